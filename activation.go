@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+)
+
+// takeStartupToken returns the activation token the launcher passed through
+// the environment and removes it, so children started by the app don't
+// reuse it. XDG_ACTIVATION_TOKEN is the Wayland variable, DESKTOP_STARTUP_ID
+// is the X11 startup-notification one some launchers still set instead.
+func takeStartupToken() string {
+	token := os.Getenv("XDG_ACTIVATION_TOKEN")
+	if token == "" {
+		token = os.Getenv("DESKTOP_STARTUP_ID")
+	}
+	os.Unsetenv("XDG_ACTIVATION_TOKEN")
+	os.Unsetenv("DESKTOP_STARTUP_ID")
+	return token
+}
+
+// mustActivate asks the compositor to focus surface, token being proof the
+// request comes from user action (a launcher, a click in another app, ...).
+func mustActivate(conn *net.UnixConn, token string, surface uint32) {
+	buf := makeMsgBuf(XDGActivationID, 2, strSize(token)+WORD_SIZE)
+	buf = appendStr(buf, token)
+	buf = binary.LittleEndian.AppendUint32(buf, surface)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// activationTokenScope is what a requested activation token is for. Each
+// field is optional, but compositors are more likely to honor the token the
+// more is set, many refuse one without the input serial.
+type activationTokenScope struct {
+	// serial is of the input event that triggered the request, on seat.
+	serial, seat uint32
+	// appID is the app_id of the client the token is passed to.
+	appID string
+	// surface is the requesting surface, which should have focus.
+	surface uint32
+}
+
+// mustRequestActivationToken asks for a token to pass to another client so
+// it can activate itself, delivered by XDGActivationTokenDone.
+func mustRequestActivationToken(conn *net.UnixConn, scope activationTokenScope) (id uint32) {
+	buf := makeMsgBuf(XDGActivationID, 1, WORD_SIZE)
+	id = regChildObj(objXDGActivationToken, XDGActivationID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	if scope.seat != 0 {
+		buf = makeMsgBuf(id, 0, WORD_SIZE*2) // set_serial
+		buf = binary.LittleEndian.AppendUint32(buf, scope.serial)
+		buf = binary.LittleEndian.AppendUint32(buf, scope.seat)
+		err = write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if scope.appID != "" {
+		buf = makeMsgBuf(id, 1, strSize(scope.appID)) // set_app_id
+		buf = appendStr(buf, scope.appID)
+		err = write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if scope.surface != 0 {
+		buf = makeMsgBuf(id, 2, WORD_SIZE) // set_surface
+		buf = binary.LittleEndian.AppendUint32(buf, scope.surface)
+		err = write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	buf = makeMsgBuf(id, 3, 0) // commit
+	err = write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func mustDestroyActivationToken(conn *net.UnixConn, id uint32) {
+	buf := makeMsgBuf(id, 4, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleXDGActivationTokenEvent(opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // done
+		token, _ := parseStr(body)
+		return XDGActivationTokenDone{Token: string(token)}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestRequestActivationTokenScope(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		opcodes []uint32
+		serial  []uint32
+		appID   string
+	)
+	conn, _ := newMockSurface(t, func(id, opcode uint32, body []byte) {
+		if objTypeOf(id) != objXDGActivationToken {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		opcodes = append(opcodes, opcode)
+		switch opcode {
+		case 0:
+			serial = []uint32{binary.LittleEndian.Uint32(body), binary.LittleEndian.Uint32(body[4:])}
+		case 1:
+			s, _ := parseStr(body)
+			appID = string(s)
+		}
+	})
+	XDGActivationID = regObj(objXDGActivation)
+
+	mustRequestActivationToken(conn, activationTokenScope{serial: 7, seat: 3, appID: "org.example.App", surface: WLSurfaceID})
+	mustRoundtrip(t, conn)
+	mu.Lock()
+	if !slices.Equal(opcodes, []uint32{0, 1, 2, 3}) {
+		t.Errorf("got requests %v, want set_serial, set_app_id, set_surface and commit", opcodes)
+	}
+	if !slices.Equal(serial, []uint32{7, 3}) || appID != "org.example.App" {
+		t.Errorf("got serial %v and app id %q", serial, appID)
+	}
+	opcodes = nil
+	mu.Unlock()
+
+	mustRequestActivationToken(conn, activationTokenScope{})
+	mustRoundtrip(t, conn)
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(opcodes, []uint32{3}) {
+		t.Errorf("got requests %v for an empty scope, want just commit", opcodes)
+	}
+}
+
+func TestActivationTokenDone(t *testing.T) {
+	body := appendStr(nil, "token-1")
+	ev, ok := handleXDGActivationTokenEvent(0, body).(XDGActivationTokenDone)
+	if !ok || ev.Token != "token-1" {
+		t.Errorf("got %#v, want the token", ev)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+)
+
+var errNoAlphaModifier = unsupportedGlobalErr("wp_alpha_modifier_v1")
+
+// alphaMultiplier maps alpha from [0, 1] onto the multiplier's [0, MaxUint32]
+// range, clamping values outside it.
+func alphaMultiplier(alpha float64) uint32 {
+	if alpha <= 0 || math.IsNaN(alpha) {
+		return 0
+	}
+	if alpha >= 1 {
+		return math.MaxUint32
+	}
+	return uint32(math.Round(alpha * math.MaxUint32))
+}
+
+// setSurfaceAlpha stages an opacity the compositor applies to the whole
+// surface on top of the buffer's own alpha, without the app re-rendering.
+func setSurfaceAlpha(alpha float64) error {
+	if WPAlphaModifierID == 0 {
+		return errNoAlphaModifier
+	}
+	pendingSurface.alpha = alphaMultiplier(alpha)
+	return nil
+}
+
+func mustSetAlphaMultiplier(conn *net.UnixConn, factor uint32) {
+	// set_multiplier on a wp_alpha_modifier_surface_v1 whose surface is gone
+	// is the no_surface error, the surface has to outlive it.
+	if WLSurfaceID == 0 {
+		panic(errors.New("wp_alpha_modifier_surface_v1: no surface"))
+	}
+	if WPAlphaModifierSurfaceID == 0 {
+		buf := makeMsgBuf(WPAlphaModifierID, 1, WORD_SIZE*2)
+		WPAlphaModifierSurfaceID = regChildObj(objWPAlphaModifierSurface, WPAlphaModifierID)
+		buf = binary.LittleEndian.AppendUint32(buf, WPAlphaModifierSurfaceID)
+		buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	buf := makeMsgBuf(WPAlphaModifierSurfaceID, 1, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, factor)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
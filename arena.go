@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"slices"
+
+	"golang.org/x/sys/unix"
+)
+
+// sharedArena hands out buffers from a single wl_shm_pool, growing it when
+// they don't fit. A pool's fd always maps from its start, so buffers share
+// the memory by being created at different offsets.
+type sharedArena struct {
+	id  uint32
+	f   *os.File
+	mem []byte
+	// free are the unused parts of the pool, sorted by offset and never
+	// adjacent.
+	free []span
+}
+
+type span struct {
+	off, size int64
+}
+
+// arenaBuffer is a buffer allocated from a sharedArena.
+type arenaBuffer struct {
+	id            uint32
+	off, size     int64
+	width, height int32
+	stride        int32
+}
+
+// arenaAlign is what buffer offsets are aligned to, a cache line.
+const arenaAlign = 64
+
+func newSharedArena(conn *net.UnixConn, size int64) (*sharedArena, error) {
+	id, f, mem, err := createPool(conn, size)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedArena{id: id, f: f, mem: mem, free: []span{{0, size}}}, nil
+}
+
+// alloc creates a width x height buffer of format in the arena, growing the
+// pool if no free part is large enough.
+func (a *sharedArena) alloc(conn *net.UnixConn, width, height int32, format shmFormat) (arenaBuffer, error) {
+	if format.bytesPerPixel() == 0 {
+		return arenaBuffer{}, errors.New("wl_shm_pool: arena can't lay out buffers of unknown format " + format.String())
+	}
+	stride := alignStride(width, format)
+	size := (int64(stride)*int64(height) + arenaAlign - 1) &^ (arenaAlign - 1)
+	i := slices.IndexFunc(a.free, func(s span) bool { return s.size >= size })
+	if i < 0 {
+		// Doubling may not fit when just the buffer would.
+		err := a.grow(conn, min(max(int64(len(a.mem))*2, int64(len(a.mem))+size), math.MaxInt32))
+		if err != nil {
+			return arenaBuffer{}, err
+		}
+		i = len(a.free) - 1
+	}
+	b := arenaBuffer{off: a.free[i].off, size: size, width: width, height: height, stride: stride}
+	var err error
+	b.id, err = createBuffer(conn, a.id, len(a.mem), shmBuffer{
+		offset: int32(b.off),
+		width:  width,
+		height: height,
+		stride: stride,
+		format: format,
+	})
+	if err != nil {
+		return arenaBuffer{}, err
+	}
+	a.free[i].off += size
+	a.free[i].size -= size
+	if a.free[i].size == 0 {
+		a.free = slices.Delete(a.free, i, i+1)
+	}
+	return b, nil
+}
+
+// pixels returns b's memory. It moves when the pool grows, so don't keep it
+// across allocs.
+func (a *sharedArena) pixels(b arenaBuffer) []byte {
+	return a.mem[b.off : b.off+b.size]
+}
+
+// release destroys b and returns its memory to the arena. The compositor
+// must be done with b, i.e. it was released or never committed.
+func (a *sharedArena) release(conn *net.UnixConn, b arenaBuffer) error {
+	err := write(conn, makeMsgBuf(b.id, 0, 0))
+	if err != nil {
+		return err
+	}
+	i, _ := slices.BinarySearchFunc(a.free, b.off, func(s span, off int64) int {
+		return int(s.off - off)
+	})
+	a.free = slices.Insert(a.free, i, span{b.off, b.size})
+	// Merge with the neighbors.
+	if i+1 < len(a.free) && a.free[i].off+a.free[i].size == a.free[i+1].off {
+		a.free[i].size += a.free[i+1].size
+		a.free = slices.Delete(a.free, i+1, i+2)
+	}
+	if i > 0 && a.free[i-1].off+a.free[i-1].size == a.free[i].off {
+		a.free[i-1].size += a.free[i].size
+		a.free = slices.Delete(a.free, i, i+1)
+	}
+	return nil
+}
+
+// grow resizes the pool to size bytes. Pools can only grow.
+func (a *sharedArena) grow(conn *net.UnixConn, size int64) error {
+	old := int64(len(a.mem))
+	if size > math.MaxInt32 {
+		return errors.New("wl_shm_pool: arena can't grow past the int32 pool size limit")
+	}
+	mem, err := resizePool(conn, a.id, a.f, a.mem, size)
+	a.mem = mem
+	if err != nil {
+		return err
+	}
+	if n := len(a.free); n > 0 && a.free[n-1].off+a.free[n-1].size == old {
+		a.free[n-1].size += size - old
+	} else {
+		a.free = append(a.free, span{old, size - old})
+	}
+	return nil
+}
+
+// destroy destroys the pool and unmaps it, the buffers still allocated stay
+// usable by the compositor until destroyed.
+func (a *sharedArena) destroy(conn *net.UnixConn) error {
+	err := write(conn, makeMsgBuf(a.id, 1, 0))
+	unix.Munmap(a.mem)
+	a.f.Close()
+	a.mem = nil
+	return err
+}
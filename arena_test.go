@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSharedArena(t *testing.T) {
+	resetTestState(t)
+	conn, _ := startMockCompositor(t, nil)
+	WLShmID = regObjVersion(objWLShm, 1)
+	a, err := newSharedArena(conn, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.destroy(conn) })
+
+	// 16x16 ARGB8888 is 1024 bytes, already aligned.
+	alloc := func(width, height int32) arenaBuffer {
+		t.Helper()
+		b, err := a.alloc(conn, width, height, WLShmFormatARGB8888)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(a.pixels(b))) != b.size {
+			t.Fatalf("got %d bytes of pixels, want %d", len(a.pixels(b)), b.size)
+		}
+		return b
+	}
+	first, second := alloc(16, 16), alloc(16, 16)
+	if first.off != 0 || second.off != 1024 {
+		t.Fatalf("got offsets %d and %d, want 0 and 1024", first.off, second.off)
+	}
+	err = a.release(conn, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused := alloc(16, 16); reused.off != first.off {
+		t.Errorf("got offset %d after a release, want the released %d", reused.off, first.off)
+	} else {
+		first = reused
+	}
+
+	// Doesn't fit in what's left, the pool grows to fit it after second.
+	big := alloc(64, 64)
+	if big.off != 2048 || len(a.mem) < 2048+64*64*4 {
+		t.Errorf("got offset %d in a pool of %d, want 2048 in one of at least %d", big.off, len(a.mem), 2048+64*64*4)
+	}
+
+	for _, b := range []arenaBuffer{second, big, first} {
+		err = a.release(conn, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []span{{0, int64(len(a.mem))}}; !slices.Equal(a.free, want) {
+		t.Errorf("got free spans %v after releasing everything, want %v", a.free, want)
+	}
+
+	_, err = a.alloc(conn, 16, 16, shmFormat('?'))
+	if err == nil {
+		t.Error("allocated a buffer of a format of unknown size")
+	}
+}
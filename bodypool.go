@@ -0,0 +1,55 @@
+package main
+
+import "sync"
+
+// Message bodies are read into buffers kept for reuse, so a stream of small
+// events like pointer motion doesn't allocate a body each. A body returned by
+// read is borrowed: it stays valid until passed to releaseBody, after which
+// it's overwritten by a later message. The loops reading events release each
+// body once handleEvent returns, so handleEvent and the eventHandlers it
+// calls must copy out whatever they keep, the decoded events do. A body never
+// released is just left to the garbage collector.
+
+var (
+	// bodyPoolSize is how many released bodies are kept for reuse, 0
+	// disables reuse.
+	bodyPoolSize = 64
+	// bodyBufSize is the capacity of pooled bodies, larger messages get a
+	// body of their own that isn't kept. It fits every core event but the
+	// odd long string or array.
+	bodyBufSize = 256
+)
+
+var (
+	bodyPoolMu sync.Mutex
+	bodyPool   [][]byte
+)
+
+// getBody returns an n bytes body, reused if possible.
+func getBody(n int) []byte {
+	if n > bodyBufSize {
+		return make([]byte, n)
+	}
+	bodyPoolMu.Lock()
+	defer bodyPoolMu.Unlock()
+	if len(bodyPool) == 0 {
+		return make([]byte, n, bodyBufSize)
+	}
+	b := bodyPool[len(bodyPool)-1]
+	bodyPool[len(bodyPool)-1] = nil
+	bodyPool = bodyPool[:len(bodyPool)-1]
+	return b[:n]
+}
+
+// releaseBody gives back a body returned by read once done with it, it
+// mustn't be used after.
+func releaseBody(b []byte) {
+	if cap(b) != bodyBufSize {
+		return
+	}
+	bodyPoolMu.Lock()
+	defer bodyPoolMu.Unlock()
+	if len(bodyPool) < bodyPoolSize {
+		bodyPool = append(bodyPool, b)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+func TestBodyReuse(t *testing.T) {
+	b := getBody(12)
+	if len(b) != 12 || cap(b) != bodyBufSize {
+		t.Fatalf("got a body of len %d cap %d", len(b), cap(b))
+	}
+	releaseBody(b)
+	if again := getBody(20); &again[:1][0] != &b[:1][0] {
+		t.Error("released body not reused")
+	}
+	if big := getBody(bodyBufSize + 1); cap(big) == bodyBufSize {
+		t.Error("body larger than bodyBufSize came from the pool")
+	}
+}
+
+// BenchmarkRead reads and handles wl_pointer motion events, with and
+// without reusing bodies.
+func BenchmarkRead(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		pool int
+	}{
+		{"pooled", 64},
+		{"unpooled", 0},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			saved := bodyPoolSize
+			bodyPoolSize = bb.pool
+			defer func() { bodyPoolSize = saved }()
+			benchmarkRead(b)
+		})
+	}
+}
+
+func benchmarkRead(b *testing.B) {
+	resetTestState(b)
+	conn, server := newConnPair(b)
+	WLPointerID = regObj(objWLPointer)
+	const batch = 64
+	var msgs []byte
+	for i := range batch {
+		msgs = binary.LittleEndian.AppendUint32(msgs, WLPointerID)
+		msgs = binary.LittleEndian.AppendUint32(msgs, 20<<16|2) // motion
+		msgs = binary.LittleEndian.AppendUint32(msgs, uint32(i))
+		msgs = binary.LittleEndian.AppendUint32(msgs, toFixed(float64(i)))
+		msgs = binary.LittleEndian.AppendUint32(msgs, toFixed(float64(i)))
+	}
+	go func() {
+		for sent := 0; sent < b.N; sent += batch {
+			_, err := server.Write(msgs)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		id, opcode, body, err := read(conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = handleEvent(ctx, conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
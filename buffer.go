@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+type latencyModeType uint8
+
+const (
+	// latencyDefault draws whenever a frame callback says so, even if the
+	// compositor still holds the last buffer.
+	latencyDefault latencyModeType = iota
+	// latencyOneFrame holds off drawing until the last committed buffer is
+	// released, so at most one frame is ever in flight. That bounds latency
+	// and spares the app from juggling buffers at the cost of throughput.
+	latencyOneFrame
+)
+
+var latencyMode = latencyDefault
+
+// bufferAttached is set by mustAttach until the commit that applies it.
+var bufferAttached bool
+
+var (
+	bufferMu sync.Mutex
+	// bufferReleased is closed when the last committed buffer is released,
+	// nil if no buffer is held by the compositor.
+	bufferReleased chan struct{}
+	// busyBuffer is the buffer bufferReleased waits on. The dispatcher
+	// goroutine checks releases against it rather than WLBufferID, which
+	// the app may be replacing.
+	busyBuffer uint32
+)
+
+// markBufferBusy records that id was committed and is held by the
+// compositor until released.
+func markBufferBusy(id uint32) {
+	bufferMu.Lock()
+	if bufferReleased == nil {
+		bufferReleased = make(chan struct{})
+	}
+	busyBuffer = id
+	bufferMu.Unlock()
+}
+
+func markBufferReleased() {
+	bufferMu.Lock()
+	markBufferReleasedLocked()
+	bufferMu.Unlock()
+}
+
+func markBufferReleasedLocked() {
+	if bufferReleased != nil {
+		close(bufferReleased)
+		bufferReleased = nil
+	}
+	busyBuffer = 0
+}
+
+// retiredBuffers are the buffers replaced while the compositor still held
+// them, each freed by its func once released. Guarded by bufferMu.
+var retiredBuffers = map[uint32]func(conn *net.UnixConn) error{}
+
+// retireBuffer gives up WLBufferID and the pool it's in. The pool is
+// destroyed and unmapped right away, the compositor keeps its own mapping
+// for as long as the buffer lives. The buffer itself is destroyed now if
+// the compositor isn't holding it, else on its release, so its contents
+// stay intact until then. A buffer of windowArena goes back to it the same
+// way, the arena isn't handing out memory the compositor may be sampling.
+func retireBuffer(conn *net.UnixConn) {
+	id := WLBufferID
+	free := func(conn *net.UnixConn) error {
+		return write(conn, makeMsgBuf(id, 0, 0))
+	}
+	if windowArena != nil {
+		a, b := windowArena, windowArenaBuf
+		free = func(conn *net.UnixConn) error {
+			return a.release(conn, b)
+		}
+		if poolStrategy != poolHighWater || !interactiveResize() {
+			// The gesture is over, the buffers still held are destroyed as
+			// they're released.
+			err := a.destroy(conn)
+			if err != nil {
+				panic(err)
+			}
+			windowArena = nil
+		}
+	} else {
+		err := write(conn, makeMsgBuf(WLShmPoolID, 1, 0))
+		if err != nil {
+			panic(err)
+		}
+		err = unix.Munmap(WLShmPoolBuf)
+		if err != nil {
+			panic(err)
+		}
+		WLShmPoolFile.Close()
+	}
+	WLShmPoolID, WLShmPoolFile, WLShmPoolBuf = 0, nil, nil
+	if bufferBusy() {
+		bufferMu.Lock()
+		retiredBuffers[id] = free
+		bufferMu.Unlock()
+		return
+	}
+	err := free(conn)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// handleBufferRelease handles the release of buffer id: a retired one is freed,
+// and the committed one is no longer busy. It reports whether id was retired.
+func handleBufferRelease(conn *net.UnixConn, id uint32) (retired bool, err error) {
+	bufferMu.Lock()
+	free, retired := retiredBuffers[id]
+	delete(retiredBuffers, id)
+	if !retired && id == busyBuffer {
+		markBufferReleasedLocked()
+	}
+	bufferMu.Unlock()
+	if retired {
+		return true, free(conn)
+	}
+	return false, nil
+}
+
+func bufferBusy() bool {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+	return bufferReleased != nil
+}
+
+// awaitBufferRelease returns a channel closed once the compositor releases the
+// last committed buffer. Goroutines drawing next to startDispatcher can
+// receive from it before touching the buffer again, it's already closed if no
+// buffer is held.
+func awaitBufferRelease() <-chan struct{} {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+	if bufferReleased == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return bufferReleased
+}
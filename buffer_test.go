@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// requestLog records the id and opcode of every request a mockCompositor
+// gets.
+type requestLog struct {
+	mu   sync.Mutex
+	reqs [][2]uint32
+}
+
+func (l *requestLog) record(id, opcode uint32, body []byte) {
+	l.mu.Lock()
+	l.reqs = append(l.reqs, [2]uint32{id, opcode})
+	l.mu.Unlock()
+}
+
+func (l *requestLog) has(id, opcode uint32) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Contains(l.reqs, [2]uint32{id, opcode})
+}
+
+func mustRoundtrip(t *testing.T, conn *net.UnixConn) {
+	t.Helper()
+	err := roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResizeKeepsHeldBufferUntilRelease(t *testing.T) {
+	var log requestLog
+	conn, m := newMockSurface(t, log.record)
+	m.holdBuffers.Store(true)
+	old := WLBufferID
+	mustDraw(conn)
+	mustRoundtrip(t, conn)
+
+	mustResizeBuffer(conn, 2*winWidth, 2*winHeight)
+	mustRoundtrip(t, conn)
+	if log.has(old, 0) {
+		t.Fatal("buffer destroyed while the compositor held it")
+	}
+
+	mustDraw(conn)
+	mustRoundtrip(t, conn)
+	mustRoundtrip(t, conn)
+	if !log.has(old, 0) {
+		t.Error("buffer not destroyed after its release")
+	}
+	bufferMu.Lock()
+	retired := len(retiredBuffers)
+	bufferMu.Unlock()
+	if retired != 0 {
+		t.Errorf("%d buffers still retired", retired)
+	}
+	if !bufferBusy() {
+		t.Error("the old buffer's release marked the new one released")
+	}
+}
+
+func TestResizeDestroysIdleBuffer(t *testing.T) {
+	var log requestLog
+	conn, _ := newMockSurface(t, log.record)
+	old := WLBufferID
+	mustResizeBuffer(conn, 2*winWidth, 2*winHeight)
+	mustRoundtrip(t, conn)
+	if !log.has(old, 0) {
+		t.Error("buffer never committed wasn't destroyed right away")
+	}
+}
+
+func TestBufferReleaseFreeError(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	conn, _ := startMockCompositor(t, nil)
+	id := regObj(objWLBuffer)
+	errFree := errors.New("free failed")
+	bufferMu.Lock()
+	retiredBuffers[id] = func(*net.UnixConn) error { return errFree }
+	bufferMu.Unlock()
+	ev, err := handleEvent(context.Background(), conn, id, 0, nil)
+	if !errors.Is(err, errFree) || ev != nil {
+		t.Errorf("release of a retired buffer failing to free got %v, %v, want %v", ev, err, errFree)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// wp_color_manager_v1::render_intent
+const (
+	WPColorManagerRenderIntentPerceptual = iota
+	WPColorManagerRenderIntentRelative
+	WPColorManagerRenderIntentSaturation
+	WPColorManagerRenderIntentAbsolute
+	WPColorManagerRenderIntentRelativeBPC
+)
+
+// wp_color_manager_v1::feature
+const (
+	WPColorManagerFeatureICCV2V4 = iota
+	WPColorManagerFeatureParametric
+	WPColorManagerFeatureSetPrimaries
+	WPColorManagerFeatureSetTFPower
+	WPColorManagerFeatureSetLuminances
+	WPColorManagerFeatureSetMasteringDisplayPrimaries
+	WPColorManagerFeatureExtendedTargetVolume
+	WPColorManagerFeatureWindowsSCRGB
+)
+
+// wp_color_manager_v1::primaries
+const (
+	WPColorManagerPrimariesSRGB = iota + 1
+	WPColorManagerPrimariesPALM
+	WPColorManagerPrimariesPAL
+	WPColorManagerPrimariesNTSC
+	WPColorManagerPrimariesGenericFilm
+	WPColorManagerPrimariesBT2020
+	WPColorManagerPrimariesCIE1931XYZ
+	WPColorManagerPrimariesDCIP3
+	WPColorManagerPrimariesDisplayP3
+	WPColorManagerPrimariesAdobeRGB
+)
+
+// wp_color_manager_v1::transfer_function
+const (
+	WPColorManagerTransferFunctionBT1886 = iota + 1
+	WPColorManagerTransferFunctionGamma22
+	WPColorManagerTransferFunctionGamma28
+	WPColorManagerTransferFunctionST240
+	WPColorManagerTransferFunctionExtLinear
+	WPColorManagerTransferFunctionLog100
+	WPColorManagerTransferFunctionLog316
+	WPColorManagerTransferFunctionXVYCC
+	WPColorManagerTransferFunctionSRGB
+	WPColorManagerTransferFunctionExtSRGB
+	WPColorManagerTransferFunctionST2084PQ
+	WPColorManagerTransferFunctionST428
+	WPColorManagerTransferFunctionHLG
+)
+
+// colorMu guards the color management state below, which the dispatcher
+// goroutine updates as the compositor's events come in.
+var colorMu sync.Mutex
+
+// What the compositor advertised right after binding wp_color_manager_v1.
+var (
+	colorIntents    = map[uint32]bool{}
+	colorFeatures   = map[uint32]bool{}
+	colorTFs        = map[uint32]bool{}
+	colorPrimaries  = map[uint32]bool{}
+	colorAdvertised bool
+)
+
+var errColorUnsupported = errors.New("wp_color_manager_v1: not supported by the compositor")
+
+func handleWPColorManagerEvent(opcode uint32, body []byte) {
+	colorMu.Lock()
+	defer colorMu.Unlock()
+	switch opcode {
+	case 0: // supported_intent
+		colorIntents[binary.LittleEndian.Uint32(body)] = true
+	case 1: // supported_feature
+		colorFeatures[binary.LittleEndian.Uint32(body)] = true
+	case 2: // supported_tf_named
+		colorTFs[binary.LittleEndian.Uint32(body)] = true
+	case 3: // supported_primaries_named
+		colorPrimaries[binary.LittleEndian.Uint32(body)] = true
+	case 4: // done
+		colorAdvertised = true
+	}
+}
+
+// imageDescription is the state of a wp_image_description_v1, which can only
+// be used once ready.
+type imageDescription struct {
+	ready, failed bool
+}
+
+var imageDescriptions = map[uint32]*imageDescription{}
+
+// createParametricImageDescription creates an image description from a named
+// transfer function and primaries, e.g. for HDR10 the PQ transfer function
+// with BT.2020 primaries.
+func createParametricImageDescription(conn *net.UnixConn, tf, primaries uint32) (id uint32, err error) {
+	colorMu.Lock()
+	ok := colorFeatures[WPColorManagerFeatureParametric] && colorTFs[tf] && colorPrimaries[primaries]
+	colorMu.Unlock()
+	if WPColorManagerID == 0 || !ok {
+		return 0, errColorUnsupported
+	}
+	creator := regChildObj(objWPImageDescriptionCreatorParams, WPColorManagerID)
+	buf := makeMsgBuf(WPColorManagerID, 5, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, creator)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = makeMsgBuf(creator, 1, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, tf)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = makeMsgBuf(creator, 3, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, primaries)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	return createImageDescription(conn, creator)
+}
+
+// createICCImageDescription creates an image description from an ICC v2 or
+// v4 profile.
+func createICCImageDescription(conn *net.UnixConn, icc []byte) (id uint32, err error) {
+	colorMu.Lock()
+	ok := colorFeatures[WPColorManagerFeatureICCV2V4]
+	colorMu.Unlock()
+	if WPColorManagerID == 0 || !ok {
+		return 0, errColorUnsupported
+	}
+	f, err := writeTempFile("wp_image_description_icc", icc)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	creator := regChildObj(objWPImageDescriptionCreatorICC, WPColorManagerID)
+	buf := makeMsgBuf(WPColorManagerID, 4, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, creator)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = makeMsgBuf(creator, 1, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(icc)))
+	err = writeFD(conn, buf, int(f.Fd()))
+	if err != nil {
+		return 0, err
+	}
+	return createImageDescription(conn, creator)
+}
+
+// createImageDescription sends create, which also destroys creator. The
+// description is tracked before it's sent, the dispatcher may handle its
+// ready event before write returns.
+func createImageDescription(conn *net.UnixConn, creator uint32) (id uint32, err error) {
+	id = regChildObj(objWPImageDescription, creator)
+	colorMu.Lock()
+	imageDescriptions[id] = &imageDescription{}
+	colorMu.Unlock()
+	buf := makeMsgBuf(creator, 0, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// pendingColorProfile is the image description setColorProfile is waiting on
+// to become ready, and the intent to use it with.
+var pendingColorProfile struct {
+	desc, intent uint32
+}
+
+// setColorProfile tags WLSurfaceID's content with the image description desc.
+// An image description can only be used once ready, if it isn't yet it's set
+// when it becomes so. Like all surface state it applies on the next commit.
+func setColorProfile(conn *net.UnixConn, desc, intent uint32) error {
+	colorMu.Lock()
+	defer colorMu.Unlock()
+	return setColorProfileLocked(conn, desc, intent)
+}
+
+// setColorProfileLocked is setColorProfile with colorMu held.
+func setColorProfileLocked(conn *net.UnixConn, desc, intent uint32) error {
+	d, ok := imageDescriptions[desc]
+	if !ok || d.failed {
+		return errors.New("wp_image_description_v1: unknown or failed image description")
+	}
+	if !colorIntents[intent] {
+		return errColorUnsupported
+	}
+	if WPColorManagementSurfaceID == 0 {
+		WPColorManagementSurfaceID = regChildObj(objWPColorManagementSurface, WPColorManagerID)
+		buf := makeMsgBuf(WPColorManagerID, 2, WORD_SIZE*2)
+		buf = binary.LittleEndian.AppendUint32(buf, WPColorManagementSurfaceID)
+		buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+		err := write(conn, buf)
+		if err != nil {
+			return err
+		}
+	}
+	if !d.ready {
+		pendingColorProfile.desc, pendingColorProfile.intent = desc, intent
+		return nil
+	}
+	pendingColorProfile.desc = 0
+	buf := makeMsgBuf(WPColorManagementSurfaceID, 1, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, desc)
+	buf = binary.LittleEndian.AppendUint32(buf, intent)
+	return write(conn, buf)
+}
+
+func mustDestroyImageDescription(conn *net.UnixConn, id uint32) {
+	colorMu.Lock()
+	delete(imageDescriptions, id)
+	colorMu.Unlock()
+	buf := makeMsgBuf(id, 0, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleWPImageDescriptionEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	colorMu.Lock()
+	defer colorMu.Unlock()
+	d, ok := imageDescriptions[id]
+	if !ok {
+		return nil
+	}
+	switch opcode {
+	case 0: // failed
+		d.failed = true
+		if pendingColorProfile.desc == id {
+			pendingColorProfile.desc = 0
+		}
+		msg, _ := parseStr(body[4:])
+		return WPImageDescriptionFailed{ImageDescription: id, Cause: binary.LittleEndian.Uint32(body), Msg: string(msg)}
+	case 1: // ready
+		d.ready = true
+		if pendingColorProfile.desc == id {
+			err := setColorProfileLocked(conn, id, pendingColorProfile.intent)
+			if err != nil {
+				panic(err)
+			}
+		}
+		return WPImageDescriptionReady{ImageDescription: id, Identity: binary.LittleEndian.Uint32(body)}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// connect connects to the compositor the environment points at: the socket
+// path in WAYLAND_SOCKET if set, otherwise the WAYLAND_DISPLAY socket name,
+// wayland-0 by default, in XDG_RUNTIME_DIR.
+func connect() (*net.UnixConn, error) {
+	socketPath, err := envSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	return connectPath(socketPath)
+}
+
+// connectContext connects like connect and does the registry roundtrip, all
+// bounded by ctx: if it's done before the globals are bound, the connection
+// is closed and ctx's error returned.
+func connectContext(ctx context.Context) (*net.UnixConn, error) {
+	socketPath, err := envSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connectPathContext(ctx, socketPath)
+	if err != nil {
+		return nil, err
+	}
+	// Reads don't watch ctx, a past deadline unblocks them when it's done.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	mustGetReg(conn)
+	err = roundtrip(ctx, conn)
+	switch {
+	case !stop() || ctx.Err() != nil:
+		err = errors.Join(ctx.Err(), err)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// ctx's deadline, hit by the read just before ctx is done.
+		err = errors.Join(context.DeadlineExceeded, err)
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		resetObjects()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func envSocketPath() (string, error) {
+	if socketPath := os.Getenv("WAYLAND_SOCKET"); socketPath != "" {
+		return socketPath, nil
+	}
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if isAbstractSocket(name) {
+		return name, nil
+	}
+	if os.Getenv("XDG_RUNTIME_DIR") == "" {
+		return "", errors.New("wayland env vars not set, neither WAYLAND_SOCKET nor XDG_RUNTIME_DIR is set")
+	}
+	if name == "" {
+		name = "wayland-0"
+	}
+	return socketNamePath(name)
+}
+
+// connectToName connects to the compositor listening on the socket name, e.g.
+// "wayland-1" for a nested compositor, resolved against XDG_RUNTIME_DIR.
+// Absolute names are used as is, like for WAYLAND_DISPLAY, and so are names
+// starting with @, which are in the abstract namespace.
+func connectToName(name string) (*net.UnixConn, error) {
+	socketPath, err := socketNamePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return connectPath(socketPath)
+}
+
+// discoverSockets returns the names of the compositor sockets in
+// XDG_RUNTIME_DIR that accept connections, wayland-0 and the like, for tools
+// to offer a choice between a session compositor and nested ones. Files that
+// aren't sockets, like the .lock files next to them, and sockets left behind
+// by a compositor that died are skipped. The names can be passed to
+// connectToName.
+func discoverSockets() ([]string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return nil, errors.New("XDG_RUNTIME_DIR is not set, can't look for wayland sockets")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "wayland-") || e.Type()&fs.ModeSocket == 0 {
+			continue
+		}
+		conn, err := connectPath(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		conn.Close()
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func socketNamePath(name string) (string, error) {
+	if filepath.IsAbs(name) || isAbstractSocket(name) {
+		return name, nil
+	}
+	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if xdgRuntimeDir == "" {
+		return "", errors.New("XDG_RUNTIME_DIR is not set, can't resolve wayland socket " + name)
+	}
+	err := checkRuntimeDir(xdgRuntimeDir)
+	if err != nil {
+		switch runtimeDirStrictness {
+		case runtimeDirFail:
+			return "", err
+		case runtimeDirWarn:
+			slog.Warn(err.Error())
+		}
+	}
+	return filepath.Join(xdgRuntimeDir, name), nil
+}
+
+// isAbstractSocket reports whether the socket name is in Linux's abstract
+// namespace, which has no file, written with a leading @ for the leading NUL
+// of the actual address. net.UnixAddr uses the same convention, so the name
+// is dialed as is.
+func isAbstractSocket(name string) bool {
+	return len(name) > 1 && name[0] == '@'
+}
+
+// connectPath connects to the compositor socket at the literal path, or the
+// abstract one for a path starting with @.
+func connectPath(socketPath string) (*net.UnixConn, error) {
+	return connectPathContext(context.Background(), socketPath)
+}
+
+// connectPathContext is connectPath with the dial bounded by ctx.
+func connectPathContext(ctx context.Context, socketPath string) (*net.UnixConn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UnixConn)
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+type runtimeDirStrictnessType uint8
+
+const (
+	runtimeDirIgnore runtimeDirStrictnessType = iota
+	// runtimeDirWarn logs a failed checkRuntimeDir and connects anyway.
+	runtimeDirWarn
+	// runtimeDirFail refuses to connect when checkRuntimeDir fails.
+	runtimeDirFail
+)
+
+// runtimeDirStrictness is what connectToName does when XDG_RUNTIME_DIR fails
+// checkRuntimeDir.
+var runtimeDirStrictness = runtimeDirWarn
+
+// checkRuntimeDir checks dir is a directory owned by the user that nobody
+// else can access, as the XDG base directory spec requires of
+// XDG_RUNTIME_DIR. Otherwise other users may be able to reach or replace
+// the compositor's socket.
+func checkRuntimeDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return errors.New("XDG_RUNTIME_DIR: " + err.Error())
+	}
+	if !fi.IsDir() {
+		return errors.New("XDG_RUNTIME_DIR " + dir + " is not a directory")
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return errors.New("XDG_RUNTIME_DIR " + dir + " is owned by uid " + strconv.Itoa(int(st.Uid)) + ", not the user")
+	}
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return errors.New("XDG_RUNTIME_DIR " + dir + " has mode " + strconv.FormatUint(uint64(perm), 8) + ", others can access it, it should be 0700")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscoverSockets(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	listen := func(name string) *net.UnixListener {
+		t.Helper()
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, name), Net: "unix"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	listen("wayland-0")
+	listen("wayland-nested")
+	listen("pulse")
+	// Left behind by a compositor that died.
+	stale := listen("wayland-1")
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	err := os.WriteFile(filepath.Join(dir, "wayland-0.lock"), nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := discoverSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	if want := []string{"wayland-0", "wayland-nested"}; !slices.Equal(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	_, err = discoverSockets()
+	if err == nil {
+		t.Error("no error without XDG_RUNTIME_DIR")
+	}
+}
+
+func TestConnectAbstractSocket(t *testing.T) {
+	name := "@golang-wayland-test-" + strconv.Itoa(os.Getpid())
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	t.Setenv("WAYLAND_SOCKET", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("WAYLAND_DISPLAY", name)
+
+	path, err := envSocketPath()
+	if err != nil || path != name {
+		t.Fatalf("got %q, %v from WAYLAND_DISPLAY, want %q", path, err, name)
+	}
+	conn, err := connectToName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if isAbstractSocket("@") || isAbstractSocket("wayland-0") {
+		t.Error("a lone @ or a plain name taken as abstract")
+	}
+}
+
+func TestCheckRuntimeDir(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// mode is the temp dir's, 0 for a file instead.
+		mode os.FileMode
+		ok   bool
+	}{
+		{"0700", 0o700, true},
+		{"0500", 0o500, true},
+		{"group readable", 0o750, false},
+		{"world accessible", 0o701, false},
+		{"0777", 0o777, false},
+		{"a file", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "runtime")
+			var err error
+			if tt.mode == 0 {
+				err = os.WriteFile(dir, nil, 0o600)
+			} else {
+				err = os.Mkdir(dir, 0o700)
+				if err == nil {
+					// Mkdir's mode is masked by the umask.
+					err = os.Chmod(dir, tt.mode)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkRuntimeDir(dir)
+			if tt.ok != (err == nil) {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+	if err := checkRuntimeDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing dir passed")
+	}
+}
+
+func TestRuntimeDirStrictness(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		strictness runtimeDirStrictnessType
+		fail, warn bool
+	}{
+		{"ignore", runtimeDirIgnore, false, false},
+		{"warn", runtimeDirWarn, false, true},
+		{"fail", runtimeDirFail, true, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Chmod(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("XDG_RUNTIME_DIR", dir)
+			saved := runtimeDirStrictness
+			runtimeDirStrictness = tt.strictness
+			t.Cleanup(func() { runtimeDirStrictness = saved })
+			logs := captureLogs(t)
+
+			path, err := socketNamePath("wayland-0")
+			if tt.fail != (err != nil) {
+				t.Fatalf("got %q, %v, want failing %v", path, err, tt.fail)
+			}
+			if !tt.fail && path != filepath.Join(dir, "wayland-0") {
+				t.Errorf("got %q, want wayland-0 in %s", path, dir)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != tt.warn {
+				t.Errorf("warned %v, want %v: %q", warned, tt.warn, logs)
+			}
+		})
+	}
+}
+
+func TestConnectContextSilentCompositor(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{"deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, context.DeadlineExceeded},
+		{"canceled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, context.Canceled},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			name := "@golang-wayland-test-silent-" + strconv.Itoa(os.Getpid())
+			l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			t.Setenv("WAYLAND_SOCKET", "")
+			t.Setenv("WAYLAND_DISPLAY", name)
+			// The compositor reads the requests and never answers, its read
+			// returns once the client hangs up.
+			hungUp := make(chan error, 1)
+			go func() {
+				c, err := l.AcceptUnix()
+				if err != nil {
+					hungUp <- err
+					return
+				}
+				defer c.Close()
+				c.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, err = io.Copy(io.Discard, c)
+				hungUp <- err
+			}()
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			conn, err := connectContext(ctx)
+			if !errors.Is(err, tt.want) || conn != nil {
+				t.Fatalf("got %v, %v, want %v", conn, err, tt.want)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("returned after %v", d)
+			}
+			if err := <-hungUp; err != nil {
+				t.Errorf("connection left open: %v", err)
+			}
+			if live := stats().LiveObjects; WLRegistryID != 0 || live != 1 {
+				t.Errorf("registry %d and %d objects left, want only the display", WLRegistryID, live)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+type rect struct {
+	x, y, w, h int32
+}
+
+func (r rect) union(o rect) rect {
+	x0, y0 := min(r.x, o.x), min(r.y, o.y)
+	x1, y1 := max(r.x+r.w, o.x+o.w), max(r.y+r.h, o.y+o.h)
+	return rect{x0, y0, x1 - x0, y1 - y0}
+}
+
+// touches reports whether r and o overlap or share an edge.
+func (r rect) touches(o rect) bool {
+	return r.x <= o.x+o.w && o.x <= r.x+r.w && r.y <= o.y+o.h && o.y <= r.y+r.h
+}
+
+type damageStrategyType uint8
+
+const (
+	// damageAsIs sends every rect as it was added.
+	damageAsIs damageStrategyType = iota
+	// damageMerge merges overlapping and adjacent rects, falling back to
+	// their bounding box past damageMaxRects.
+	damageMerge
+	// damageBoundingBox sends a single rect covering all the damage.
+	damageBoundingBox
+)
+
+var (
+	damageStrategy = damageMerge
+	damageMaxRects = 16
+)
+
+// pendingDamage is the damage, in buffer coordinates, to send with the next
+// commit.
+var pendingDamage []rect
+
+// addDamage marks a region of the buffer as changed for the next commit.
+// Surfaces older than damage_buffer get it converted to surface coordinates
+// with the staged transform and scale.
+func addDamage(x, y, w, h int32) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	if surfaceVersion() < 4 {
+		pendingSurfaceDamage = append(pendingSurfaceDamage, pendingSurface.toSurface(rect{x, y, w, h}))
+		return
+	}
+	pendingDamage = append(pendingDamage, rect{x, y, w, h})
+}
+
+// addSurfaceDamage marks a region in surface coordinates as changed for the
+// next commit, converting it to buffer coordinates with the staged transform
+// and scale. Damage added before changing them isn't converted again.
+func addSurfaceDamage(x, y, w, h int32) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	if surfaceVersion() < 4 {
+		pendingSurfaceDamage = append(pendingSurfaceDamage, rect{x, y, w, h})
+		return
+	}
+	r := pendingSurface.toBuffer(rect{x, y, w, h})
+	addDamage(r.x, r.y, r.w, r.h)
+}
+
+// toBuffer converts r from surface to buffer coordinates under s's
+// transform and scale, the inverse of what the compositor does to display
+// the buffer.
+func (s surfaceState) toBuffer(r rect) rect {
+	sw, sh := s.size()
+	x0, y0 := s.pointToBuffer(r.x, r.y, int32(sw), int32(sh))
+	x1, y1 := s.pointToBuffer(r.x+r.w, r.y+r.h, int32(sw), int32(sh))
+	return rect{min(x0, x1), min(y0, y1), max(x0, x1) - min(x0, x1), max(y0, y1) - min(y0, y1)}
+}
+
+// pointToBuffer converts the surface point x, y on a w x h surface to buffer
+// coordinates.
+func (s surfaceState) pointToBuffer(x, y, w, h int32) (bx, by int32) {
+	switch s.transform {
+	case WLOutputTransformNormal:
+		bx, by = x, y
+	case WLOutputTransform90:
+		bx, by = y, w-x
+	case WLOutputTransform180:
+		bx, by = w-x, h-y
+	case WLOutputTransform270:
+		bx, by = h-y, x
+	case WLOutputTransformFlipped:
+		bx, by = w-x, y
+	case WLOutputTransformFlipped90:
+		bx, by = y, x
+	case WLOutputTransformFlipped180:
+		bx, by = x, h-y
+	case WLOutputTransformFlipped270:
+		bx, by = h-y, w-x
+	}
+	return bx * s.scale, by * s.scale
+}
+
+// toSurface converts r from buffer to surface coordinates under s's
+// transform and scale, the inverse of toBuffer. A buffer rect not on the
+// scale's grid is rounded out to the surface pixels it touches.
+func (s surfaceState) toSurface(r rect) rect {
+	sw, sh := s.size()
+	x0, y0 := s.pointToSurface(r.x/s.scale, r.y/s.scale, int32(sw), int32(sh))
+	x1, y1 := s.pointToSurface((r.x+r.w+s.scale-1)/s.scale, (r.y+r.h+s.scale-1)/s.scale, int32(sw), int32(sh))
+	return rect{min(x0, x1), min(y0, y1), max(x0, x1) - min(x0, x1), max(y0, y1) - min(y0, y1)}
+}
+
+// pointToSurface converts the point bx, by of the buffer, divided by the
+// scale already, to coordinates on a w x h surface.
+func (s surfaceState) pointToSurface(bx, by, w, h int32) (x, y int32) {
+	switch s.transform {
+	case WLOutputTransformNormal:
+		x, y = bx, by
+	case WLOutputTransform90:
+		x, y = w-by, bx
+	case WLOutputTransform180:
+		x, y = w-bx, h-by
+	case WLOutputTransform270:
+		x, y = by, h-bx
+	case WLOutputTransformFlipped:
+		x, y = w-bx, by
+	case WLOutputTransformFlipped90:
+		x, y = by, bx
+	case WLOutputTransformFlipped180:
+		x, y = bx, h-by
+	case WLOutputTransformFlipped270:
+		x, y = w-by, h-bx
+	}
+	return x, y
+}
+
+// damageSurface marks the whole buffer as changed for the next commit.
+func damageSurface() {
+	r, bufferCoords := fullDamage()
+	if !bufferCoords {
+		pendingSurfaceDamage = append(pendingSurfaceDamage, r)
+		return
+	}
+	addDamage(r.x, r.y, r.w, r.h)
+}
+
+// pendingSurfaceDamage is the damage, in surface coordinates, to send with
+// wl_surface::damage for surfaces older than damage_buffer.
+var pendingSurfaceDamage []rect
+
+// fullDamage returns the rect covering the whole surface for the next
+// commit, in buffer coordinates if WLSurfaceID has damage_buffer (version 4),
+// else in surface coordinates: the buffer size divided by the scale, e.g.
+// 50x50 for a 100x100 buffer at scale 2.
+func fullDamage() (r rect, bufferCoords bool) {
+	if surfaceVersion() >= 4 {
+		return rect{0, 0, int32(bufWidth), int32(bufHeight)}, true
+	}
+	w, h := pendingSurface.size()
+	return rect{0, 0, int32(w), int32(h)}, false
+}
+
+// diffDamage makes mustDraw damage only the tiles of the buffer that differ
+// from the last frame, instead of all of it. It costs a copy of the buffer
+// and comparing the two every frame.
+var diffDamage bool
+
+const (
+	diffTileSize = 32
+	// diffMaxTiles is how many changed tiles are looked for before giving
+	// up and damaging everything, past it the rects wouldn't save much.
+	diffMaxTiles = 64
+)
+
+// diffPrev is the buffer contents at the last addDiffDamage.
+var diffPrev []byte
+
+// addDiffDamage damages the tiles of buf, a 4 bytes per pixel buffer, that
+// changed since the last call and keeps a copy of it for the next. Changed
+// tiles next to each other on a row of tiles are added as one rect.
+func addDiffDamage(buf []byte, width, height, stride int32) {
+	defer func() {
+		diffPrev = append(diffPrev[:0], buf...)
+	}()
+	if len(diffPrev) != len(buf) {
+		addDamage(0, 0, width, height)
+		return
+	}
+	var found []rect
+	tiles := 0
+	for ty := int32(0); ty < height; ty += diffTileSize {
+		th := min(diffTileSize, height-ty)
+		run := rect{}
+		for tx := int32(0); tx < width; tx += diffTileSize {
+			tw := min(diffTileSize, width-tx)
+			if !tileChanged(buf, tx, ty, tw, th, stride) {
+				if run.w > 0 {
+					found = append(found, run)
+					run = rect{}
+				}
+				continue
+			}
+			tiles++
+			if tiles > diffMaxTiles {
+				addDamage(0, 0, width, height)
+				return
+			}
+			if run.w == 0 {
+				run = rect{tx, ty, 0, th}
+			}
+			run.w += tw
+		}
+		if run.w > 0 {
+			found = append(found, run)
+		}
+	}
+	for _, r := range found {
+		addDamage(r.x, r.y, r.w, r.h)
+	}
+}
+
+func tileChanged(buf []byte, x, y, w, h, stride int32) bool {
+	for row := y; row < y+h; row++ {
+		start := row*stride + x*4
+		end := start + w*4
+		if !bytes.Equal(buf[start:end], diffPrev[start:end]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeDamage applies damageStrategy to rects, reusing its backing array.
+func mergeDamage(rects []rect) []rect {
+	if len(rects) < 2 {
+		return rects
+	}
+	switch damageStrategy {
+	case damageMerge:
+		for merged := true; merged; {
+			merged = false
+			for i := 0; i < len(rects); i++ {
+				for j := i + 1; j < len(rects); j++ {
+					if !rects[i].touches(rects[j]) {
+						continue
+					}
+					rects[i] = rects[i].union(rects[j])
+					rects[j] = rects[len(rects)-1]
+					rects = rects[:len(rects)-1]
+					merged = true
+					j--
+				}
+			}
+		}
+		if len(rects) <= damageMaxRects {
+			return rects
+		}
+		fallthrough
+	case damageBoundingBox:
+		box := rects[0]
+		for _, r := range rects[1:] {
+			box = box.union(r)
+		}
+		return append(rects[:0], box)
+	}
+	return rects
+}
+
+func mustSendDamage(conn *net.UnixConn) {
+	for _, r := range mergeDamage(pendingDamage) {
+		buf := makeMsgBuf(WLSurfaceID, 9, WORD_SIZE*4)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.x))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.y))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.w))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.h))
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	pendingDamage = pendingDamage[:0]
+	for _, r := range mergeDamage(pendingSurfaceDamage) {
+		buf := makeMsgBuf(WLSurfaceID, 2, WORD_SIZE*4)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.x))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.y))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.w))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.h))
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	pendingSurfaceDamage = pendingSurfaceDamage[:0]
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// useSurfaceVersion makes WLSurfaceID a wl_surface of version ver, with a
+// bufWidth×bufHeight buffer at scale.
+func useSurfaceVersion(t *testing.T, ver uint32, scale int32) {
+	t.Helper()
+	resetTestState(t)
+	WLSurfaceID = regObjVersion(objWLSurface, ver)
+	pendingSurface.scale = scale
+}
+
+func TestToBuffer(t *testing.T) {
+	resetTestState(t)
+	r := rect{10, 20, 30, 40}
+	for _, tt := range []struct {
+		transform uint32
+		scale     int32
+		want      rect
+	}{
+		{WLOutputTransformNormal, 1, rect{10, 20, 30, 40}},
+		{WLOutputTransformNormal, 2, rect{20, 40, 60, 80}},
+		{WLOutputTransform90, 1, rect{20, 60, 40, 30}},
+		{WLOutputTransform90, 2, rect{40, 20, 80, 60}},
+		{WLOutputTransform180, 1, rect{60, 40, 30, 40}},
+		{WLOutputTransformFlipped, 2, rect{20, 40, 60, 80}},
+	} {
+		s := surfaceState{transform: tt.transform, scale: tt.scale}
+		if got := s.toBuffer(r); got != tt.want {
+			t.Errorf("transform %d scale %d: got %v, want %v", tt.transform, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestToSurfaceInvertsToBuffer(t *testing.T) {
+	resetTestState(t)
+	r := rect{10, 20, 5, 30}
+	for transform := uint32(WLOutputTransformNormal); transform <= WLOutputTransformFlipped270; transform++ {
+		for _, scale := range []int32{1, 2} {
+			s := surfaceState{transform: transform, scale: scale}
+			if got := s.toSurface(s.toBuffer(r)); got != r {
+				t.Errorf("transform %d scale %d: got %v back, want %v", transform, scale, got, r)
+			}
+		}
+	}
+}
+
+func TestToSurfaceRoundsOut(t *testing.T) {
+	s := surfaceState{scale: 2}
+	if got, want := s.toSurface(rect{1, 1, 2, 2}), (rect{0, 0, 2, 2}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFullDamage(t *testing.T) {
+	for _, tt := range []struct {
+		ver          uint32
+		scale        int32
+		want         rect
+		bufferCoords bool
+	}{
+		{4, 1, rect{0, 0, 100, 100}, true},
+		{4, 2, rect{0, 0, 100, 100}, true},
+		{3, 1, rect{0, 0, 100, 100}, false},
+		{3, 2, rect{0, 0, 50, 50}, false},
+	} {
+		useSurfaceVersion(t, tt.ver, tt.scale)
+		got, bufferCoords := fullDamage()
+		if got != tt.want || bufferCoords != tt.bufferCoords {
+			t.Errorf("v%d scale %d: got %v, %t, want %v, %t", tt.ver, tt.scale, got, bufferCoords, tt.want, tt.bufferCoords)
+		}
+	}
+}
+
+func TestSurfaceDamageToBuffer(t *testing.T) {
+	useSurfaceVersion(t, 4, 2)
+	addSurfaceDamage(10, 10, 5, 5)
+	addSurfaceDamage(30, 30, 0, 5)
+	want := []rect{{20, 20, 10, 10}}
+	if !slices.Equal(pendingDamage, want) || len(pendingSurfaceDamage) != 0 {
+		t.Errorf("got buffer damage %v and surface damage %v, want buffer damage %v", pendingDamage, pendingSurfaceDamage, want)
+	}
+}
+
+func TestDamageBeforeDamageBuffer(t *testing.T) {
+	useSurfaceVersion(t, 3, 2)
+	addDamage(2, 2, 4, 4)
+	addSurfaceDamage(10, 10, 5, 5)
+	if len(pendingDamage) != 0 {
+		t.Fatalf("buffer damage %v queued for a v3 surface", pendingDamage)
+	}
+	want := []rect{{1, 1, 2, 2}, {10, 10, 5, 5}}
+	if !slices.Equal(pendingSurfaceDamage, want) {
+		t.Errorf("got surface damage %v, want %v", pendingSurfaceDamage, want)
+	}
+	if err := pendingSurface.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDiffDamageBeforeDamageBuffer(t *testing.T) {
+	useSurfaceVersion(t, 3, 2)
+	buf := make([]byte, 64*64*4)
+	addDiffDamage(buf, 64, 64, 64*4)
+	pendingSurfaceDamage = pendingSurfaceDamage[:0]
+	buf[(40*64+40)*4] = 1
+	addDiffDamage(buf, 64, 64, 64*4)
+	want := []rect{{16, 16, 16, 16}}
+	if len(pendingDamage) != 0 || !slices.Equal(pendingSurfaceDamage, want) {
+		t.Errorf("got buffer damage %v and surface damage %v, want surface damage %v", pendingDamage, pendingSurfaceDamage, want)
+	}
+}
+
+func TestSendDamageOpcodes(t *testing.T) {
+	for _, tt := range []struct {
+		ver    uint32
+		opcode uint32
+	}{
+		{3, 2}, // damage
+		{4, 9}, // damage_buffer
+	} {
+		resetTestState(t)
+		requests := make(chan uint32, 8)
+		conn, _ := startMockCompositor(t, func(id, opcode uint32, body []byte) {
+			if id == 3 {
+				requests <- opcode
+			}
+		})
+		WLCompositorID = regObjVersion(objWLCompositor, tt.ver)
+		mustCreateSurface(conn)
+		if WLSurfaceID != 3 {
+			t.Fatalf("surface got id %d, want 3", WLSurfaceID)
+		}
+		addDamage(0, 0, 10, 10)
+		mustCommit(conn)
+		if got := <-requests; got != tt.opcode {
+			t.Errorf("v%d: got opcode %d, want %d", tt.ver, got, tt.opcode)
+		}
+		if got := <-requests; got != 6 {
+			t.Errorf("v%d: got opcode %d after the damage, want the commit", tt.ver, got)
+		}
+	}
+}
+
+func TestMergeDamage(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		strategy damageStrategyType
+		maxRects int
+		rects    []rect
+		want     []rect
+	}{
+		{"as is keeps overlapping", damageAsIs, 16,
+			[]rect{{0, 0, 10, 10}, {5, 5, 10, 10}},
+			[]rect{{0, 0, 10, 10}, {5, 5, 10, 10}}},
+		{"merge overlapping", damageMerge, 16,
+			[]rect{{0, 0, 10, 10}, {5, 5, 10, 10}},
+			[]rect{{0, 0, 15, 15}}},
+		{"merge adjacent", damageMerge, 16,
+			[]rect{{0, 0, 10, 10}, {10, 0, 10, 10}},
+			[]rect{{0, 0, 20, 10}}},
+		{"merge keeps disjoint", damageMerge, 16,
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}},
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}}},
+		{"merge through a merged rect", damageMerge, 16,
+			[]rect{{0, 0, 10, 10}, {30, 0, 10, 10}, {8, 0, 24, 5}},
+			[]rect{{0, 0, 40, 10}}},
+		{"merge of overlapping and disjoint", damageMerge, 16,
+			[]rect{{0, 0, 10, 10}, {50, 50, 5, 5}, {5, 5, 10, 10}},
+			[]rect{{0, 0, 15, 15}, {50, 50, 5, 5}}},
+		{"merge past the cap", damageMerge, 2,
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}, {40, 0, 5, 5}},
+			[]rect{{0, 0, 45, 30}}},
+		{"merge at the cap", damageMerge, 2,
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}, {5, 5, 1, 1}},
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}}},
+		{"bounding box of disjoint", damageBoundingBox, 16,
+			[]rect{{0, 0, 10, 10}, {20, 20, 10, 10}},
+			[]rect{{0, 0, 30, 30}}},
+		{"bounding box of one", damageBoundingBox, 16,
+			[]rect{{5, 5, 10, 10}},
+			[]rect{{5, 5, 10, 10}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			saved, savedMax := damageStrategy, damageMaxRects
+			damageStrategy, damageMaxRects = tt.strategy, tt.maxRects
+			t.Cleanup(func() { damageStrategy, damageMaxRects = saved, savedMax })
+			got := mergeDamage(slices.Clone(tt.rects))
+			byPos := func(a, b rect) int {
+				if a.y != b.y {
+					return int(a.y - b.y)
+				}
+				return int(a.x - b.x)
+			}
+			slices.SortFunc(got, byPos)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
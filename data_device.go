@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+)
+
+// wl_data_device_manager::dnd_action
+const (
+	WLDataDeviceManagerDndActionNone = 0
+	WLDataDeviceManagerDndActionCopy = 1
+	WLDataDeviceManagerDndActionMove = 2
+	WLDataDeviceManagerDndActionAsk  = 4
+)
+
+// dataOffer is a wl_data_offer, created by the compositor so it has a
+// server id in serverObjects rather than an entry in objects. Its version is
+// the one of WLDataDeviceManagerID.
+type dataOffer struct {
+	mimeTypes     []string
+	sourceActions uint32
+	action        uint32
+	// accepted is the mime type last passed to acceptOffer.
+	accepted string
+	dropped  bool
+}
+
+var (
+	// dataOffersMu guards dataOffers, dndOffer and selectionOffer, set by
+	// the event handling goroutine and used by the app's.
+	dataOffersMu sync.Mutex
+	dataOffers   = map[uint32]*dataOffer{}
+
+	// dndOffer is the offer of the drag over WLSurfaceID, selectionOffer
+	// the clipboard's.
+	dndOffer       uint32
+	selectionOffer uint32
+)
+
+var (
+	errNoSuchOffer     = errors.New("wl_data_offer: no such offer")
+	errMimeNotOffered  = errors.New("wl_data_offer: mime type not offered")
+	errOfferNotDropped = errors.New("wl_data_offer: finish before the drop or without an accepted mime type")
+	errInvalidAction   = errors.New("wl_data_offer: actions must be copy, move or ask, the preferred one a single one of them")
+	errActionNotFinal  = errors.New("wl_data_offer: finish with no action or ask, set_actions must pick copy or move first")
+	errNotDndOffer     = errors.New("wl_data_offer: actions are only for drag and drop offers")
+)
+
+const dndActionsAll = WLDataDeviceManagerDndActionCopy | WLDataDeviceManagerDndActionMove | WLDataDeviceManagerDndActionAsk
+
+// mustGetDataDevice creates the data device of WLSeatID, through which drags
+// and the clipboard are offered.
+func mustGetDataDevice(conn *net.UnixConn) {
+	buf := makeMsgBuf(WLDataDeviceManagerID, 1, WORD_SIZE*2)
+	WLDataDeviceID = regChildObj(objWLDataDevice, WLDataDeviceManagerID)
+	buf = binary.LittleEndian.AppendUint32(buf, WLDataDeviceID)
+	buf = binary.LittleEndian.AppendUint32(buf, WLSeatID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleWLDataDeviceEvent(conn *net.UnixConn, opcode uint32, body []byte) (Event, error) {
+	switch opcode {
+	case 0: // data_offer
+		id := binary.LittleEndian.Uint32(body)
+		err := regServerObj(id, objWLDataOffer)
+		if err != nil {
+			return nil, err
+		}
+		dataOffersMu.Lock()
+		dataOffers[id] = &dataOffer{}
+		dataOffersMu.Unlock()
+	case 1: // enter
+		ev := WLDataDeviceEnter{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Surface: binary.LittleEndian.Uint32(body[4:]),
+			X:       fromFixed(binary.LittleEndian.Uint32(body[8:])),
+			Y:       fromFixed(binary.LittleEndian.Uint32(body[12:])),
+			Offer:   binary.LittleEndian.Uint32(body[16:]),
+		}
+		dataOffersMu.Lock()
+		dndOffer = ev.Offer
+		if o, ok := dataOffers[ev.Offer]; ok {
+			ev.MimeTypes = slices.Clone(o.mimeTypes)
+			ev.SourceActions = o.sourceActions
+		}
+		dataOffersMu.Unlock()
+		return ev, nil
+	case 2: // leave
+		// The offer is dead once the drag leaves, unless it was dropped,
+		// then it lives until the app finishes with it.
+		dataOffersMu.Lock()
+		offer := dndOffer
+		o, ok := dataOffers[offer]
+		dead := offer != 0 && !(ok && o.dropped)
+		dndOffer = 0
+		dataOffersMu.Unlock()
+		if dead {
+			mustDestroyOffer(conn, offer)
+		}
+		return WLDataDeviceLeave{}, nil
+	case 3: // motion
+		return WLDataDeviceMotion{
+			Time: binary.LittleEndian.Uint32(body),
+			X:    fromFixed(binary.LittleEndian.Uint32(body[4:])),
+			Y:    fromFixed(binary.LittleEndian.Uint32(body[8:])),
+		}, nil
+	case 4: // drop
+		dataOffersMu.Lock()
+		ev := WLDataDeviceDrop{Offer: dndOffer}
+		if o, ok := dataOffers[dndOffer]; ok {
+			o.dropped = true
+			ev.Action = o.action
+		}
+		dataOffersMu.Unlock()
+		return ev, nil
+	case 5: // selection
+		offer := binary.LittleEndian.Uint32(body)
+		dataOffersMu.Lock()
+		prev := selectionOffer
+		selectionOffer = offer
+		ev := WLDataDeviceSelection{Offer: offer}
+		if o, ok := dataOffers[offer]; ok {
+			ev.MimeTypes = slices.Clone(o.mimeTypes)
+		}
+		dataOffersMu.Unlock()
+		if prev != 0 {
+			mustDestroyOffer(conn, prev)
+		}
+		return ev, nil
+	}
+	return nil, nil
+}
+
+// handleWLDataOfferEvent handles the events of the offer id, false if it's
+// not a known offer.
+func handleWLDataOfferEvent(id, opcode uint32, body []byte) (Event, bool) {
+	dataOffersMu.Lock()
+	defer dataOffersMu.Unlock()
+	o, ok := dataOffers[id]
+	if !ok {
+		return nil, false
+	}
+	switch opcode {
+	case 0: // offer
+		mime, _ := parseStr(body)
+		o.mimeTypes = append(o.mimeTypes, string(mime))
+	case 1: // source_actions
+		o.sourceActions = binary.LittleEndian.Uint32(body)
+		return WLDataOfferSourceActions{Offer: id, SourceActions: o.sourceActions}, true
+	case 2: // action
+		o.action = binary.LittleEndian.Uint32(body)
+		return WLDataOfferAction{Offer: id, Action: o.action}, true
+	}
+	return nil, true
+}
+
+// acceptOffer tells the source of a drag whether mime can be dropped here,
+// "" meaning nothing can. serial is the one of the last enter event.
+func acceptOffer(conn *net.UnixConn, offer, serial uint32, mime string) error {
+	dataOffersMu.Lock()
+	o, ok := dataOffers[offer]
+	if ok && mime != "" && !slices.Contains(o.mimeTypes, mime) {
+		dataOffersMu.Unlock()
+		return errMimeNotOffered
+	}
+	if ok {
+		o.accepted = mime
+	}
+	dataOffersMu.Unlock()
+	if !ok {
+		return errNoSuchOffer
+	}
+	size := uint32(WORD_SIZE * 2)
+	if mime != "" {
+		size = WORD_SIZE + strSize(mime)
+	}
+	buf := makeMsgBuf(offer, 0, size)
+	buf = binary.LittleEndian.AppendUint32(buf, serial)
+	if mime != "" {
+		buf = appendStr(buf, mime)
+	} else {
+		buf = binary.LittleEndian.AppendUint32(buf, 0) // null string
+	}
+	return write(conn, buf)
+}
+
+// setOfferActions tells the compositor which of the source's actions are
+// supported here and which one is preferred. The compositor answers with the
+// action it picked from both sides as WLDataOfferAction. After dropping with
+// the ask action, call it again with the single action the user chose before
+// finishing.
+func setOfferActions(conn *net.UnixConn, offer, actions, preferred uint32) error {
+	err := requireVersion(WLDataDeviceManagerID, 3, "set_actions")
+	if err != nil {
+		return err
+	}
+	if actions&^dndActionsAll != 0 || preferred&(preferred-1) != 0 || preferred&^actions != 0 {
+		return errInvalidAction
+	}
+	dataOffersMu.Lock()
+	_, ok := dataOffers[offer]
+	selection := offer == selectionOffer
+	dataOffersMu.Unlock()
+	if !ok {
+		return errNoSuchOffer
+	}
+	if selection {
+		return errNotDndOffer
+	}
+	buf := makeMsgBuf(offer, 4, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, actions)
+	buf = binary.LittleEndian.AppendUint32(buf, preferred)
+	return write(conn, buf)
+}
+
+// receiveOffer asks for the offered data as mime, which the source writes to
+// the returned reader until it's done and closes it.
+func receiveOffer(conn *net.UnixConn, offer uint32, mime string) (io.ReadCloser, error) {
+	dataOffersMu.Lock()
+	o, ok := dataOffers[offer]
+	offered := ok && slices.Contains(o.mimeTypes, mime)
+	dataOffersMu.Unlock()
+	if !ok {
+		return nil, errNoSuchOffer
+	}
+	if !offered {
+		return nil, errMimeNotOffered
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	buf := makeMsgBuf(offer, 1, strSize(mime))
+	buf = appendStr(buf, mime)
+	err = writeFD(conn, buf, int(w.Fd()))
+	if err == nil {
+		// The source won't write anything before it gets the fd.
+		err = flush(conn)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// finishOffer tells the source a dropped offer was received, after which
+// the offer can only be destroyed. With the move action the source then
+// deletes the data.
+func finishOffer(conn *net.UnixConn, offer uint32) error {
+	err := requireVersion(WLDataDeviceManagerID, 3, "finish")
+	if err != nil {
+		return err
+	}
+	dataOffersMu.Lock()
+	o, ok := dataOffers[offer]
+	done := ok && o.dropped && o.accepted != ""
+	final := ok && (o.action == WLDataDeviceManagerDndActionCopy || o.action == WLDataDeviceManagerDndActionMove)
+	dataOffersMu.Unlock()
+	if !ok {
+		return errNoSuchOffer
+	}
+	if !done {
+		return errOfferNotDropped
+	}
+	if !final {
+		return errActionNotFinal
+	}
+	return write(conn, makeMsgBuf(offer, 3, 0))
+}
+
+func mustDestroyOffer(conn *net.UnixConn, offer uint32) {
+	dataOffersMu.Lock()
+	delete(dataOffers, offer)
+	dataOffersMu.Unlock()
+	forgetServerObj(offer)
+	err := write(conn, makeMsgBuf(offer, 2, 0))
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestDataOfferBadID(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		id   uint32
+	}{
+		{"client range", 3},
+		{"already used", serverIDMin},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			conn, _ := startMockCompositor(t, nil)
+			if err := regServerObj(serverIDMin, objWLDataOffer); err != nil {
+				t.Fatal(err)
+			}
+			ev, err := handleWLDataDeviceEvent(conn, 0, binary.LittleEndian.AppendUint32(nil, tc.id))
+			var corrupt corruptMsgErr
+			if !errors.As(err, &corrupt) || ev != nil {
+				t.Fatalf("data_offer %#x got %v, %v, want a corruptMsgErr", tc.id, ev, err)
+			}
+		})
+	}
+}
+
+// offerRequest is a request a mockCompositor got for an offer.
+type offerRequest struct {
+	opcode uint32
+	body   []byte
+}
+
+// newMockOffer starts a mockCompositor with a data device manager at ver
+// and has it offer text/plain in a drag entering at serial 7.
+func newMockOffer(t *testing.T, ver uint32) (conn *net.UnixConn, m *mockCompositor, offer uint32, reqs func() []offerRequest) {
+	t.Helper()
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	offer = serverIDMin
+	var mu sync.Mutex
+	var got []offerRequest
+	conn, m = startMockCompositor(t, func(id, opcode uint32, body []byte) {
+		if id == offer {
+			mu.Lock()
+			got = append(got, offerRequest{opcode, slices.Clone(body)})
+			mu.Unlock()
+		}
+	})
+	WLDataDeviceManagerID = regObjVersion(objWLDataDeviceManager, ver)
+	WLDataDeviceID = regChildObj(objWLDataDevice, WLDataDeviceManagerID)
+	if _, err := handleWLDataDeviceEvent(conn, 0, binary.LittleEndian.AppendUint32(nil, offer)); err != nil {
+		t.Fatal(err)
+	}
+	handleWLDataOfferEvent(offer, 0, appendStr(nil, "text/plain"))
+	enter := []uint32{7, WLSurfaceID, 0, 0, offer}
+	var body []byte
+	for _, w := range enter {
+		body = binary.LittleEndian.AppendUint32(body, w)
+	}
+	if _, err := handleWLDataDeviceEvent(conn, 1, body); err != nil {
+		t.Fatal(err)
+	}
+	return conn, m, offer, func() []offerRequest {
+		mustRoundtrip(t, conn)
+		mu.Lock()
+		defer mu.Unlock()
+		return got
+	}
+}
+
+func TestOfferRequests(t *testing.T) {
+	accepted := binary.LittleEndian.AppendUint32(nil, 7)
+	accepted = appendStr(accepted, "text/plain")
+	// finish accepts text/plain, the compositor picks action and the drag
+	// is dropped, then finishes.
+	finish := func(conn *net.UnixConn, offer, action uint32) error {
+		if err := acceptOffer(conn, offer, 7, "text/plain"); err != nil {
+			return err
+		}
+		handleWLDataOfferEvent(offer, 2, binary.LittleEndian.AppendUint32(nil, action))
+		handleWLDataDeviceEvent(conn, 4, nil)
+		return finishOffer(conn, offer)
+	}
+	for _, tc := range []struct {
+		name string
+		ver  uint32
+		do   func(conn *net.UnixConn, offer uint32) error
+		err  error
+		// want is the last request sent, nil if none is.
+		want *offerRequest
+	}{
+		{
+			name: "accept", ver: 3,
+			do:   func(conn *net.UnixConn, offer uint32) error { return acceptOffer(conn, offer, 7, "text/plain") },
+			want: &offerRequest{0, accepted},
+		},
+		{
+			name: "accept nothing", ver: 3,
+			do:   func(conn *net.UnixConn, offer uint32) error { return acceptOffer(conn, offer, 7, "") },
+			want: &offerRequest{0, []byte{7, 0, 0, 0, 0, 0, 0, 0}},
+		},
+		{
+			name: "accept not offered", ver: 3,
+			do:  func(conn *net.UnixConn, offer uint32) error { return acceptOffer(conn, offer, 7, "image/png") },
+			err: errMimeNotOffered,
+		},
+		{
+			name: "accept unknown offer", ver: 3,
+			do:  func(conn *net.UnixConn, offer uint32) error { return acceptOffer(conn, offer+1, 7, "text/plain") },
+			err: errNoSuchOffer,
+		},
+		{
+			name: "set_actions", ver: 3,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return setOfferActions(conn, offer, WLDataDeviceManagerDndActionCopy|WLDataDeviceManagerDndActionMove, WLDataDeviceManagerDndActionMove)
+			},
+			want: &offerRequest{4, []byte{3, 0, 0, 0, 2, 0, 0, 0}},
+		},
+		{
+			name: "set_actions preferred not supported", ver: 3,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return setOfferActions(conn, offer, WLDataDeviceManagerDndActionCopy, WLDataDeviceManagerDndActionMove)
+			},
+			err: errInvalidAction,
+		},
+		{
+			name: "set_actions before v3", ver: 2,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return setOfferActions(conn, offer, WLDataDeviceManagerDndActionCopy, WLDataDeviceManagerDndActionCopy)
+			},
+			err: errVersionTooOld,
+		},
+		{
+			name: "finish", ver: 3,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return finish(conn, offer, WLDataDeviceManagerDndActionCopy)
+			},
+			want: &offerRequest{3, []byte{}},
+		},
+		{
+			name: "finish before the drop", ver: 3,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				if err := acceptOffer(conn, offer, 7, "text/plain"); err != nil {
+					return err
+				}
+				return finishOffer(conn, offer)
+			},
+			err:  errOfferNotDropped,
+			want: &offerRequest{0, accepted},
+		},
+		{
+			name: "finish with ask", ver: 3,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return finish(conn, offer, WLDataDeviceManagerDndActionAsk)
+			},
+			err:  errActionNotFinal,
+			want: &offerRequest{0, accepted},
+		},
+		{
+			name: "finish before v3", ver: 2,
+			do: func(conn *net.UnixConn, offer uint32) error {
+				return finish(conn, offer, WLDataDeviceManagerDndActionCopy)
+			},
+			err:  errVersionTooOld,
+			want: &offerRequest{0, accepted},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, _, offer, reqs := newMockOffer(t, tc.ver)
+			err := tc.do(conn, offer)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			got := reqs()
+			if tc.want == nil {
+				if len(got) != 0 {
+					t.Errorf("sent %v, want no request", got)
+				}
+				return
+			}
+			if len(got) == 0 {
+				t.Fatalf("sent nothing, want %v", *tc.want)
+			}
+			last := got[len(got)-1]
+			if last.opcode != tc.want.opcode || !bytes.Equal(last.body, tc.want.body) {
+				t.Errorf("last request is opcode %d %v, want %d %v", last.opcode, last.body, tc.want.opcode, tc.want.body)
+			}
+		})
+	}
+}
+
+func TestReceiveOffer(t *testing.T) {
+	conn, m, offer, reqs := newMockOffer(t, 3)
+	if _, err := receiveOffer(conn, offer, "image/png"); !errors.Is(err, errMimeNotOffered) {
+		t.Fatalf("receiving a mime type not offered got %v, want %v", err, errMimeNotOffered)
+	}
+	r, err := receiveOffer(conn, offer, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got := reqs()
+	if len(got) != 1 || got[0].opcode != 1 || !bytes.Equal(got[0].body, appendStr(nil, "text/plain")) {
+		t.Fatalf("sent %v, want one receive of text/plain", got)
+	}
+	fds := m.takeFDs()
+	if len(fds) != 1 {
+		t.Fatalf("the compositor got %d fds, want 1", len(fds))
+	}
+	w := os.NewFile(uintptr(fds[0]), "offer")
+	w.WriteString("hello")
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("read %q, %v, want what the source wrote", data, err)
+	}
+}
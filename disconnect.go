@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// disconnectMu serializes disconnect.
+var disconnectMu sync.Mutex
+
+// errDisconnected is returned by nextMsg once disconnect ran, so a loop
+// reading events can tell it from the connection breaking.
+var errDisconnected = errors.New("wayland: disconnected")
+
+// destroyOnDisconnect makes disconnect destroy the objects this package
+// keeps the ids of before closing, for compositors and proxies that report
+// the objects a client leaves behind. Objects the app created itself, like
+// popups and regions, are the app's to destroy.
+var destroyOnDisconnect bool
+
+// teardown is the objects destroyOnDisconnect destroys, children before the
+// objects they were created from, with their destructor's opcode and the
+// version it was added in. wl_outputs and wl_seats are released last.
+var teardown = []struct {
+	id      *uint32
+	destroy uint16
+	since   uint32
+}{
+	{&WPCommitTimerID, 1, 1},
+	{&WPFifoID, 2, 1},
+	{&WPAlphaModifierSurfaceID, 0, 1},
+	{&WPTearingControlID, 1, 1},
+	{&WPColorManagementSurfaceID, 0, 1},
+	{&ZWPVirtualKeyboardID, 3, 1},
+	{&ZWLRVirtualPointerID, 8, 1},
+	{&WLPointerID, 1, 3},
+	{&WLKeyboardID, 0, 3},
+	{&WLTouchID, 0, 3},
+	{&WLDataDeviceID, 2, 2},
+	{&XDGTopLevelID, 0, 1},
+	{&XDGSurfaceID, 0, 1},
+	{&WLSurfaceID, 0, 1},
+	{&WLBufferID, 0, 1},
+	{&WLShmPoolID, 1, 1},
+	{&WPCommitTimingManagerID, 0, 1},
+	{&WPFifoManagerID, 0, 1},
+	{&WPAlphaModifierID, 0, 1},
+	{&WPTearingControlManagerID, 0, 1},
+	{&WPColorManagerID, 0, 1},
+	{&ZWLRVirtualPointerManagerID, 1, 1},
+	{&ZWPKeyboardShortcutsInhibitManagerID, 0, 1},
+	{&XDGActivationID, 0, 1},
+	{&ZWLROutputPowerManagerID, 1, 1},
+	{&ZWLRGammaControlManagerID, 1, 1},
+	{&ZWLRLayerShellID, 1, 3},
+	{&WLSubcompositorID, 0, 1},
+	{&XDGToplevelIconManagerID, 0, 1},
+	{&WPPresentationID, 0, 1},
+	{&ZWPLinuxDmabufID, 0, 1},
+	{&XDGWMBaseID, 0, 1},
+	{&WLShmID, 1, 2},
+}
+
+// destroyLive queues the destructors of the objects in teardown and of the
+// bound wl_outputs and wl_seats, skipping those without one at their
+// version.
+func destroyLive(conn *net.UnixConn) error {
+	destroy := func(id uint32, opcode uint16, since uint32) error {
+		if id == 0 || interfaceVersion(id) < since {
+			return nil
+		}
+		return write(conn, makeMsgBuf(id, opcode, 0))
+	}
+	for _, obj := range teardown {
+		err := destroy(*obj.id, obj.destroy, obj.since)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range WLSeatIDs {
+		err := destroy(id, 3, 5)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range WLOutputIDs {
+		err := destroy(id, 0, 3)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connClosed reports whether conn was closed already.
+func connClosed(conn *net.UnixConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return true
+	}
+	return rc.Control(func(uintptr) {}) != nil
+}
+
+// disconnect flushes the requests still queued and closes conn, then waits
+// for the reader started by startReader for conn, if any, to stop, and
+// closes the message log being recorded. The messages the reader queued are
+// dropped, nextMsg returns errDisconnected instead. Objects are only
+// destroyed one by one with destroyOnDisconnect, the compositor frees all of
+// a client's objects when its connection closes anyway. Calling it for a
+// conn already closed, by an earlier disconnect or not, only stops its
+// reader and returns nil.
+func disconnect(conn *net.UnixConn) error {
+	disconnectMu.Lock()
+	defer disconnectMu.Unlock()
+	if connClosed(conn) {
+		stopReader(conn)
+		return nil
+	}
+	var destroyErr error
+	if destroyOnDisconnect {
+		destroyErr = destroyLive(conn)
+	}
+	flushErr := flush(conn)
+	closeErr := conn.Close()
+	stopReader(conn)
+	return errors.Join(destroyErr, flushErr, closeErr, stopRecording())
+}
+
+// stopReader waits for the reader of conn, closed, to stop, and replaces
+// what it queued with errDisconnected.
+func stopReader(conn *net.UnixConn) {
+	readMu.Lock()
+	done := readerDone
+	if readerConn != conn {
+		done = nil
+	}
+	readMu.Unlock()
+	if done == nil {
+		return
+	}
+	<-done
+	readMu.Lock()
+	readerDone, readerConn = nil, nil
+	readerPongs.Store(false)
+	readQ = []readMsg{{err: errDisconnected}}
+	readMu.Unlock()
+	readCond.Broadcast()
+}
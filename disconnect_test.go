@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDisconnectTwice(t *testing.T) {
+	for _, reader := range []bool{false, true} {
+		name := "without reader"
+		if reader {
+			name = "with reader"
+		}
+		t.Run(name, func(t *testing.T) {
+			resetTestState(t)
+			conn, _ := startMockCompositor(t, nil)
+			if reader {
+				startReader(conn)
+			}
+			for i := range 2 {
+				err := disconnect(conn)
+				if err != nil {
+					t.Fatalf("disconnect %d: %v", i, err)
+				}
+			}
+			if !connClosed(conn) {
+				t.Fatal("conn still open after disconnect")
+			}
+			if !reader {
+				return
+			}
+			_, _, _, err := nextMsg()
+			if !errors.Is(err, errDisconnected) {
+				t.Errorf("got %v after disconnect, want %v", err, errDisconnected)
+			}
+		})
+	}
+}
+
+func TestDisconnectEarlierConn(t *testing.T) {
+	resetTestState(t)
+	a, _ := startMockCompositor(t, nil)
+	b, mb := startMockCompositor(t, nil)
+	t.Cleanup(func() { disconnect(b) })
+	if err := disconnect(a); err != nil {
+		t.Fatal(err)
+	}
+	startReader(b)
+
+	// a again, after b took over, mustn't touch b or its reader.
+	if err := disconnect(a); err != nil {
+		t.Fatalf("disconnecting a closed conn again: %v", err)
+	}
+	if connClosed(b) {
+		t.Fatal("disconnecting a closed b")
+	}
+	mb.emit(WLDisplayID, 1, 42)
+	id, opcode, body, err := nextMsg()
+	releaseBody(body)
+	if err != nil || id != WLDisplayID || opcode != 1 {
+		t.Errorf("b's reader got %d, %d, %v, want its delete_id", id, opcode, err)
+	}
+}
+
+func TestDisconnectDestroysLiveObjects(t *testing.T) {
+	for _, destroy := range []bool{false, true} {
+		var log requestLog
+		conn, m := newMockSurface(t, log.record)
+		saved := destroyOnDisconnect
+		destroyOnDisconnect = destroy
+		t.Cleanup(func() { destroyOnDisconnect = saved })
+		XDGWMBaseID = regObjVersion(objXDGWMBase, 1)
+		WLOutputIDs = []uint32{regObjVersion(objWLOutput, 2), regObjVersion(objWLOutput, 4)}
+		surface, buffer, pool, wmBase, shm := WLSurfaceID, WLBufferID, WLShmPoolID, XDGWMBaseID, WLShmID
+		oldOutput, output := WLOutputIDs[0], WLOutputIDs[1]
+
+		if err := disconnect(conn); err != nil {
+			t.Fatal(err)
+		}
+		<-m.done
+		for _, req := range []struct {
+			name       string
+			id, opcode uint32
+			sent       bool
+		}{
+			{"wl_surface.destroy", surface, 0, destroy},
+			{"wl_buffer.destroy", buffer, 0, destroy},
+			{"wl_shm_pool.destroy", pool, 1, destroy},
+			{"xdg_wm_base.destroy", wmBase, 0, destroy},
+			{"wl_output.release", output, 0, destroy},
+			{"wl_output.release before v3", oldOutput, 0, false},
+			{"wl_shm.release before v2", shm, 1, false},
+		} {
+			if got := log.has(req.id, req.opcode); got != req.sent {
+				t.Errorf("destroyOnDisconnect %v: %s sent is %v, want %v", destroy, req.name, got, req.sent)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// xdg_toplevel::state
+const (
+	XDGToplevelStateMaximized = iota + 1
+	XDGToplevelStateFullscreen
+	XDGToplevelStateResizing
+	XDGToplevelStateActivated
+	XDGToplevelStateTiledLeft
+	XDGToplevelStateTiledRight
+	XDGToplevelStateTiledTop
+	XDGToplevelStateTiledBottom
+	XDGToplevelStateSuspended
+)
+
+// toplevelResizing is set while the last toplevel configure had the
+// resizing state, during an interactive resize. Guarded by configureMu.
+var toplevelResizing bool
+
+// interactiveResize reports toplevelResizing.
+func interactiveResize() bool {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	return toplevelResizing
+}
+
+// handleEvent does the protocol housekeeping for an event (pong, ack, freeing
+// ids, ...) and returns it decoded if it's one the app may want to react to,
+// nil otherwise. The error is the fatal wl_display::error, a connection
+// error a handler panicked with, or a handlerPanicErr onHandlerPanic didn't
+// recover from.
+func handleEvent(ctx context.Context, conn *net.UnixConn, id, opcode uint32, body []byte) (ev Event, err error) {
+	if onHandlerPanic != nil {
+		defer func() {
+			if v := recover(); v != nil {
+				ev, err = nil, recoverHandler(id, opcode, v)
+			}
+		}()
+	}
+	return dispatchEvent(ctx, conn, id, opcode, body)
+}
+
+// errHandlerPanic is wrapped by handlerPanicErr.
+var errHandlerPanic = errors.New("panic handling event")
+
+// handlerPanicErr is a panic recovered while handling an event, most likely
+// from an app callback like an eventHandler or onGlobal.
+type handlerPanicErr struct {
+	id, opcode uint32
+	// obj describes the object as of the panic, see describeObj.
+	obj   string
+	value any
+	stack []byte
+}
+
+func (err handlerPanicErr) Error() string {
+	msg := "unknown panic value"
+	switch v := err.value.(type) {
+	case error:
+		msg = v.Error()
+	case string:
+		msg = v
+	case interface{ String() string }:
+		msg = v.String()
+	}
+	return errHandlerPanic.Error() + " opcode " + strconv.FormatUint(uint64(err.opcode), 10) + " of " + err.obj + ": " + msg
+}
+
+// Unwrap returns errHandlerPanic and the panic value if it's an error.
+func (err handlerPanicErr) Unwrap() []error {
+	if v, ok := err.value.(error); ok {
+		return []error{errHandlerPanic, v}
+	}
+	return []error{errHandlerPanic}
+}
+
+// onHandlerPanic, if set, makes handleEvent recover from panics, passing
+// them as a handlerPanicErr. It returns whether to carry on with the next
+// event, the connection is unaffected, or to stop with the error, like
+// after a wl_display::error. Unset, panics unwind as usual.
+//
+// Panics with a connection error, like a must function failing to write
+// to a closed connection, aren't app bugs and there's no carrying on after
+// them, they're returned as is without calling it.
+var onHandlerPanic func(err error) (carryOn bool)
+
+func recoverHandler(id, opcode uint32, v any) error {
+	if err, ok := v.(error); ok && isConnErr(err) {
+		return err
+	}
+	err := handlerPanicErr{id: id, opcode: opcode, obj: describeObj(id), value: v, stack: debug.Stack()}
+	if onHandlerPanic(err) {
+		return nil
+	}
+	return err
+}
+
+// isConnErr reports whether err means the connection is gone.
+func isConnErr(err error) bool {
+	return errors.Is(err, errConnLost) || errors.Is(err, errDisconnected) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// dispatchEvent is handleEvent without the recovering.
+func dispatchEvent(ctx context.Context, conn *net.UnixConn, id, opcode uint32, body []byte) (Event, error) {
+	switch id {
+	case WLDisplayID:
+		return nil, handleWLDisplayEvent(opcode, body)
+	case WLRegistryID:
+		return handleWLRegistryEvent(conn, id, opcode, body), nil
+	case XDGWMBaseID:
+		if opcode != 0 {
+			logUnhandled(ctx, "xdg_wm_base", id, opcode, body)
+			return nil, nil
+		}
+		serial := binary.LittleEndian.Uint32(body)
+		if !readerPongs.Load() {
+			mustPong(conn, serial)
+		}
+		return XDGWMBasePing{Serial: serial}, nil
+	case XDGSurfaceID:
+		if opcode != 0 {
+			logUnhandled(ctx, "xdg_surface", id, opcode, body)
+			return nil, nil
+		}
+		serial := binary.LittleEndian.Uint32(body)
+		configureMu.Lock()
+		pendingConfigure = serial
+		configureMu.Unlock()
+		return XDGSurfaceConfigure{XDGSurface: id, Serial: serial}, nil
+	case XDGTopLevelID:
+		switch opcode {
+		case 0: // configure
+			ev := XDGToplevelConfigure{
+				Width:  int32(binary.LittleEndian.Uint32(body)),
+				Height: int32(binary.LittleEndian.Uint32(body[4:])),
+			}
+			ev.States = parseUint32s(body[8:])
+			w, h := configureSize(ev.Width, ev.Height)
+			configureMu.Lock()
+			pendingWindowWidth, pendingWindowHeight = w, h
+			toplevelResizing = slices.Contains(ev.States, XDGToplevelStateResizing)
+			configureMu.Unlock()
+			return ev, nil
+		case 1: // close
+			select {
+			case closeRequests <- struct{}{}:
+			default:
+			}
+			return XDGToplevelClose{}, nil
+		}
+		logUnhandled(ctx, "xdg_top_level", id, opcode, body)
+	case WLShmID:
+		handleWLShmEvent(opcode, body)
+	case WLSurfaceID:
+		return handleWLSurfaceEvent(conn, opcode, body), nil
+	case WPColorManagerID:
+		handleWPColorManagerEvent(opcode, body)
+	case WPPresentationID:
+		handleWPPresentationEvent(opcode, body)
+	case XDGToplevelIconManagerID:
+		handleXDGToplevelIconManagerEvent(opcode, body)
+	case WLPointerID:
+		return handleWLPointerEvent(opcode, body), nil
+	case WLKeyboardID:
+		return handleWLKeyboardEvent(opcode, body), nil
+	case WLTouchID:
+		return handleWLTouchEvent(opcode, body), nil
+	case WLDataDeviceID:
+		return handleWLDataDeviceEvent(conn, opcode, body)
+	default:
+		return handleObjEvent(ctx, conn, id, opcode, body)
+	}
+	return nil, nil
+}
+
+// eventsLen is the buffer size of the channel returned by startDispatcher.
+var eventsLen = 64
+
+// eventDroppable reports whether ev may be dropped when the events channel is
+// full, instead of blocking the dispatcher until the app catches up. Only
+// events superseded by the next one of their kind, like pointer motion,
+// should be dropped.
+var eventDroppable = func(ev Event) bool {
+	_, ok := ev.(WLPointerMotion)
+	return ok
+}
+
+// dispatchErr is why the dispatcher stopped, valid once its channel is
+// closed. io.EOF is the compositor closing the connection cleanly, an error
+// wrapping errConnLost is it dying mid-message.
+var dispatchErr error
+
+// startDispatcher handles events on a new goroutine and delivers the decoded
+// ones on the returned channel, for apps that would rather select on events
+// than run the read loop themselves. Reading is done by startReader, so pings
+// are answered even while delivery is blocked on a full channel. The channel
+// is closed when reading fails, the compositor sends an error or ctx is done,
+// with the reason left in dispatchErr.
+//
+// Handling an event on the dispatcher only records what the compositor
+// asked for. What replaces the buffer, a new window size or scale, is
+// applied by react, which the goroutine drawing has to pass the events to.
+//
+// With flushOnDispatch the dispatcher only flushes before it starts waiting,
+// requests made from other goroutines while it waits need an explicit flush.
+func startDispatcher(ctx context.Context, conn *net.UnixConn) <-chan Event {
+	events := make(chan Event, eventsLen)
+	startReader(conn)
+	go func() {
+		defer close(events)
+		for {
+			id, opcode, body, err := nextMsg()
+			if err != nil {
+				dispatchErr = err
+				return
+			}
+			ev, err := handleEvent(ctx, conn, id, opcode, body)
+			releaseBody(body)
+			if err != nil {
+				dispatchErr = err
+				return
+			}
+			if ev == nil {
+				continue
+			}
+			if eventDroppable(ev) {
+				select {
+				case events <- ev:
+				default:
+				}
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				dispatchErr = ctx.Err()
+				return
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordHandlerPanics sets onHandlerPanic for the test, carrying on after
+// each panic and returning what it was called with.
+func recordHandlerPanics(tb testing.TB) *[]error {
+	tb.Helper()
+	var errs []error
+	saved := onHandlerPanic
+	onHandlerPanic = func(err error) bool {
+		errs = append(errs, err)
+		return true
+	}
+	tb.Cleanup(func() { onHandlerPanic = saved })
+	return &errs
+}
+
+func TestHandlerPanicRecovered(t *testing.T) {
+	resetTestState(t)
+	errs := recordHandlerPanics(t)
+	id := regObjHandler(func(conn *net.UnixConn, id, opcode uint32, body []byte) {
+		panic("boom")
+	})
+
+	ev, err := handleEvent(context.Background(), nil, id, 3, nil)
+	if ev != nil || err != nil {
+		t.Fatalf("got %v, %v, want the panic recovered", ev, err)
+	}
+	if len(*errs) != 1 {
+		t.Fatalf("onHandlerPanic called %d times, want 1", len(*errs))
+	}
+	var perr handlerPanicErr
+	if !errors.As((*errs)[0], &perr) || !errors.Is(perr, errHandlerPanic) {
+		t.Fatalf("got %v, want a handlerPanicErr", (*errs)[0])
+	}
+	if perr.id != id || perr.opcode != 3 || perr.value != "boom" {
+		t.Errorf("got panic %v of %d opcode %d, want boom of %d opcode 3", perr.value, perr.id, perr.opcode, id)
+	}
+}
+
+func TestHandlerConnErrPanicReturned(t *testing.T) {
+	resetTestState(t)
+	errs := recordHandlerPanics(t)
+	for _, want := range []error{errConnLost, errDisconnected, net.ErrClosed, syscall.EPIPE, syscall.ECONNRESET} {
+		id := regObjHandler(func(conn *net.UnixConn, id, opcode uint32, body []byte) {
+			panic(fmt.Errorf("writing: %w", want))
+		})
+		_, err := handleEvent(context.Background(), nil, id, 0, nil)
+		if !errors.Is(err, want) {
+			t.Errorf("got %v, want %v", err, want)
+		}
+	}
+	if len(*errs) != 0 {
+		t.Errorf("onHandlerPanic called with %v for connection errors", *errs)
+	}
+}
+
+func TestDrawPanicRecovered(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	errs := recordHandlerPanics(t)
+	pendingSurface.scale = 0
+
+	quit, err := reactRecovering(conn, WLDisplayID, 0, WLSurfaceFrameDone{})
+	if quit || err != nil {
+		t.Fatalf("got %v, %v, want the panic recovered", quit, err)
+	}
+	if len(*errs) != 1 {
+		t.Fatalf("onHandlerPanic called %d times, want 1", len(*errs))
+	}
+	if !errors.Is((*errs)[0], errHandlerPanic) {
+		t.Errorf("got %v, want a handlerPanicErr", (*errs)[0])
+	}
+
+	pendingSurface.scale = 1
+	conn.Close()
+	_, err = reactRecovering(conn, WLDisplayID, 0, WLSurfaceFrameDone{})
+	if !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got %v drawing to a closed conn, want %v", err, net.ErrClosed)
+	}
+	if len(*errs) != 1 {
+		t.Errorf("onHandlerPanic called with %v for a closed conn", (*errs)[1:])
+	}
+}
+
+// TestDispatcherWhileCommitting has the compositor reconfigure the surface
+// and update the state of other protocols while the app draws and commits
+// on its own goroutine, as startDispatcher hands it events. Run with -race.
+func TestDispatcherWhileCommitting(t *testing.T) {
+	conn, m := newMockSurface(t, nil)
+	t.Cleanup(func() { disconnect(conn) })
+	XDGSurfaceID = regObj(objXDGSurface)
+	XDGTopLevelID = regObj(objXDGTopLevel)
+	WPColorManagerID = regObj(objWPColorManager)
+	ZWLROutputPowerManagerID = regObj(objZWLROutputPowerManager)
+	ZWLRGammaControlManagerID = regObj(objZWLRGammaControlManager)
+	ZWPKeyboardShortcutsInhibitManagerID = regObj(objZWPKeyboardShortcutsInhibitManager)
+	output, seat := regObj(objWLOutput), regObj(objWLSeat)
+	power := mustGetOutputPower(conn, output)
+	gamma := mustGetGammaControl(conn, output).id
+	err := inhibitShortcuts(conn, seat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortcutsMu.Lock()
+	inhibitor := shortcutsInhibitors[seat].id
+	shortcutsMu.Unlock()
+	surface, xdgSurface, toplevel, colorManager := WLSurfaceID, XDGSurfaceID, XDGTopLevelID, WPColorManagerID
+
+	const rounds = 20
+	go func() {
+		for i := range uint32(rounds) {
+			m.emit(toplevel, 0, 200+i, 100+i, 0)
+			m.emit(surface, 2, 1+i%2) // preferred_buffer_scale
+			m.emit(surface, 3, i%4)   // preferred_buffer_transform
+			m.emit(colorManager, 1, i%8)
+			m.emit(power, 0, i%2)
+			m.emit(gamma, 0, 256)
+			m.emit(inhibitor, i%2)
+			m.emit(xdgSurface, 0, i+1)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := startDispatcher(ctx, conn)
+	ramp := make([]uint16, 256)
+	for {
+		ev, ok := <-events
+		if !ok {
+			t.Fatalf("dispatcher stopped: %v", dispatchErr)
+		}
+		react(conn, ev)
+		mustRepaint(conn)
+		mustSetOutputPower(conn, output, true)
+		setGamma(conn, output, ramp, ramp, ramp)
+		createParametricImageDescription(conn, WPColorManagerTransferFunctionSRGB, WPColorManagerPrimariesSRGB)
+		if c, ok := ev.(XDGSurfaceConfigure); ok && c.Serial == rounds {
+			break
+		}
+	}
+	if windowWidth != 200+rounds-1 || windowHeight != 100+rounds-1 {
+		t.Errorf("got a window of %dx%d after the last configure, want %dx%d", windowWidth, windowHeight, 200+rounds-1, 100+rounds-1)
+	}
+	err = disconnect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range events {
+	}
+}
+
+func TestPongDuringSlowHandler(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	XDGWMBaseID = regObj(objXDGWMBase)
+	wmBase := XDGWMBaseID
+	pongs := make(chan uint32, 4)
+	conn, m := startMockCompositor(t, func(id, opcode uint32, body []byte) {
+		if id == wmBase && opcode == 3 {
+			pongs <- binary.LittleEndian.Uint32(body)
+		}
+	})
+	blocked := make(chan struct{})
+	release := sync.OnceFunc(func() { close(blocked) })
+	slow := regObjHandler(func(conn *net.UnixConn, id, opcode uint32, body []byte) {
+		<-blocked
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := startDispatcher(ctx, conn)
+	t.Cleanup(func() {
+		release()
+		disconnect(conn)
+		for range events {
+		}
+	})
+	pong := func(want uint32) {
+		t.Helper()
+		select {
+		case serial := <-pongs:
+			if serial != want {
+				t.Fatalf("got pong %d, want %d", serial, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("ping %d not ponged", want)
+		}
+	}
+
+	m.emit(slow, 0)
+	m.emit(wmBase, 0, 42)
+	// The handler blocks until the pong arrives.
+	pong(42)
+	release()
+	for ev := range events {
+		if ev == (XDGWMBasePing{Serial: 42}) {
+			break
+		}
+	}
+	// The next ping's pong comes right after the first, not after a second
+	// pong from the dispatcher.
+	m.emit(wmBase, 0, 43)
+	pong(43)
+}
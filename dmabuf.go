@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"slices"
+
+	"golang.org/x/sys/unix"
+)
+
+// linux-dmabuf is only bound for its default feedback, whose tranches list
+// the formats the compositor can scan out or composite cheapest, most
+// preferred first. Buffers are still wl_shm, picking a format from the
+// feedback that wl_shm also takes spares the compositor a conversion.
+
+var (
+	// dmabufFormatTable is the mapped format_table of the default
+	// feedback, nil before one is received.
+	dmabufFormatTable []byte
+	// dmabufPendingFormats are the formats of the tranches received since
+	// the last done, applied to compositorFormats by the next.
+	dmabufPendingFormats []shmFormat
+)
+
+// bindDmabuf binds zwp_linux_dmabuf_v1 and asks for its default feedback,
+// which needs version 4. Older versions only list formats and modifiers
+// without an order of preference, so they're left unbound.
+func bindDmabuf(conn *net.UnixConn, name, ver uint32, iface []byte) {
+	if ver < 4 || ZWPLinuxDmabufID != 0 && objTypeOf(ZWPLinuxDmabufID) == objZWPLinuxDmabuf {
+		return
+	}
+	ZWPLinuxDmabufID = mustRegBind(conn, objZWPLinuxDmabuf, name, ver, iface)
+	buf := makeMsgBuf(ZWPLinuxDmabufID, 2, WORD_SIZE)
+	id := regChildObj(objZWPLinuxDmabufFeedback, ZWPLinuxDmabufID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleZWPLinuxDmabufFeedbackEvent(id, opcode uint32, body []byte) {
+	switch opcode {
+	case 0: // done
+		compositorFormats = dmabufPendingFormats
+		dmabufPendingFormats = nil
+	case 1: // format_table
+		fd := takeFD(id)
+		if fd < 0 {
+			return
+		}
+		defer closeRecvFD(fd)
+		if dmabufFormatTable != nil {
+			unix.Munmap(dmabufFormatTable)
+			dmabufFormatTable = nil
+		}
+		// The table has to be mapped MAP_PRIVATE.
+		table, err := unix.Mmap(fd, 0, int(binary.LittleEndian.Uint32(body)), unix.PROT_READ, unix.MAP_PRIVATE)
+		if err != nil {
+			slog.Error("zwp_linux_dmabuf_feedback_v1: mapping the format table", "err", err)
+			return
+		}
+		dmabufFormatTable = table
+	case 5: // tranche_formats
+		indices, _ := parseArray(body)
+		fs, err := dmabufTrancheFormats(dmabufFormatTable, indices)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		for _, f := range fs {
+			if !slices.Contains(dmabufPendingFormats, f) {
+				dmabufPendingFormats = append(dmabufPendingFormats, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// formatTable returns a linux-dmabuf format table of fourccs, with modifiers
+// of 0.
+func formatTable(fourccs ...uint32) []byte {
+	var table []byte
+	for _, f := range fourccs {
+		table = binary.LittleEndian.AppendUint32(table, f)
+		table = binary.LittleEndian.AppendUint32(table, 0)
+		table = binary.LittleEndian.AppendUint64(table, 0)
+	}
+	return table
+}
+
+// trancheFormats returns the body of a tranche_formats event of indices.
+func trancheFormats(indices ...uint16) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(indices)*2))
+	for _, i := range indices {
+		body = binary.LittleEndian.AppendUint16(body, i)
+	}
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	return body
+}
+
+func TestDmabufFeedbackFormats(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	const (
+		xr24 = 'X' | 'R'<<8 | '2'<<16 | '4'<<24
+		ar24 = 'A' | 'R'<<8 | '2'<<16 | '4'<<24
+		xb24 = 'X' | 'B'<<8 | '2'<<16 | '4'<<24
+	)
+	table := formatTable(ar24, xb24, xr24)
+	fd, err := unix.MemfdCreate("format_table", unix.MFD_CLOEXEC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unix.Write(fd, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvFDsMu.Lock()
+	recvFDs = append(recvFDs, fd)
+	recvFDsMu.Unlock()
+
+	id := regObj(objZWPLinuxDmabufFeedback)
+	handleZWPLinuxDmabufFeedbackEvent(id, 1, binary.LittleEndian.AppendUint32(nil, uint32(len(table))))
+	handleZWPLinuxDmabufFeedbackEvent(id, 5, trancheFormats(1, 2))
+	handleZWPLinuxDmabufFeedbackEvent(id, 3, nil)
+	handleZWPLinuxDmabufFeedbackEvent(id, 5, trancheFormats(2, 0))
+	handleZWPLinuxDmabufFeedbackEvent(id, 3, nil)
+	if compositorFormats != nil {
+		t.Fatal("formats applied before done")
+	}
+	handleZWPLinuxDmabufFeedbackEvent(id, 0, nil)
+
+	want := []shmFormat{WLShmFormatXBGR8888, WLShmFormatXRGB8888, WLShmFormatARGB8888}
+	if !slices.Equal(compositorFormats, want) {
+		t.Errorf("got formats %v, want %v", compositorFormats, want)
+	}
+	shmFormats[WLShmFormatXBGR8888] = true
+	defer delete(shmFormats, WLShmFormatXBGR8888)
+	if got := preferredFormat(false); got != WLShmFormatXBGR8888 {
+		t.Errorf("got preferred format %v, want %v", got, WLShmFormatXBGR8888)
+	}
+	if got := preferredFormat(true); got != WLShmFormatARGB8888 {
+		t.Errorf("got preferred alpha format %v, want %v", got, WLShmFormatARGB8888)
+	}
+}
+
+func TestBindDmabufGetsDefaultFeedback(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(func() { ZWPLinuxDmabufID = 0 })
+	ZWPLinuxDmabufID = 0
+	requests := make(chan [2]uint32, 4)
+	conn, _ := startMockCompositor(t, func(id, opcode uint32, body []byte) {
+		requests <- [2]uint32{id, opcode}
+	})
+	WLRegistryID = regObj(objWLRegistry)
+	bindDmabuf(conn, 7, 3, []byte("zwp_linux_dmabuf_v1"))
+	if ZWPLinuxDmabufID != 0 {
+		t.Fatal("bound below version 4")
+	}
+	bindDmabuf(conn, 7, 4, []byte("zwp_linux_dmabuf_v1"))
+	if got := <-requests; got != [2]uint32{WLRegistryID, 0} {
+		t.Errorf("got request %v, want the bind", got)
+	}
+	if got := <-requests; got != [2]uint32{ZWPLinuxDmabufID, 2} {
+		t.Errorf("got request %v, want get_default_feedback", got)
+	}
+	if got := objTypeOf(ZWPLinuxDmabufID + 1); got != objZWPLinuxDmabufFeedback {
+		t.Errorf("feedback registered as %v", got)
+	}
+}
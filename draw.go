@@ -0,0 +1,90 @@
+package main
+
+// canvas draws into a buffer of 4 bytes per pixel XRGB8888/ARGB8888 pixels,
+// which are B, G, R, A in memory. Everything is clipped to the buffer.
+type canvas struct {
+	pix           []byte
+	width, height int32
+	stride        int32
+}
+
+// bufCanvas returns a canvas over WLBufferID's pixels.
+func bufCanvas() canvas {
+	return canvas{pix: WLShmPoolBuf, width: int32(bufWidth), height: int32(bufHeight), stride: int32(bufWidth * 4)}
+}
+
+// clip returns the part of the rect inside c, w or h <= 0 if there's none.
+func (c canvas) clip(x, y, w, h int32) (int32, int32, int32, int32) {
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+w, c.width), min(y+h, c.height)
+	return x0, y0, x1 - x0, y1 - y0
+}
+
+func (c canvas) set(x, y int32, argb uint32) {
+	if x < 0 || y < 0 || x >= c.width || y >= c.height {
+		return
+	}
+	i := y*c.stride + x*4
+	c.pix[i] = byte(argb)
+	c.pix[i+1] = byte(argb >> 8)
+	c.pix[i+2] = byte(argb >> 16)
+	c.pix[i+3] = byte(argb >> 24)
+}
+
+// fillRect fills a rect with the 0xAARRGGBB color argb.
+func (c canvas) fillRect(x, y, w, h int32, argb uint32) {
+	x, y, w, h = c.clip(x, y, w, h)
+	if w <= 0 || h <= 0 {
+		return
+	}
+	px := [4]byte{byte(argb), byte(argb >> 8), byte(argb >> 16), byte(argb >> 24)}
+	row := c.pix[y*c.stride+x*4:][:w*4]
+	for i := int32(0); i < w*4; i += 4 {
+		copy(row[i:], px[:])
+	}
+	for r := int32(1); r < h; r++ {
+		copy(c.pix[(y+r)*c.stride+x*4:], row)
+	}
+}
+
+// drawLine draws a one pixel wide line from x0, y0 to x1, y1, both ends
+// included.
+func (c canvas) drawLine(x0, y0, x1, y1 int32, argb uint32) {
+	dx, dy := x1-x0, -(y1 - y0)
+	sx, sy := int32(1), int32(1)
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	if dy > 0 {
+		dy, sy = -dy, -1
+	}
+	e := dx + dy
+	for {
+		c.set(x0, y0, argb)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// blit copies src into c with its top left corner at x, y. Pixels are
+// copied as is, alpha isn't blended.
+func (c canvas) blit(x, y int32, src canvas) {
+	dx, dy, w, h := c.clip(x, y, src.width, src.height)
+	if w <= 0 || h <= 0 {
+		return
+	}
+	sx, sy := dx-x, dy-y
+	for r := int32(0); r < h; r++ {
+		copy(c.pix[(dy+r)*c.stride+dx*4:][:w*4], src.pix[(sy+r)*src.stride+sx*4:])
+	}
+}
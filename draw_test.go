@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestCanvas returns a zeroed canvas with pad bytes at the end of each row.
+func newTestCanvas(width, height, pad int32) canvas {
+	stride := width*4 + pad
+	return canvas{pix: make([]byte, stride*height), width: width, height: height, stride: stride}
+}
+
+// picture returns c's rows with '#' for a pixel of argb, '.' for a zeroed
+// one and '?' for anything else.
+func (c canvas) picture(argb uint32) []string {
+	px := []byte{byte(argb), byte(argb >> 8), byte(argb >> 16), byte(argb >> 24)}
+	var rows []string
+	for y := range c.height {
+		var row strings.Builder
+		for x := range c.width {
+			i := y*c.stride + x*4
+			switch got := c.pix[i : i+4]; {
+			case bytes.Equal(got, px):
+				row.WriteByte('#')
+			case bytes.Equal(got, make([]byte, 4)):
+				row.WriteByte('.')
+			default:
+				row.WriteByte('?')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+func TestFillRect(t *testing.T) {
+	const color = 0x11223344
+	for _, tt := range []struct {
+		name       string
+		x, y, w, h int32
+		want       []string
+	}{
+		{"inside", 1, 1, 2, 2, []string{
+			".....",
+			".##..",
+			".##..",
+			".....",
+		}},
+		{"whole canvas", 0, 0, 5, 4, []string{
+			"#####",
+			"#####",
+			"#####",
+			"#####",
+		}},
+		{"clipped at the top left", -2, -1, 4, 3, []string{
+			"##...",
+			"##...",
+			".....",
+			".....",
+		}},
+		{"clipped at the bottom right", 3, 2, 10, 10, []string{
+			".....",
+			".....",
+			"...##",
+			"...##",
+		}},
+		{"outside", 5, 0, 2, 2, []string{
+			".....",
+			".....",
+			".....",
+			".....",
+		}},
+		{"empty", 1, 1, 0, 3, []string{
+			".....",
+			".....",
+			".....",
+			".....",
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCanvas(5, 4, 8)
+			c.fillRect(tt.x, tt.y, tt.w, tt.h, color)
+			if got := c.picture(color); !slices.Equal(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			for y := range c.height {
+				pad := c.pix[y*c.stride+c.width*4 : (y+1)*c.stride]
+				if !bytes.Equal(pad, make([]byte, len(pad))) {
+					t.Errorf("row %d padding written: %v", y, pad)
+				}
+			}
+		})
+	}
+}
+
+func TestFillRectPixelBytes(t *testing.T) {
+	c := newTestCanvas(2, 1, 0)
+	c.fillRect(1, 0, 1, 1, 0x11223344)
+	// B, G, R, A in memory.
+	if want := []byte{0, 0, 0, 0, 0x44, 0x33, 0x22, 0x11}; !bytes.Equal(c.pix, want) {
+		t.Errorf("got %#v, want %#v", c.pix, want)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apps with their own poll loop, waiting on timers and other sockets too,
+// can wait on the connection's fd instead of blocking in read:
+//
+//	for {
+//		events, err := dispatchPending(ctx, conn)
+//		// handle err and events
+//		err = flush(conn)
+//		// handle err
+//		// poll connFD(conn) for POLLIN along with the app's own fds
+//	}
+//
+// Messages are read off the socket one at a time with nothing buffered in
+// between, so a message can't sit unnoticed while the app waits. A readable
+// fd doesn't always mean there's something to dispatch though: when the
+// compositor's message was split across writes, dispatchPending leaves the
+// first part on the socket rather than block for the rest, and the poll
+// returns right away until the rest comes. Requests made while dispatching
+// need the flush before waiting, or the compositor may never see the
+// request whose reply the app is waiting for.
+
+// With requests made from other goroutines, waiting on the fd while one of
+// them sits queued can deadlock: the compositor won't send the reply the app
+// waits for until it gets the request. Polling goroutines guard the wait
+// with prepareRead and cancelRead:
+//
+//	for !prepareRead() {
+//		err := flush(conn)
+//		// handle err
+//	}
+//	// poll connFD(conn)
+//	cancelRead()
+//	events, err := dispatchPending(ctx, conn)
+//
+// prepareRead only succeeds with nothing queued, and until cancelRead every
+// request is sent as soon as it's built whatever flushPolicy says, so none
+// can be left behind while the poll waits.
+
+// prepareRead announces the caller is about to wait for the connection's fd
+// to be readable. It returns false, preparing nothing, if requests are still
+// queued, they have to be flushed first. Each successful call has to be
+// followed by a cancelRead once the wait is over, whether or not anything
+// was read.
+func prepareRead() bool {
+	outMu.Lock()
+	defer outMu.Unlock()
+	if len(outBuf) > 0 {
+		return false
+	}
+	readsPrepared++
+	return true
+}
+
+// cancelRead ends a read prepared by prepareRead, requests are queued by
+// flushPolicy again once no read is prepared.
+func cancelRead() {
+	outMu.Lock()
+	defer outMu.Unlock()
+	if readsPrepared > 0 {
+		readsPrepared--
+	}
+}
+
+// errReaderStarted is returned by dispatchPending once startReader runs, the
+// reader owns the socket then and nextMsg is the way to get messages.
+var errReaderStarted = errors.New("wayland: messages are read by startReader")
+
+// connFD returns conn's fd for polling. It stays owned by conn, it mustn't
+// be read from or closed.
+func connFD(conn *net.UnixConn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	err = raw.Control(func(f uintptr) { fd = int(f) })
+	return fd, err
+}
+
+// dispatchPending handles the messages that can be read whole without
+// waiting and returns the events decoded from them, nil if none was ready.
+// The error is a read error or the fatal wl_display::error, events handled
+// before it are still returned.
+func dispatchPending(ctx context.Context, conn *net.UnixConn) ([]Event, error) {
+	if readerPongs.Load() {
+		return nil, errReaderStarted
+	}
+	fd, err := connFD(conn)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for {
+		ready, err := msgReady(fd)
+		if err != nil || !ready {
+			return events, err
+		}
+		id, opcode, body, err := read(conn)
+		if err != nil {
+			return events, err
+		}
+		ev, err := handleEvent(ctx, conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			return events, err
+		}
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+}
+
+// peekHeader is where msgReady peeks at the next message's header.
+var peekHeader = make([]byte, HEADER_SIZE)
+
+// msgReady reports whether a whole message can be read from fd without
+// blocking, or the peer hung up so the read can report it. Nothing is
+// consumed.
+func msgReady(fd int) (bool, error) {
+	queued, err := unix.IoctlGetInt(fd, unix.SIOCINQ)
+	if err != nil {
+		return false, err
+	}
+	if queued == 0 {
+		// Readable with nothing queued is a hang up.
+		return readable(fd)
+	}
+	if queued < HEADER_SIZE {
+		return false, nil
+	}
+	n, _, err := unix.Recvfrom(fd, peekHeader, unix.MSG_PEEK|unix.MSG_DONTWAIT)
+	if err != nil {
+		return false, err
+	}
+	if n < HEADER_SIZE {
+		// A peek stops after bytes sent along with fds, here partway
+		// through the header. The header is all queued, most likely the
+		// body too as the compositor sent them in one go.
+		return true, nil
+	}
+	// A size below the header is corrupt, left to read to report.
+	size := int(binary.LittleEndian.Uint32(peekHeader[4:]) >> 16)
+	return queued >= size, nil
+}
+
+// readable reports whether reading fd wouldn't block, which includes the
+// peer hanging up so the read can report it.
+func readable(fd int) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return n > 0 && fds[0].Revents != 0, nil
+	}
+}
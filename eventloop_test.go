@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// dispatchPendingPromptly runs dispatchPending, failing the test if it
+// blocks.
+func dispatchPendingPromptly(t *testing.T, conn *net.UnixConn) []Event {
+	t.Helper()
+	type result struct {
+		events []Event
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		events, err := dispatchPending(context.Background(), conn)
+		done <- result{events, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.events
+	case <-time.After(time.Second):
+		t.Fatal("dispatchPending blocked")
+		return nil
+	}
+}
+
+func TestDispatchPendingReturnsPromptly(t *testing.T) {
+	resetTestState(t)
+	conn, server := newConnPair(t)
+	cb := regCallback(objWLCallback, WLDisplayID, func(data uint32) Event {
+		return WLSurfaceFrameDone{Time: data}
+	})
+
+	if events := dispatchPendingPromptly(t, conn); events != nil {
+		t.Fatalf("got %v with nothing sent", events)
+	}
+
+	msg := binary.LittleEndian.AppendUint32(nil, cb)
+	msg = binary.LittleEndian.AppendUint32(msg, 12<<16)
+	msg = binary.LittleEndian.AppendUint32(msg, 42)
+	for _, part := range [][]byte{msg[:6], msg[6:10]} {
+		_, err := server.Write(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if events := dispatchPendingPromptly(t, conn); events != nil {
+			t.Fatalf("got %v with part of a message sent", events)
+		}
+	}
+	_, err := server.Write(msg[10:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := dispatchPendingPromptly(t, conn)
+	if len(events) != 1 || events[0] != (WLSurfaceFrameDone{Time: 42}) {
+		t.Errorf("got %v, want the callback's done", events)
+	}
+
+	server.Close()
+	_, err = dispatchPending(context.Background(), conn)
+	if err == nil {
+		t.Error("no error once the compositor hung up")
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+// Event is an event decoded by handleEvent for the app to react to. Each
+// type is named after the interface and event it's decoded from.
+type Event any
+
+// WLRegistryGlobal is sent by every registry, Registry tells them apart.
+type WLRegistryGlobal struct {
+	Registry  uint32
+	Name      uint32
+	Interface string
+	Version   uint32
+}
+
+type WLRegistryGlobalRemove struct {
+	Registry uint32
+	Name     uint32
+}
+
+type XDGWMBasePing struct {
+	Serial uint32
+}
+
+type XDGSurfaceConfigure struct {
+	XDGSurface uint32
+	Serial     uint32
+}
+
+type XDGToplevelConfigure struct {
+	Width, Height int32
+	States        []uint32
+}
+
+// XDGToplevelClose is the compositor asking for the toplevel to close, e.g.
+// from its close button. It's only a request, the window stays open unless
+// the app destroys it, so it can save state or ask for confirmation first.
+type XDGToplevelClose struct{}
+
+// XDGPopupConfigure is the popup's position relative to its parent and size,
+// applied with the XDGSurfaceConfigure that follows.
+type XDGPopupConfigure struct {
+	Popup         uint32
+	X, Y          int32
+	Width, Height int32
+}
+
+// XDGPopupDone is sent when the compositor dismissed the popup, it should be
+// destroyed.
+type XDGPopupDone struct {
+	Popup uint32
+}
+
+type WLSurfaceFrameDone struct {
+	Time uint32
+}
+
+// WLSurfaceScale is sent when the surface moved to outputs with a different
+// highest scale, or the compositor's preferred scale changed. react
+// reallocates the buffer at the new scale with mustSetScale and redraws,
+// apps handling events themselves have to do the same.
+type WLSurfaceScale struct {
+	Scale int32
+}
+
+// WLSurfacePreferredTransform is the buffer transform the compositor
+// suggests, since wl_surface v6. It's only applied with setBufferTransform.
+type WLSurfacePreferredTransform struct {
+	Transform uint32
+}
+
+type WLBufferRelease struct {
+	Buffer uint32
+}
+
+type WLSeatCapabilities struct {
+	Seat         uint32
+	Capabilities uint32
+}
+
+type WLSeatName struct {
+	Seat uint32
+	Name string
+}
+
+type WLPointerEnter struct {
+	Serial  uint32
+	Surface uint32
+	X, Y    float64
+}
+
+type WLPointerLeave struct {
+	Serial  uint32
+	Surface uint32
+}
+
+type WLPointerMotion struct {
+	Time uint32
+	X, Y float64
+}
+
+type WLPointerButton struct {
+	Serial uint32
+	Time   uint32
+	Button uint32
+	State  uint32
+}
+
+type WLPointerAxis struct {
+	Time  uint32
+	Axis  uint32
+	Value float64
+}
+
+// WLPointerFrame ends a group of pointer events. Axes sums up the frame's
+// scrolling, indexed by wl_pointer::axis, with Source its axis_source if
+// sent.
+type WLPointerFrame struct {
+	Source uint32
+	Axes   [2]pointerAxisFrame
+}
+
+type WLPointerAxisSource struct {
+	Source uint32
+}
+
+type WLPointerAxisStop struct {
+	Time uint32
+	Axis uint32
+}
+
+type WLPointerAxisDiscrete struct {
+	Axis     uint32
+	Discrete int32
+}
+
+// WLPointerAxisValue120 is the scroll in 120ths of a wheel detent, replacing
+// WLPointerAxisDiscrete since wl_pointer v8.
+type WLPointerAxisValue120 struct {
+	Axis     uint32
+	Value120 int32
+}
+
+// WLPointerAxisRelativeDirection tells whether the scroll on Axis moves the
+// content along with the fingers or wheel, the natural scrolling setting,
+// since wl_pointer v9.
+type WLPointerAxisRelativeDirection struct {
+	Axis      uint32
+	Direction uint32
+}
+
+// WLKeyboardKeymap carries the keymap text for the xkb_v1 format, trimmed at
+// its terminating NUL. Err is set instead when there's no keymap to use,
+// errNoKeymap if the compositor has none and keys are raw keycodes.
+type WLKeyboardKeymap struct {
+	Format uint32
+	Keymap []byte
+	Err    error
+}
+
+type WLTouchDown struct {
+	Serial  uint32
+	Time    uint32
+	Surface uint32
+	ID      int32
+	X, Y    float64
+}
+
+type WLTouchUp struct {
+	Serial uint32
+	Time   uint32
+	ID     int32
+}
+
+type WLTouchMotion struct {
+	Time uint32
+	ID   int32
+	X, Y float64
+}
+
+// WLTouchFrame ends a group of touch events, with every point as of then
+// including their shape and orientation. Points that went up during the
+// frame are included one last time. Points are sorted by ID.
+type WLTouchFrame struct {
+	Points []touchPoint
+}
+
+type WLTouchCancel struct{}
+
+type WLKeyboardEnter struct {
+	Serial  uint32
+	Surface uint32
+	Keys    []uint32
+}
+
+type WLKeyboardLeave struct {
+	Serial  uint32
+	Surface uint32
+}
+
+// WLKeyboardKey is a key pressed or released, or with
+// WLKeyboardKeyStateRepeated a repeat of a key held, from keyRepeats.
+type WLKeyboardKey struct {
+	Serial uint32
+	Time   uint32
+	Key    uint32
+	State  uint32
+}
+
+type WLKeyboardModifiers struct {
+	Serial    uint32
+	Depressed uint32
+	Latched   uint32
+	Locked    uint32
+	Group     uint32
+}
+
+type WLKeyboardRepeatInfo struct {
+	// Rate is in keys per second, 0 disables repeating.
+	Rate int32
+	// Delay is in milliseconds.
+	Delay int32
+}
+
+type ZWLROutputPowerMode struct {
+	Output uint32
+	Mode   uint32
+}
+
+type ZWLROutputPowerFailed struct {
+	Output uint32
+}
+
+type ZWLRGammaControlGammaSize struct {
+	Output uint32
+	Size   uint32
+}
+
+type ZWLRGammaControlFailed struct {
+	Output uint32
+}
+
+type ZWPKeyboardShortcutsInhibitorActive struct {
+	Seat uint32
+}
+
+type ZWPKeyboardShortcutsInhibitorInactive struct {
+	Seat uint32
+}
+
+type XDGActivationTokenDone struct {
+	Token string
+}
+
+type WPImageDescriptionReady struct {
+	ImageDescription uint32
+	Identity         uint32
+}
+
+type WPImageDescriptionFailed struct {
+	ImageDescription uint32
+	Cause            uint32
+	Msg              string
+}
+
+// WLDataDeviceEnter is a drag entering Surface, offering MimeTypes with the
+// dnd actions SourceActions.
+type WLDataDeviceEnter struct {
+	Serial        uint32
+	Surface       uint32
+	X, Y          float64
+	Offer         uint32
+	MimeTypes     []string
+	SourceActions uint32
+}
+
+type WLDataDeviceLeave struct{}
+
+type WLDataDeviceMotion struct {
+	Time uint32
+	X, Y float64
+}
+
+// WLDataDeviceDrop is the drag being dropped, Offer can be received from
+// and has to be finished and destroyed after.
+type WLDataDeviceDrop struct {
+	Offer  uint32
+	Action uint32
+}
+
+// WLDataDeviceSelection is a new clipboard offer, 0 if it was cleared.
+type WLDataDeviceSelection struct {
+	Offer     uint32
+	MimeTypes []string
+}
+
+// WLDataOfferSourceActions are the actions the drag source supports, which
+// may change during the drag.
+type WLDataOfferSourceActions struct {
+	Offer         uint32
+	SourceActions uint32
+}
+
+// WLDataOfferAction is the action the compositor picked for the drag.
+type WLDataOfferAction struct {
+	Offer  uint32
+	Action uint32
+}
+
+// WPPresentationFeedbackPresented is the commit of Feedback being shown. Time
+// is when, in nanoseconds of presentationClock, Refresh the nanoseconds until
+// the next refresh or 0 if unknown, Seq the output's refresh counter and
+// Flags the WPPresentationFeedbackKind* that apply.
+type WPPresentationFeedbackPresented struct {
+	Feedback uint32
+	Time     uint64
+	Refresh  uint32
+	Seq      uint64
+	Flags    uint32
+}
+
+// WPPresentationFeedbackDiscarded is the commit of Feedback never being
+// shown, e.g. replaced by the next before a refresh.
+type WPPresentationFeedbackDiscarded struct {
+	Feedback uint32
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestEventDecoding(t *testing.T) {
+	// 24.8 fixed point values.
+	const (
+		fixed1_5   = 0x180
+		fixedMinus = 0xfffffdc0 // -2.25
+		fixed10    = 0xa00
+	)
+	// wl_pointer::axis_source
+	const sourceWheel, sourceFinger = 0, 1
+	// setup registers the object the event is for and any state it needs,
+	// and returns the object.
+	type setup func(t *testing.T, conn *net.UnixConn) uint32
+	obj := func(typ objType, id *uint32) setup {
+		return func(t *testing.T, conn *net.UnixConn) uint32 {
+			o := regObj(typ)
+			if id != nil {
+				*id = o
+			}
+			return o
+		}
+	}
+	// offer has the data device offer text/plain as serverIDMin, with
+	// action picked.
+	offer := func(action uint32) setup {
+		return func(t *testing.T, conn *net.UnixConn) uint32 {
+			WLDataDeviceID = regObj(objWLDataDevice)
+			if _, err := handleWLDataDeviceEvent(conn, 0, words(serverIDMin)); err != nil {
+				t.Fatal(err)
+			}
+			handleWLDataOfferEvent(serverIDMin, 0, appendStr(nil, "text/plain"))
+			handleWLDataOfferEvent(serverIDMin, 2, words(action))
+			return WLDataDeviceID
+		}
+	}
+	entered := func(action uint32) setup {
+		return func(t *testing.T, conn *net.UnixConn) uint32 {
+			dev := offer(action)(t, conn)
+			if _, err := handleWLDataDeviceEvent(conn, 1, words(1, 0, 0, 0, serverIDMin)); err != nil {
+				t.Fatal(err)
+			}
+			return dev
+		}
+	}
+	serverObj := func(t *testing.T, conn *net.UnixConn) uint32 {
+		offer(0)(t, conn)
+		return serverIDMin
+	}
+	outputPower := func(t *testing.T, conn *net.UnixConn) uint32 {
+		id := regObj(objZWLROutputPower)
+		outputPowerMu.Lock()
+		zwlrOutputPowers[42] = id
+		outputPowerMu.Unlock()
+		return id
+	}
+	gamma := func(t *testing.T, conn *net.UnixConn) uint32 {
+		id := regObj(objZWLRGammaControl)
+		gammaMu.Lock()
+		zwlrGammaControls[42] = &gammaControl{id: id}
+		gammaMu.Unlock()
+		return id
+	}
+	inhibitor := func(t *testing.T, conn *net.UnixConn) uint32 {
+		id := regObj(objZWPKeyboardShortcutsInhibitor)
+		shortcutsMu.Lock()
+		shortcutsInhibitors[42] = &shortcutsInhibitor{id: id}
+		shortcutsMu.Unlock()
+		return id
+	}
+	var desc uint32
+	imageDesc := func(t *testing.T, conn *net.UnixConn) uint32 {
+		desc = regObj(objWPImageDescription)
+		colorMu.Lock()
+		imageDescriptions[desc] = &imageDescription{}
+		colorMu.Unlock()
+		return desc
+	}
+	frameCallback := func(t *testing.T, conn *net.UnixConn) uint32 {
+		WLSurfaceID = regObj(objWLSurface)
+		mustFrame(conn)
+		return WLFrameCallbackID
+	}
+	// keyboard queues the fd the keymap event comes with.
+	keyboard := func(t *testing.T, conn *net.UnixConn) uint32 {
+		WLKeyboardID = regObj(objWLKeyboard)
+		fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queueRecvFDs(unix.UnixRights(fd))
+		return WLKeyboardID
+	}
+	scrolled := func(t *testing.T, conn *net.UnixConn) uint32 {
+		WLPointerID = regObj(objWLPointer)
+		handleWLPointerEvent(6, words(sourceWheel))
+		handleWLPointerEvent(4, words(1, WLPointerAxisVerticalScroll, fixed1_5))
+		handleWLPointerEvent(9, words(WLPointerAxisVerticalScroll, 120))
+		return WLPointerID
+	}
+	touched := func(t *testing.T, conn *net.UnixConn) uint32 {
+		WLTouchID = regObj(objWLTouch)
+		handleWLTouchEvent(0, words(1, 2, 3, 4, fixed1_5, fixed10))
+		return WLTouchID
+	}
+
+	var seat, registry, popup, buffer, feedback, token uint32
+	for _, tc := range []struct {
+		name   string
+		setup  setup
+		opcode uint32
+		body   []byte
+		// want is the event, its object ids taken after setup.
+		want func() Event
+	}{
+		{"wl_registry.global", obj(objWLRegistry, &registry), 0,
+			append(appendStr(words(7), "wl_seat"), words(9)...),
+			func() Event { return WLRegistryGlobal{Registry: registry, Name: 7, Interface: "wl_seat", Version: 9} }},
+		{"wl_registry.global_remove", obj(objWLRegistry, &registry), 1, words(7),
+			func() Event { return WLRegistryGlobalRemove{Registry: registry, Name: 7} }},
+		{"xdg_wm_base.ping", obj(objXDGWMBase, &XDGWMBaseID), 0, words(5),
+			func() Event { return XDGWMBasePing{Serial: 5} }},
+		{"xdg_surface.configure", obj(objXDGSurface, &XDGSurfaceID), 0, words(5),
+			func() Event { return XDGSurfaceConfigure{XDGSurface: XDGSurfaceID, Serial: 5} }},
+		{"xdg_toplevel.configure", obj(objXDGTopLevel, &XDGTopLevelID), 0,
+			words(640, 480, 8, XDGToplevelStateMaximized, XDGToplevelStateActivated),
+			func() Event {
+				return XDGToplevelConfigure{Width: 640, Height: 480, States: []uint32{XDGToplevelStateMaximized, XDGToplevelStateActivated}}
+			}},
+		{"xdg_toplevel.close", obj(objXDGTopLevel, &XDGTopLevelID), 1, nil,
+			func() Event { return XDGToplevelClose{} }},
+		{"xdg_popup.configure", obj(objXDGPopup, &popup), 0, words(0xfffffffb, 10, 200, 100),
+			func() Event { return XDGPopupConfigure{Popup: popup, X: -5, Y: 10, Width: 200, Height: 100} }},
+		{"xdg_popup.popup_done", obj(objXDGPopup, &popup), 1, nil,
+			func() Event { return XDGPopupDone{Popup: popup} }},
+		{"wl_callback.done of a frame", frameCallback, 0, words(1234),
+			func() Event { return WLSurfaceFrameDone{Time: 1234} }},
+		{"wl_surface.preferred_buffer_scale", obj(objWLSurface, &WLSurfaceID), 2, words(2),
+			func() Event { return WLSurfaceScale{Scale: 2} }},
+		{"wl_surface.preferred_buffer_transform", obj(objWLSurface, &WLSurfaceID), 3, words(WLOutputTransform90),
+			func() Event { return WLSurfacePreferredTransform{Transform: WLOutputTransform90} }},
+		{"wl_buffer.release", obj(objWLBuffer, &buffer), 0, nil,
+			func() Event { return WLBufferRelease{Buffer: buffer} }},
+		{"wl_seat.capabilities", obj(objWLSeat, &seat), 0, words(WLSeatCapabilityKeyboard),
+			func() Event { return WLSeatCapabilities{Seat: seat, Capabilities: WLSeatCapabilityKeyboard} }},
+		{"wl_seat.name", obj(objWLSeat, &seat), 1, appendStr(nil, "seat1"),
+			func() Event { return WLSeatName{Seat: seat, Name: "seat1"} }},
+		{"wl_pointer.enter", obj(objWLPointer, &WLPointerID), 0, words(5, 3, fixed1_5, fixedMinus),
+			func() Event { return WLPointerEnter{Serial: 5, Surface: 3, X: 1.5, Y: -2.25} }},
+		{"wl_pointer.leave", obj(objWLPointer, &WLPointerID), 1, words(5, 3),
+			func() Event { return WLPointerLeave{Serial: 5, Surface: 3} }},
+		{"wl_pointer.motion", obj(objWLPointer, &WLPointerID), 2, words(100, fixed10, fixed1_5),
+			func() Event { return WLPointerMotion{Time: 100, X: 10, Y: 1.5} }},
+		{"wl_pointer.button", obj(objWLPointer, &WLPointerID), 3, words(5, 100, 0x110, WLPointerButtonStatePressed),
+			func() Event {
+				return WLPointerButton{Serial: 5, Time: 100, Button: 0x110, State: WLPointerButtonStatePressed}
+			}},
+		{"wl_pointer.axis", obj(objWLPointer, &WLPointerID), 4, words(100, WLPointerAxisHorizontalScroll, fixedMinus),
+			func() Event { return WLPointerAxis{Time: 100, Axis: WLPointerAxisHorizontalScroll, Value: -2.25} }},
+		{"wl_pointer.frame", scrolled, 5, nil,
+			func() Event {
+				var ev WLPointerFrame
+				ev.Source = sourceWheel
+				ev.Axes[WLPointerAxisVerticalScroll] = pointerAxisFrame{Value: 1.5, Value120: 120}
+				return ev
+			}},
+		{"wl_pointer.axis_source", obj(objWLPointer, &WLPointerID), 6, words(sourceFinger),
+			func() Event { return WLPointerAxisSource{Source: sourceFinger} }},
+		{"wl_pointer.axis_stop", obj(objWLPointer, &WLPointerID), 7, words(100, WLPointerAxisVerticalScroll),
+			func() Event { return WLPointerAxisStop{Time: 100, Axis: WLPointerAxisVerticalScroll} }},
+		{"wl_pointer.axis_discrete", obj(objWLPointer, &WLPointerID), 8, words(WLPointerAxisVerticalScroll, 0xffffffff),
+			func() Event { return WLPointerAxisDiscrete{Axis: WLPointerAxisVerticalScroll, Discrete: -1} }},
+		{"wl_pointer.axis_value120", obj(objWLPointer, &WLPointerID), 9, words(WLPointerAxisVerticalScroll, 60),
+			func() Event { return WLPointerAxisValue120{Axis: WLPointerAxisVerticalScroll, Value120: 60} }},
+		{"wl_pointer.axis_relative_direction", obj(objWLPointer, &WLPointerID), 10,
+			words(WLPointerAxisVerticalScroll, WLPointerAxisRelativeDirectionInverted),
+			func() Event {
+				return WLPointerAxisRelativeDirection{Axis: WLPointerAxisVerticalScroll, Direction: WLPointerAxisRelativeDirectionInverted}
+			}},
+		{"wl_keyboard.keymap without one", keyboard, 0, words(WLKeyboardKeymapFormatNoKeymap, 0),
+			func() Event { return WLKeyboardKeymap{Format: WLKeyboardKeymapFormatNoKeymap, Err: errNoKeymap} }},
+		{"wl_keyboard.enter", obj(objWLKeyboard, &WLKeyboardID), 1, words(5, 3, 8, 30, 31),
+			func() Event { return WLKeyboardEnter{Serial: 5, Surface: 3, Keys: []uint32{30, 31}} }},
+		{"wl_keyboard.leave", obj(objWLKeyboard, &WLKeyboardID), 2, words(5, 3),
+			func() Event { return WLKeyboardLeave{Serial: 5, Surface: 3} }},
+		{"wl_keyboard.key", obj(objWLKeyboard, &WLKeyboardID), 3, words(5, 100, 30, WLKeyboardKeyStatePressed),
+			func() Event { return WLKeyboardKey{Serial: 5, Time: 100, Key: 30, State: WLKeyboardKeyStatePressed} }},
+		{"wl_keyboard.modifiers", obj(objWLKeyboard, &WLKeyboardID), 4, words(5, 1, 2, 4, 1),
+			func() Event { return WLKeyboardModifiers{Serial: 5, Depressed: 1, Latched: 2, Locked: 4, Group: 1} }},
+		{"wl_keyboard.repeat_info", obj(objWLKeyboard, &WLKeyboardID), 5, words(25, 600),
+			func() Event { return WLKeyboardRepeatInfo{Rate: 25, Delay: 600} }},
+		{"wl_touch.down", obj(objWLTouch, &WLTouchID), 0, words(5, 100, 3, 1, fixed1_5, fixed10),
+			func() Event { return WLTouchDown{Serial: 5, Time: 100, Surface: 3, ID: 1, X: 1.5, Y: 10} }},
+		{"wl_touch.up", obj(objWLTouch, &WLTouchID), 1, words(5, 100, 1),
+			func() Event { return WLTouchUp{Serial: 5, Time: 100, ID: 1} }},
+		{"wl_touch.motion", obj(objWLTouch, &WLTouchID), 2, words(100, 1, fixedMinus, fixed1_5),
+			func() Event { return WLTouchMotion{Time: 100, ID: 1, X: -2.25, Y: 1.5} }},
+		{"wl_touch.frame", touched, 3, nil,
+			func() Event { return WLTouchFrame{Points: []touchPoint{{ID: 4, Surface: 3, X: 1.5, Y: 10}}} }},
+		{"wl_touch.cancel", obj(objWLTouch, &WLTouchID), 4, nil,
+			func() Event { return WLTouchCancel{} }},
+		{"zwlr_output_power_v1.mode", outputPower, 0, words(1),
+			func() Event { return ZWLROutputPowerMode{Output: 42, Mode: 1} }},
+		{"zwlr_output_power_v1.failed", outputPower, 1, nil,
+			func() Event { return ZWLROutputPowerFailed{Output: 42} }},
+		{"zwlr_gamma_control_v1.gamma_size", gamma, 0, words(256),
+			func() Event { return ZWLRGammaControlGammaSize{Output: 42, Size: 256} }},
+		{"zwlr_gamma_control_v1.failed", gamma, 1, nil,
+			func() Event { return ZWLRGammaControlFailed{Output: 42} }},
+		{"zwp_keyboard_shortcuts_inhibitor_v1.active", inhibitor, 0, nil,
+			func() Event { return ZWPKeyboardShortcutsInhibitorActive{Seat: 42} }},
+		{"zwp_keyboard_shortcuts_inhibitor_v1.inactive", inhibitor, 1, nil,
+			func() Event { return ZWPKeyboardShortcutsInhibitorInactive{Seat: 42} }},
+		{"xdg_activation_token_v1.done", obj(objXDGActivationToken, &token), 0, appendStr(nil, "tok"),
+			func() Event { return XDGActivationTokenDone{Token: "tok"} }},
+		{"wp_image_description_v1.ready", imageDesc, 1, words(9),
+			func() Event { return WPImageDescriptionReady{ImageDescription: desc, Identity: 9} }},
+		{"wp_image_description_v1.failed", imageDesc, 0, appendStr(words(2), "unsupported"),
+			func() Event {
+				return WPImageDescriptionFailed{ImageDescription: desc, Cause: 2, Msg: "unsupported"}
+			}},
+		{"wl_data_device.enter", offer(0), 1, words(5, 3, fixed1_5, fixed10, serverIDMin),
+			func() Event {
+				return WLDataDeviceEnter{Serial: 5, Surface: 3, X: 1.5, Y: 10, Offer: serverIDMin, MimeTypes: []string{"text/plain"}}
+			}},
+		{"wl_data_device.leave", obj(objWLDataDevice, &WLDataDeviceID), 2, nil,
+			func() Event { return WLDataDeviceLeave{} }},
+		{"wl_data_device.motion", obj(objWLDataDevice, &WLDataDeviceID), 3, words(100, fixedMinus, fixed10),
+			func() Event { return WLDataDeviceMotion{Time: 100, X: -2.25, Y: 10} }},
+		{"wl_data_device.drop", entered(WLDataDeviceManagerDndActionMove), 4, nil,
+			func() Event { return WLDataDeviceDrop{Offer: serverIDMin, Action: WLDataDeviceManagerDndActionMove} }},
+		{"wl_data_device.selection", offer(0), 5, words(serverIDMin),
+			func() Event { return WLDataDeviceSelection{Offer: serverIDMin, MimeTypes: []string{"text/plain"}} }},
+		{"wl_data_device.selection cleared", obj(objWLDataDevice, &WLDataDeviceID), 5, words(0),
+			func() Event { return WLDataDeviceSelection{} }},
+		{"wl_data_offer.source_actions", serverObj, 1, words(WLDataDeviceManagerDndActionCopy),
+			func() Event {
+				return WLDataOfferSourceActions{Offer: serverIDMin, SourceActions: WLDataDeviceManagerDndActionCopy}
+			}},
+		{"wl_data_offer.action", serverObj, 2, words(WLDataDeviceManagerDndActionAsk),
+			func() Event { return WLDataOfferAction{Offer: serverIDMin, Action: WLDataDeviceManagerDndActionAsk} }},
+		{"wp_presentation_feedback.presented", obj(objWPPresentationFeedback, &feedback), 1,
+			words(1, 2, 3, 16666666, 0, 60, WPPresentationFeedbackKindVsync),
+			func() Event {
+				return WPPresentationFeedbackPresented{
+					Feedback: feedback,
+					Time:     (1<<32+2)*1e9 + 3,
+					Refresh:  16666666,
+					Seq:      60,
+					Flags:    WPPresentationFeedbackKindVsync,
+				}
+			}},
+		{"wp_presentation_feedback.discarded", obj(objWPPresentationFeedback, &feedback), 2, nil,
+			func() Event { return WPPresentationFeedbackDiscarded{Feedback: feedback} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			pointerFrame = WLPointerFrame{}
+			clear(touchPoints)
+			t.Cleanup(func() {
+				pointerFrame = WLPointerFrame{}
+				clear(touchPoints)
+			})
+			conn, _ := startMockCompositor(t, nil)
+			id := tc.setup(t, conn)
+			got, err := dispatchEvent(context.Background(), conn, id, tc.opcode, tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tc.want(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		})
+	}
+}
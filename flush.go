@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+type flushPolicyType uint8
+
+const (
+	// flushEveryRequest sends each request as soon as it's built.
+	flushEveryRequest flushPolicyType = iota
+	// flushOnDispatch queues requests until read is about to wait for
+	// events, so everything built between two reads goes out together.
+	flushOnDispatch
+	// flushManual queues requests until flush is called.
+	flushManual
+)
+
+// flushPolicy decides when queued requests hit the wire. Whatever the policy,
+// requests creating objects reserve their id when they're built, so ids stay
+// in the order the compositor will see them in.
+var flushPolicy = flushEveryRequest
+
+const (
+	// outBufMax and outFDsMax bound the queue, reaching either flushes
+	// regardless of the policy. outFDsMax matches libwayland's limit of fds
+	// per sendmsg.
+	outBufMax = 4096
+	outFDsMax = 28
+)
+
+// maxMsgSize is the largest message the 16 bit size in the header can hold.
+// makeMsgBuf doesn't check it, a bigger message's size overflows into the
+// opcode, so write and writeFD refuse them before anything is queued.
+const maxMsgSize = 1<<16 - 1
+
+var errMsgTooLarge = errors.New("request too large for the wire format")
+
+// msgTooLargeErr is returned by write and writeFD for a message over
+// maxMsgSize.
+type msgTooLargeErr struct {
+	id   uint32
+	size int
+}
+
+func (err msgTooLargeErr) Error() string {
+	return errMsgTooLarge.Error() + ": message for object " + strconv.FormatUint(uint64(err.id), 10) +
+		" is " + strconv.Itoa(err.size) + " bytes, the most is " + strconv.Itoa(maxMsgSize)
+}
+
+func (err msgTooLargeErr) Unwrap() error {
+	return errMsgTooLarge
+}
+
+func checkMsgSize(msg []byte) error {
+	if len(msg) <= maxMsgSize {
+		return nil
+	}
+	return msgTooLargeErr{id: binary.LittleEndian.Uint32(msg), size: len(msg)}
+}
+
+// outMu guards the queue, requests may be built on other goroutines than the
+// dispatcher's.
+var outMu sync.Mutex
+
+var (
+	outBuf  []byte
+	outFDs  []int
+	outMsgs uint64
+	// readsPrepared counts the prepareRead calls not yet ended by
+	// cancelRead, requests are sent right away while it's nonzero.
+	readsPrepared int
+)
+
+func write(conn *net.UnixConn, msg []byte) error {
+	err := checkMsgSize(msg)
+	if err != nil {
+		return err
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	outBuf = append(outBuf, msg...)
+	outMsgs++
+	recordRequest(msg)
+	if flushPolicy == flushEveryRequest || readsPrepared > 0 || len(outBuf) >= outBufMax {
+		return flushLocked(conn)
+	}
+	return nil
+}
+
+// writeFD writes msg passing fd along with it. fd is duplicated, so the
+// caller may close it right away even if the request is only queued.
+func writeFD(conn *net.UnixConn, msg []byte, fd int) error {
+	err := checkMsgSize(msg)
+	if err != nil {
+		return err
+	}
+	dup, err := unix.Dup(fd)
+	if err != nil {
+		return err
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	outFDs = append(outFDs, dup)
+	outBuf = append(outBuf, msg...)
+	outMsgs++
+	recordRequest(msg)
+	if flushPolicy == flushEveryRequest || readsPrepared > 0 || len(outBuf) >= outBufMax || len(outFDs) >= outFDsMax {
+		return flushLocked(conn)
+	}
+	return nil
+}
+
+// flush sends all queued requests.
+func flush(conn *net.UnixConn) error {
+	outMu.Lock()
+	defer outMu.Unlock()
+	return flushLocked(conn)
+}
+
+func flushLocked(conn *net.UnixConn) error {
+	if len(outBuf) == 0 {
+		return nil
+	}
+	var oob []byte
+	if len(outFDs) > 0 {
+		oob = unix.UnixRights(outFDs...)
+	}
+	// A stream socket may take only part of the buffer, the rest is sent
+	// by further sendmsgs. The fds go along with the first bytes, and
+	// whatever wasn't sent when one fails stays queued.
+	for len(outBuf) > 0 {
+		n, _, err := conn.WriteMsgUnix(outBuf, oob, nil)
+		counters.bytesOut.Add(uint64(n))
+		if n > 0 && oob != nil {
+			counters.fdsOut.Add(uint64(len(outFDs)))
+			for _, fd := range outFDs {
+				unix.Close(fd)
+			}
+			outFDs = outFDs[:0]
+			oob = nil
+		}
+		outBuf = outBuf[:copy(outBuf, outBuf[n:])]
+		if err != nil {
+			return err
+		}
+	}
+	counters.msgsOut.Add(outMsgs)
+	outMsgs = 0
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteRefusesOversized(t *testing.T) {
+	resetTestState(t)
+	client, _ := newConnPair(t)
+	msgs := outMsgs
+
+	big := makeMsgBuf(WLDisplayID, 0, maxMsgSize+1-HEADER_SIZE)
+	big = big[:cap(big)]
+	err := write(client, big)
+	if !errors.Is(err, errMsgTooLarge) {
+		t.Errorf("write: got %v, want %v", err, errMsgTooLarge)
+	}
+	err = writeFD(client, big, int(os.Stdin.Fd()))
+	if !errors.Is(err, errMsgTooLarge) {
+		t.Errorf("writeFD: got %v, want %v", err, errMsgTooLarge)
+	}
+	outMu.Lock()
+	queued, fds := len(outBuf), len(outFDs)
+	outMu.Unlock()
+	if outMsgs != msgs || queued != 0 || fds != 0 {
+		t.Errorf("refused messages queued: %d messages, %d bytes, %d fds", outMsgs-msgs, queued, fds)
+	}
+
+	largest := makeMsgBuf(WLDisplayID, 0, maxMsgSize&^(WORD_SIZE-1)-HEADER_SIZE)
+	largest = largest[:cap(largest)]
+	err = write(client, largest)
+	if err != nil {
+		t.Errorf("writing a message of %d bytes: %v", len(largest), err)
+	}
+}
+
+// sentBytes returns how many bytes reached server, waiting a little for
+// them.
+func sentBytes(tb testing.TB, server *net.UnixConn) int {
+	tb.Helper()
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	defer server.SetReadDeadline(time.Time{})
+	buf := make([]byte, 1<<16)
+	total := 0
+	for {
+		n, err := server.Read(buf)
+		total += n
+		if err != nil {
+			return total
+		}
+	}
+}
+
+func TestFlushPolicies(t *testing.T) {
+	sync := makeMsgBuf(WLDisplayID, 0, WORD_SIZE)
+	sync = binary.LittleEndian.AppendUint32(sync, 2)
+	// A delete_id for read to return once it's done flushing.
+	event := makeMsgBuf(WLDisplayID, 1, WORD_SIZE)
+	event = binary.LittleEndian.AppendUint32(event, 2)
+	for _, tt := range []struct {
+		name            string
+		policy          flushPolicyType
+		byWrite, byRead bool
+	}{
+		{"every request", flushEveryRequest, true, true},
+		{"on dispatch", flushOnDispatch, false, true},
+		{"manual", flushManual, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			client, server := newConnPair(t)
+			flushPolicy = tt.policy
+
+			err := write(client, sync)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sentBytes(t, server) > 0; got != tt.byWrite {
+				t.Errorf("sent by write: %t, want %t", got, tt.byWrite)
+			}
+			_, err = server.Write(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, body, err := read(client)
+			releaseBody(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sentBytes(t, server) > 0; got != (tt.byRead && !tt.byWrite) {
+				t.Errorf("sent by read: %t, want %t", got, tt.byRead && !tt.byWrite)
+			}
+			err = flush(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sentBytes(t, server)
+
+			if tt.policy == flushEveryRequest {
+				return
+			}
+			// Whatever the policy, a full queue is sent.
+			for range outBufMax/len(sync) + 1 {
+				err = write(client, sync)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := sentBytes(t, server) > 0; !got {
+				t.Error("nothing sent once outBufMax is reached")
+			}
+		})
+	}
+}
+
+func TestFlushPartialWrites(t *testing.T) {
+	resetTestState(t)
+	client, server := newConnPair(t)
+	// Much more than the socket buffers hold, so sendmsg takes it in
+	// parts.
+	err := client.SetWriteBuffer(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 1<<20)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	fd, err := unix.Dup(int(os.Stdin.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outMu.Lock()
+	outBuf = append(outBuf[:0], want...)
+	outFDs = append(outFDs[:0], fd)
+	outMu.Unlock()
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- flush(client) }()
+	got := make([]byte, 0, len(want))
+	chunk := make([]byte, 1<<16)
+	oob := make([]byte, unix.CmsgSpace(4*outFDsMax))
+	var fds []int
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) < len(want) {
+		n, oobn, _, _, err := server.ReadMsgUnix(chunk, oob)
+		if err != nil {
+			t.Fatalf("after %d bytes: %v", len(got), err)
+		}
+		got = append(got, chunk[:n]...)
+		cmsgs, _ := unix.ParseSocketControlMessage(oob[:oobn])
+		for _, cmsg := range cmsgs {
+			rights, _ := unix.ParseUnixRights(&cmsg)
+			fds = append(fds, rights...)
+		}
+	}
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+	err = <-flushed
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("bytes lost or reordered")
+	}
+	if len(fds) != 1 {
+		t.Errorf("got %d fds, want 1", len(fds))
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	if len(outBuf) != 0 || len(outFDs) != 0 {
+		t.Errorf("%d bytes and %d fds still queued", len(outBuf), len(outFDs))
+	}
+}
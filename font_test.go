@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// glyphA is 'A' as drawn at 0, 0.
+var glyphA = []string{
+	"........",
+	"..###...",
+	"..###...",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	".#####..",
+	".#####..",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	".#...#..",
+	"........",
+}
+
+func TestDrawText(t *testing.T) {
+	const color = 0xffffffff
+	// shifted returns glyphA drawn at x, y on a width×height canvas.
+	shifted := func(x, y, width, height int) []string {
+		rows := make([]string, height)
+		for r := range rows {
+			row := []byte(strings.Repeat(".", width))
+			if g := r - y; g >= 0 && g < len(glyphA) {
+				for col := range glyphWidth {
+					if cx := x + col; cx >= 0 && cx < width {
+						row[cx] = glyphA[g][col]
+					}
+				}
+			}
+			rows[r] = string(row)
+		}
+		return rows
+	}
+	for _, tt := range []struct {
+		name          string
+		width, height int32
+		x, y          int32
+		s             string
+		want          []string
+	}{
+		{"A", 8, 16, 0, 0, "A", glyphA},
+		{"A offset", 10, 18, 1, 2, "A", shifted(1, 2, 10, 18)},
+		{"A clipped at the left", 8, 16, -3, 0, "A", shifted(-3, 0, 8, 16)},
+		{"A clipped at the bottom right", 8, 16, 4, 9, "A", shifted(4, 9, 8, 16)},
+		{"A on the second line", 8, 32, 0, 0, "\nA", shifted(0, 16, 8, 32)},
+		{"space", 8, 16, 0, 0, " ", slices.Repeat([]string{"........"}, 16)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCanvas(tt.width, tt.height, 0)
+			c.drawText(tt.x, tt.y, tt.s, color)
+			if got := c.picture(color); !slices.Equal(got, tt.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "time"
+
+// frameClock decouples a fixed-rate simulation from the display refresh.
+// Fed the timestamp of every frame callback, it runs the update as many
+// times as whole ticks have passed and returns how far into the next tick
+// the frame is, for the renderer to interpolate between the last two
+// simulation states.
+type frameClock struct {
+	tick time.Duration
+	// maxTicks caps the updates run for one frame, so a long stall (the
+	// window being hidden, a debugger, ...) doesn't turn into a burst of
+	// catch-up updates.
+	maxTicks int
+
+	started bool
+	last    uint32
+	acc     time.Duration
+}
+
+// newFrameClock returns a frameClock running tickRate updates per second.
+func newFrameClock(tickRate int) *frameClock {
+	return &frameClock{tick: time.Second / time.Duration(tickRate), maxTicks: 8}
+}
+
+// advance feeds the millisecond timestamp of a frame callback, calls update
+// once per tick elapsed since the previous one, and returns the
+// interpolation alpha in [0, 1).
+func (c *frameClock) advance(ms uint32, update func(dt time.Duration)) (alpha float64) {
+	if !c.started {
+		c.started = true
+		c.last = ms
+		return 0
+	}
+	// uint32 subtraction keeps working across the timestamp wrapping around.
+	c.acc += time.Duration(ms-c.last) * time.Millisecond
+	c.last = ms
+	for n := 0; c.acc >= c.tick; n++ {
+		if n == c.maxTicks {
+			c.acc = 0
+			break
+		}
+		update(c.tick)
+		c.acc -= c.tick
+	}
+	return float64(c.acc) / float64(c.tick)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFrameClock(t *testing.T) {
+	type frame struct {
+		ms uint32
+		// updates run and alpha returned for the frame.
+		updates int
+		alpha   float64
+	}
+	for _, tt := range []struct {
+		name     string
+		tickRate int
+		frames   []frame
+	}{
+		{"ticks slower than frames", 50, []frame{
+			{0, 0, 0},
+			{16, 0, 0.8},
+			{32, 1, 0.6},
+			{48, 1, 0.4},
+			{64, 1, 0.2},
+		}},
+		{"ticks faster than frames", 100, []frame{
+			{0, 0, 0},
+			{16, 1, 0.6},
+			{33, 2, 0.3},
+			{49, 1, 0.9},
+		}},
+		{"tick on a frame", 50, []frame{
+			{100, 0, 0},
+			{120, 1, 0},
+			{160, 2, 0},
+		}},
+		{"stall capped", 50, []frame{
+			{0, 0, 0},
+			{10, 0, 0.5},
+			{1010, 8, 0},
+			{1020, 0, 0.5},
+		}},
+		{"timestamp wrapping around", 50, []frame{
+			{math.MaxUint32 - 15, 0, 0},
+			{4, 1, 0},
+			{14, 0, 0.5},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFrameClock(tt.tickRate)
+			tick := time.Second / time.Duration(tt.tickRate)
+			for _, f := range tt.frames {
+				var dts []time.Duration
+				alpha := c.advance(f.ms, func(dt time.Duration) { dts = append(dts, dt) })
+				if len(dts) != f.updates || math.Abs(alpha-f.alpha) > 1e-9 {
+					t.Errorf("frame at %dms: got %d updates and alpha %v, want %d and %v", f.ms, len(dts), alpha, f.updates, f.alpha)
+				}
+				if slices.ContainsFunc(dts, func(dt time.Duration) bool { return dt != tick }) {
+					t.Errorf("frame at %dms: updates got %v, want each a tick of %v", f.ms, dts, tick)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// fifo-v1 and commit-timing-v1 let the compositor pace commits instead of
+// the app waiting on frame callbacks: a commit waiting on a fifo barrier is
+// only applied after the previous one set by a commit got presented, and a
+// commit with a timestamp isn't presented before that time.
+
+var (
+	errNoFifo         = unsupportedGlobalErr("wp_fifo_manager_v1")
+	errNoCommitTiming = unsupportedGlobalErr("wp_commit_timing_manager_v1")
+)
+
+// setFifoBarrier stages a fifo barrier and/or a wait on the previous barrier
+// for the next commit. Setting both on every commit gives vsync-throttled
+// presentation where each commit is shown for at least one refresh cycle.
+func setFifoBarrier(barrier, wait bool) error {
+	if WPFifoManagerID == 0 {
+		return errNoFifo
+	}
+	pendingSurface.fifoBarrier = barrier
+	pendingSurface.fifoWait = wait
+	return nil
+}
+
+// setCommitTime stages the earliest time, in nanoseconds of the
+// presentation clock (wp_presentation::clock_id, normally
+// CLOCK_MONOTONIC), the next commit may be presented at.
+func setCommitTime(nsec uint64) error {
+	if WPCommitTimingManagerID == 0 {
+		return errNoCommitTiming
+	}
+	// 0 means no timestamp here, and is never a valid target anyway.
+	if nsec == 0 {
+		return errors.New("wp_commit_timer_v1: invalid timestamp")
+	}
+	// A second set_timestamp before the commit is the timestamp_exists
+	// error, staging just keeps the latest.
+	pendingSurface.commitTime = nsec
+	return nil
+}
+
+func mustSendFifo(conn *net.UnixConn, barrier, wait bool) {
+	if WPFifoID == 0 {
+		buf := makeMsgBuf(WPFifoManagerID, 1, WORD_SIZE*2)
+		WPFifoID = regChildObj(objWPFifo, WPFifoManagerID)
+		buf = binary.LittleEndian.AppendUint32(buf, WPFifoID)
+		buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if barrier {
+		err := write(conn, makeMsgBuf(WPFifoID, 0, 0))
+		if err != nil {
+			panic(err)
+		}
+	}
+	if wait {
+		err := write(conn, makeMsgBuf(WPFifoID, 1, 0))
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func mustSetCommitTimestamp(conn *net.UnixConn, nsec uint64) {
+	if WPCommitTimerID == 0 {
+		buf := makeMsgBuf(WPCommitTimingManagerID, 1, WORD_SIZE*2)
+		WPCommitTimerID = regChildObj(objWPCommitTimer, WPCommitTimingManagerID)
+		buf = binary.LittleEndian.AppendUint32(buf, WPCommitTimerID)
+		buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	sec := nsec / 1e9
+	buf := makeMsgBuf(WPCommitTimerID, 0, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sec>>32))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sec))
+	// Always below 1e9, anything else is the invalid_timestamp error.
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(nsec%1e9))
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
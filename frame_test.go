@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// awaitFrameDone reads events until the next frame callback is done.
+func awaitFrameDone(tb testing.TB, conn *net.UnixConn) {
+	tb.Helper()
+	for {
+		if _, ok := nextEvent(tb, conn).(WLSurfaceFrameDone); ok {
+			return
+		}
+	}
+}
+
+// BenchmarkFrame measures a whole frame against a mockCompositor: the
+// frame request, attach, damage and commit of mustDraw, then reading up to
+// the frame callback being done, with 1 to 3 buffers drawn round robin.
+func BenchmarkFrame(b *testing.B) {
+	for buffers := 1; buffers <= 3; buffers++ {
+		b.Run(strconv.Itoa(buffers)+"buffers", func(b *testing.B) {
+			benchmarkFrame(b, buffers)
+		})
+	}
+}
+
+func benchmarkFrame(b *testing.B, buffers int) {
+	conn, _ := newMockSurface(b, nil)
+	size := len(WLShmPoolBuf)
+	ids := []uint32{WLBufferID}
+	mems := [][]byte{WLShmPoolBuf}
+	for range buffers - 1 {
+		id, f, mem, err := createPool(conn, int64(size))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() {
+			unix.Munmap(mem)
+			f.Close()
+		})
+		buf, err := createBuffer(conn, id, size, shmBuffer{
+			width:  int32(bufWidth),
+			height: int32(bufHeight),
+			stride: int32(bufWidth * 4),
+			format: bufFormat,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, buf)
+		mems = append(mems, mem)
+	}
+	first := WLShmPoolBuf
+	b.Cleanup(func() { WLShmPoolBuf = first })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		WLBufferID, WLShmPoolBuf = ids[i%buffers], mems[i%buffers]
+		mustDraw(conn)
+		awaitFrameDone(b, conn)
+	}
+}
+
+func TestDrawCycle(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	for range 3 {
+		mustDraw(conn)
+		if !framePending() || !bufferBusy() {
+			t.Fatal("mustDraw didn't request a frame and commit the buffer")
+		}
+		ev := nextEvent(t, conn)
+		if rel, ok := ev.(WLBufferRelease); !ok || rel.Buffer != WLBufferID {
+			t.Fatalf("got %#v, want the release of buffer %d", ev, WLBufferID)
+		}
+		awaitFrameDone(t, conn)
+		if framePending() || bufferBusy() {
+			t.Fatal("frame still pending or buffer busy after the callback")
+		}
+	}
+}
+
+func TestRequestFrame(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// steps are requestFrame, mustFrame and mustCommit calls, by name.
+		steps []string
+		// want is the time each requestFrame channel gets, in order.
+		want []uint32
+	}{
+		{"one", []string{"request", "commit"}, []uint32{16}},
+		{"two in one commit", []string{"request", "request", "commit"}, []uint32{16, 16}},
+		{"in commit order", []string{"request", "commit", "request", "commit"}, []uint32{16, 32}},
+		{"not replaced by mustFrame", []string{"request", "frame", "commit"}, []uint32{16}},
+		{"not replacing mustFrame", []string{"frame", "request", "frame", "commit"}, []uint32{16}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := newMockSurface(t, nil)
+			t.Cleanup(resetObjects)
+			var chans []<-chan uint32
+			for _, step := range tt.steps {
+				switch step {
+				case "request":
+					chans = append(chans, requestFrame(conn))
+				case "frame":
+					mustFrame(conn)
+				case "commit":
+					mustCommit(conn)
+				}
+			}
+			mustRoundtrip(t, conn)
+			for i, ch := range chans {
+				select {
+				case ms := <-ch:
+					if ms != tt.want[i] {
+						t.Errorf("request %d: got %d, want %d", i, ms, tt.want[i])
+					}
+				default:
+					t.Fatalf("request %d: no frame done", i)
+				}
+				if ms, ok := <-ch; ok {
+					t.Errorf("request %d: got a second value %d", i, ms)
+				}
+			}
+			if framePending() {
+				t.Error("mustFrame's callback done but still pending")
+			}
+		})
+	}
+}
+
+func TestCommitAndWait(t *testing.T) {
+	const timeout = time.Second
+	for _, tt := range []struct {
+		name   string
+		hidden bool
+		want   uint32
+		err    error
+	}{
+		{"shown", false, 16, nil},
+		{"hidden", true, 0, errFrameTimeout},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, m := newMockSurface(t, nil)
+			t.Cleanup(resetObjects)
+			fake := newFakeClock(time.Unix(0, 0))
+			clk = fake
+			m.hidden.Store(tt.hidden)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events := startDispatcher(ctx, conn)
+			t.Cleanup(func() {
+				disconnect(conn)
+				for range events {
+				}
+			})
+			type result struct {
+				ms  uint32
+				err error
+			}
+			wait := func() result {
+				t.Helper()
+				done := make(chan result, 1)
+				go func() {
+					ms, err := commitAndWait(conn, timeout)
+					done <- result{ms, err}
+				}()
+				if d := awaitTimer(t, fake); d != timeout {
+					t.Fatalf("waiting %v, want %v", d, timeout)
+				}
+				if m.hidden.Load() {
+					fake.advance(timeout)
+				}
+				select {
+				case r := <-done:
+					return r
+				case <-time.After(5 * time.Second):
+					t.Fatal("commitAndWait didn't return")
+					return result{}
+				}
+			}
+
+			if r := wait(); r.ms != tt.want || r.err != tt.err {
+				t.Fatalf("got %d, %v, want %d, %v", r.ms, r.err, tt.want, tt.err)
+			}
+			// A callback done after the timeout is dropped, the next commit
+			// waits for its own.
+			if tt.hidden {
+				m.show()
+			}
+			if r := wait(); r.ms != 32 || r.err != nil {
+				t.Errorf("next commit: got %d, %v, want 32", r.ms, r.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+type gammaControl struct {
+	id uint32
+	// size is the number of entries in each ramp, 0 until gamma_size
+	// arrives.
+	size uint32
+}
+
+// gammaMu guards zwlrGammaControls and their sizes, which the dispatcher
+// goroutine updates.
+var gammaMu sync.Mutex
+
+// zwlrGammaControls maps a wl_output id to its zwlr_gamma_control_v1.
+var zwlrGammaControls = map[uint32]*gammaControl{}
+
+var errGammaSizeUnknown = errors.New("zwlr_gamma_control_v1: gamma_size not received yet")
+
+func mustGetGammaControl(conn *net.UnixConn, output uint32) *gammaControl {
+	gammaMu.Lock()
+	defer gammaMu.Unlock()
+	if gc, ok := zwlrGammaControls[output]; ok {
+		return gc
+	}
+	buf := makeMsgBuf(ZWLRGammaControlManagerID, 0, WORD_SIZE*2)
+	gc := &gammaControl{id: regChildObj(objZWLRGammaControl, ZWLRGammaControlManagerID)}
+	buf = binary.LittleEndian.AppendUint32(buf, gc.id)
+	buf = binary.LittleEndian.AppendUint32(buf, output)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	zwlrGammaControls[output] = gc
+	return gc
+}
+
+// setGamma sets the gamma ramps of output. Each ramp must have exactly the
+// number of entries given by the gamma_size event, so the gamma control has
+// to be created (mustGetGammaControl) and the event received before calling.
+func setGamma(conn *net.UnixConn, output uint32, red, green, blue []uint16) error {
+	gc := mustGetGammaControl(conn, output)
+	gammaMu.Lock()
+	size := gc.size
+	gammaMu.Unlock()
+	if size == 0 {
+		return errGammaSizeUnknown
+	}
+	if len(red) != int(size) || len(green) != int(size) || len(blue) != int(size) {
+		return errors.New("zwlr_gamma_control_v1: ramps must have " + strconv.FormatUint(uint64(size), 10) + " entries")
+	}
+
+	table := make([]byte, 0, 3*2*size)
+	for _, ramp := range [][]uint16{red, green, blue} {
+		for _, v := range ramp {
+			table = binary.LittleEndian.AppendUint16(table, v)
+		}
+	}
+	f, err := writeTempFile("zwlr_gamma_control", table)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := makeMsgBuf(gc.id, 0, 0)
+	err = writeFD(conn, buf, int(f.Fd()))
+	return err
+}
+
+func mustDestroyGammaControl(conn *net.UnixConn, id uint32) {
+	buf := makeMsgBuf(id, 1, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleZWLRGammaControlEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	gammaMu.Lock()
+	defer gammaMu.Unlock()
+	var output uint32
+	var gc *gammaControl
+	for o, c := range zwlrGammaControls {
+		if c.id == id {
+			output, gc = o, c
+			break
+		}
+	}
+	if gc == nil {
+		return nil
+	}
+	switch opcode {
+	case 0: // gamma_size
+		gc.size = binary.LittleEndian.Uint32(body)
+		return ZWLRGammaControlGammaSize{Output: output, Size: gc.size}
+	case 1: // failed
+		// Gamma can't be set on this output (gone, unsupported or taken by
+		// another client), the object is inert and only good for destroying.
+		delete(zwlrGammaControls, output)
+		mustDestroyGammaControl(conn, id)
+		return ZWLRGammaControlFailed{Output: output}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// logUnhandled logs a message no handler decoded. With debug logging enabled
+// the body is also dumped as words by dumpWords, for working out the layout
+// of events not typed yet.
+func logUnhandled(ctx context.Context, msg string, id, opcode uint32, body []byte) {
+	attrs := []any{"id", id, "opcode", opcode, "body", hex.EncodeToString(body)}
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		attrs = append(attrs, "words", dumpWords(body))
+	}
+	slog.InfoContext(ctx, msg, attrs...)
+}
+
+// dumpWords formats body as the uint32 words the wire format is made of,
+// each in hex, then as a signed number and as text where those make sense:
+// small numbers are likely ints or enums, printable bytes a string's. A
+// trailing partial word is shown in hex alone. For example a string argument
+// "abc" dumps as
+//
+//	0x00000004 4, 0x00636261 "abc."
+func dumpWords(body []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(body); i += 4 {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if len(body)-i < 4 {
+			b.WriteString(hex.EncodeToString(body[i:]))
+			break
+		}
+		w := binary.LittleEndian.Uint32(body[i:])
+		b.WriteString("0x")
+		b.WriteString(strconv.FormatUint(uint64(w)|1<<32, 16)[1:])
+		if n := int32(w); n > -1<<16 && n < 1<<16 {
+			b.WriteString(" " + strconv.Itoa(int(n)))
+		} else if text, ok := wordText(body[i : i+4]); ok {
+			b.WriteString(" " + strconv.Quote(text))
+		}
+	}
+	return b.String()
+}
+
+// wordText returns word as text, NULs shown as dots, if it's printable ASCII
+// with at least one letter.
+func wordText(word []byte) (string, bool) {
+	text := make([]byte, len(word))
+	letters := 0
+	for i, c := range word {
+		switch {
+		case c == 0:
+			text[i] = '.'
+		case c < ' ' || c > '~':
+			return "", false
+		default:
+			text[i] = c
+			letters++
+		}
+	}
+	return string(text), letters > 0
+}
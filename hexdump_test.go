@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestDumpWords(t *testing.T) {
+	for _, tt := range []struct {
+		body []byte
+		want string
+	}{
+		{nil, ""},
+		{[]byte{4, 0, 0, 0, 'a', 'b', 'c', 0}, `0x00000004 4, 0x00636261 "abc."`},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, "0xffffffff -1"},
+		{[]byte{0, 0, 0, 0x80}, "0x80000000"},
+		{[]byte{1, 0, 0, 0, 0xab, 0xcd}, "0x00000001 1, abcd"},
+	} {
+		if got := dumpWords(tt.body); got != tt.want {
+			t.Errorf("dumpWords(%v) = %s, want %s", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"image"
+	_ "image/png"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// loadImageBuffer decodes the image file at path, PNG or any other format
+// registered with the image package, into a new ARGB8888 buffer of its size,
+// for image viewers and splash screens. The buffer owns its memory, nothing
+// stays mapped client side, and it can be attached as often as needed until
+// destroyed.
+func loadImageBuffer(conn *net.UnixConn, path string) (id uint32, w, h int32, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return 0, 0, 0, errors.New("decoding " + path + ": " + err.Error())
+	}
+	bounds := img.Bounds()
+	w, h = int32(bounds.Dx()), int32(bounds.Dy())
+	b := shmBuffer{width: w, height: h, stride: alignStride(w, WLShmFormatARGB8888), format: WLShmFormatARGB8888}
+	pool, poolFile, mem, err := createPool(conn, int64(b.stride)*int64(h))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer poolFile.Close()
+	defer unix.Munmap(mem)
+	putImage(mem, int(b.stride), img)
+	id, err = createBuffer(conn, pool, len(mem), b)
+	// The buffer keeps the memory alive, the pool isn't needed anymore.
+	destroyErr := write(conn, makeMsgBuf(pool, 1, 0))
+	return id, w, h, errors.Join(err, destroyErr)
+}
+
+// putImage writes img into dst as ARGB8888, that is premultiplied BGRA bytes,
+// with rows stride bytes apart.
+func putImage(dst []byte, stride int, img image.Image) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := dst[(y-bounds.Min.Y)*stride:]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// RGBA returns premultiplied components too.
+			r, g, b, a := img.At(x, y).RGBA()
+			i := (x - bounds.Min.X) * 4
+			row[i+0] = byte(b >> 8)
+			row[i+1] = byte(g >> 8)
+			row[i+2] = byte(r >> 8)
+			row[i+3] = byte(a >> 8)
+		}
+	}
+}
+
+// uploadPixels copies h rows of w 4-byte pixels from src, whose rows are
+// srcStride bytes apart, to dst, whose rows are dstStride bytes apart. Only
+// the pixels are copied, the padding past each row in dst is left as is. The
+// pixels have to be in dst's format already.
+func uploadPixels(dst, src []byte, w, h, srcStride, dstStride int) error {
+	if w < 0 || h < 0 {
+		return errors.New("uploadPixels: negative size " + strconv.Itoa(w) + "x" + strconv.Itoa(h))
+	}
+	row := w * 4
+	if srcStride < row || dstStride < row {
+		return errors.New("uploadPixels: stride shorter than a row of " + strconv.Itoa(w) + " pixels")
+	}
+	if h == 0 {
+		return nil
+	}
+	// The last row needn't be padded.
+	if len(src) < (h-1)*srcStride+row || len(dst) < (h-1)*dstStride+row {
+		return errors.New("uploadPixels: buffer too short for " + strconv.Itoa(h) + " rows")
+	}
+	if srcStride == row && dstStride == row {
+		copy(dst, src[:h*row])
+		return nil
+	}
+	for y := range h {
+		copy(dst[y*dstStride:y*dstStride+row], src[y*srcStride:])
+	}
+	return nil
+}
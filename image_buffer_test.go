@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadPixels(t *testing.T) {
+	// 2x2 pixels, src rows padded to 12 bytes and dst rows to 16.
+	src := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 0xee, 0xee, 0xee, 0xee,
+		9, 10, 11, 12, 13, 14, 15, 16,
+	}
+	dst := bytes.Repeat([]byte{0xff}, 32)
+	err := uploadPixels(dst, src, 2, 2, 12, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		9, 10, 11, 12, 13, 14, 15, 16, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	if !bytes.Equal(dst, want) {
+		t.Errorf("got\n%v\nwant\n%v", dst, want)
+	}
+
+	packed := make([]byte, 16)
+	err = uploadPixels(packed, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, 2, 2, 8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packed, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}) {
+		t.Errorf("got %v copying between packed rows", packed)
+	}
+
+	for _, tt := range []struct {
+		name                       string
+		dst, src                   []byte
+		w, h, srcStride, dstStride int
+	}{
+		{"negative size", dst, src, -1, 2, 12, 16},
+		{"short stride", dst, src, 4, 2, 12, 16},
+		{"short src", dst, src[:16], 2, 2, 12, 16},
+		{"short dst", dst[:20], src, 2, 2, 12, 16},
+	} {
+		err := uploadPixels(tt.dst, tt.src, tt.w, tt.h, tt.srcStride, tt.dstStride)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestPutImage(t *testing.T) {
+	// Bounds off the origin, the image still lands at dst's start.
+	img := image.NewNRGBA(image.Rect(1, 1, 3, 2))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	img.Set(2, 1, color.NRGBA{R: 1, G: 2, B: 3, A: 255})
+	dst := make([]byte, 12)
+	putImage(dst, 12, img)
+	// Premultiplied BGRA.
+	want := []byte{0, 0, 128, 128, 3, 2, 1, 255, 0, 0, 0, 0}
+	if !bytes.Equal(dst, want) {
+		t.Errorf("got %v, want %v", dst, want)
+	}
+}
+
+func TestLoadImageBuffer(t *testing.T) {
+	resetTestState(t)
+	conn, _ := startMockCompositor(t, nil)
+	WLShmID = regObjVersion(objWLShm, 1)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "splash.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 3, 2)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, w, h, err := loadImageBuffer(conn, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 3 || h != 2 || objTypeOf(id) != objWLBuffer {
+		t.Errorf("got %s %d of %dx%d, want a wl_buffer of 3x2", describeObj(id), id, w, h)
+	}
+
+	notImage := filepath.Join(dir, "notes.txt")
+	err = os.WriteFile(notImage, []byte("not an image"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{filepath.Join(dir, "missing.png"), notImage} {
+		_, _, _, err = loadImageBuffer(conn, bad)
+		if err == nil {
+			t.Errorf("loaded %s", bad)
+		}
+	}
+}
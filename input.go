@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// wl_seat::capability
+const (
+	WLSeatCapabilityPointer  = 1
+	WLSeatCapabilityKeyboard = 2
+	WLSeatCapabilityTouch    = 4
+)
+
+// handleWLSeatEvent handles the events of every seat, only WLSeatID gets its
+// input devices created.
+func handleWLSeatEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // capabilities
+		caps := binary.LittleEndian.Uint32(body)
+		seatCaps[id] = caps
+		if id == WLSeatID {
+			mustGetSeatDevices(conn, caps)
+		}
+		return WLSeatCapabilities{Seat: id, Capabilities: caps}
+	case 1: // name
+		name, _ := parseStr(body)
+		seatNames[id] = string(name)
+		if seatName != "" && string(name) == seatName && id != WLSeatID {
+			mustSwitchSeat(conn, id)
+		}
+		return WLSeatName{Seat: id, Name: string(name)}
+	}
+	return nil
+}
+
+// mustGetSeatDevices creates the input devices of WLSeatID it has caps for
+// and doesn't have yet, and its data device.
+func mustGetSeatDevices(conn *net.UnixConn, caps uint32) {
+	if caps&WLSeatCapabilityPointer != 0 && WLPointerID == 0 {
+		mustGetPointer(conn)
+	}
+	if caps&WLSeatCapabilityKeyboard != 0 && WLKeyboardID == 0 {
+		mustGetKeyboard(conn)
+	}
+	if caps&WLSeatCapabilityTouch != 0 && WLTouchID == 0 {
+		mustGetTouch(conn)
+	}
+	if WLDataDeviceManagerID != 0 && WLDataDeviceID == 0 {
+		mustGetDataDevice(conn)
+	}
+}
+
+func mustGetPointer(conn *net.UnixConn) {
+	buf := makeMsgBuf(WLSeatID, 0, WORD_SIZE)
+	WLPointerID = regChildObj(objWLPointer, WLSeatID)
+	buf = binary.LittleEndian.AppendUint32(buf, WLPointerID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustGetKeyboard(conn *net.UnixConn) {
+	buf := makeMsgBuf(WLSeatID, 1, WORD_SIZE)
+	WLKeyboardID = regChildObj(objWLKeyboard, WLSeatID)
+	buf = binary.LittleEndian.AppendUint32(buf, WLKeyboardID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustGetTouch(conn *net.UnixConn) {
+	buf := makeMsgBuf(WLSeatID, 2, WORD_SIZE)
+	WLTouchID = regChildObj(objWLTouch, WLSeatID)
+	buf = binary.LittleEndian.AppendUint32(buf, WLTouchID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// pointerSerials are the serials of a seat's latest pointer events that
+// requests need: set_cursor takes the enter serial, move, resize and popup
+// grabs the button one.
+type pointerSerials struct {
+	enter, button uint32
+}
+
+var (
+	pointerSerialsMu sync.Mutex
+	// pointerSerialsBySeat only has WLSeatID for now, the one seat whose
+	// pointer is bound.
+	pointerSerialsBySeat = map[uint32]pointerSerials{}
+)
+
+// lastPointerEnterSerial returns the serial of the latest pointer enter on
+// seat, for set_cursor. 0 if the pointer never entered.
+func lastPointerEnterSerial(seat uint32) uint32 {
+	pointerSerialsMu.Lock()
+	defer pointerSerialsMu.Unlock()
+	return pointerSerialsBySeat[seat].enter
+}
+
+// lastPointerButtonSerial returns the serial of the latest button press or
+// release on seat, for move, resize and popup grabs. 0 if there was none.
+func lastPointerButtonSerial(seat uint32) uint32 {
+	pointerSerialsMu.Lock()
+	defer pointerSerialsMu.Unlock()
+	return pointerSerialsBySeat[seat].button
+}
+
+var (
+	focusMu sync.Mutex
+	// keyboardFocus and pointerFocus are the surfaces with WLSeatID's
+	// keyboard and pointer focus, 0 for none.
+	keyboardFocus, pointerFocus uint32
+)
+
+// hasKeyboardFocus reports whether surface has the keyboard focus, e.g. to
+// stop blinking a text cursor while it doesn't.
+func hasKeyboardFocus(surface uint32) bool {
+	focusMu.Lock()
+	defer focusMu.Unlock()
+	return surface != 0 && keyboardFocus == surface
+}
+
+// hasPointerFocus reports whether the pointer is over surface.
+func hasPointerFocus(surface uint32) bool {
+	focusMu.Lock()
+	defer focusMu.Unlock()
+	return surface != 0 && pointerFocus == surface
+}
+
+// setFocus sets *focus to surface, or to 0 if leaving is set and surface
+// has it, as a leave may come after the focus moved on.
+func setFocus(focus *uint32, surface uint32, leaving bool) {
+	focusMu.Lock()
+	defer focusMu.Unlock()
+	switch {
+	case !leaving:
+		*focus = surface
+	case *focus == surface:
+		*focus = 0
+	}
+}
+
+// forgetFocus drops the focus of a deleted surface, so its id isn't seen
+// focused once reused.
+func forgetFocus(surface uint32) {
+	focusMu.Lock()
+	defer focusMu.Unlock()
+	if keyboardFocus == surface {
+		keyboardFocus = 0
+	}
+	if pointerFocus == surface {
+		pointerFocus = 0
+	}
+}
+
+// wl_pointer::axis_relative_direction
+const (
+	WLPointerAxisRelativeDirectionIdentical = 0
+	WLPointerAxisRelativeDirectionInverted  = 1
+)
+
+// pointerAxisFrame is the scrolling on an axis during a pointer frame.
+type pointerAxisFrame struct {
+	// Value is in surface coordinates, Value120 in 120ths of a wheel detent,
+	// 0 without wheel events. Discrete is only sent before wl_pointer v8.
+	Value    float64
+	Value120 int32
+	Discrete int32
+	// Stop is set when the scroll stopped, for kinetic scrolling.
+	Stop bool
+	// Inverted is set when the axis is scrolled with natural scrolling, so
+	// the content should move with the fingers. Only sent since
+	// wl_pointer v9, always false before.
+	Inverted bool
+}
+
+// pointerFrame accumulates the axis events of the current pointer frame.
+var pointerFrame WLPointerFrame
+
+func pointerAxis(axis uint32) *pointerAxisFrame {
+	if int(axis) >= len(pointerFrame.Axes) {
+		return &pointerAxisFrame{}
+	}
+	return &pointerFrame.Axes[axis]
+}
+
+func handleWLPointerEvent(opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // enter
+		ev := WLPointerEnter{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Surface: binary.LittleEndian.Uint32(body[4:]),
+			X:       fromFixed(binary.LittleEndian.Uint32(body[8:])),
+			Y:       fromFixed(binary.LittleEndian.Uint32(body[12:])),
+		}
+		pointerSerialsMu.Lock()
+		s := pointerSerialsBySeat[WLSeatID]
+		s.enter = ev.Serial
+		pointerSerialsBySeat[WLSeatID] = s
+		pointerSerialsMu.Unlock()
+		setFocus(&pointerFocus, ev.Surface, false)
+		return ev
+	case 1: // leave
+		ev := WLPointerLeave{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Surface: binary.LittleEndian.Uint32(body[4:]),
+		}
+		setFocus(&pointerFocus, ev.Surface, true)
+		return ev
+	case 2: // motion
+		return WLPointerMotion{
+			Time: binary.LittleEndian.Uint32(body),
+			X:    fromFixed(binary.LittleEndian.Uint32(body[4:])),
+			Y:    fromFixed(binary.LittleEndian.Uint32(body[8:])),
+		}
+	case 3: // button
+		ev := WLPointerButton{
+			Serial: binary.LittleEndian.Uint32(body),
+			Time:   binary.LittleEndian.Uint32(body[4:]),
+			Button: binary.LittleEndian.Uint32(body[8:]),
+			State:  binary.LittleEndian.Uint32(body[12:]),
+		}
+		pointerSerialsMu.Lock()
+		s := pointerSerialsBySeat[WLSeatID]
+		s.button = ev.Serial
+		pointerSerialsBySeat[WLSeatID] = s
+		pointerSerialsMu.Unlock()
+		return ev
+	case 4: // axis
+		ev := WLPointerAxis{
+			Time:  binary.LittleEndian.Uint32(body),
+			Axis:  binary.LittleEndian.Uint32(body[4:]),
+			Value: fromFixed(binary.LittleEndian.Uint32(body[8:])),
+		}
+		pointerAxis(ev.Axis).Value += ev.Value
+		return ev
+	case 5: // frame
+		ev := pointerFrame
+		pointerFrame = WLPointerFrame{}
+		return ev
+	case 6: // axis_source
+		ev := WLPointerAxisSource{Source: binary.LittleEndian.Uint32(body)}
+		pointerFrame.Source = ev.Source
+		return ev
+	case 7: // axis_stop
+		ev := WLPointerAxisStop{
+			Time: binary.LittleEndian.Uint32(body),
+			Axis: binary.LittleEndian.Uint32(body[4:]),
+		}
+		pointerAxis(ev.Axis).Stop = true
+		return ev
+	case 8: // axis_discrete
+		ev := WLPointerAxisDiscrete{
+			Axis:     binary.LittleEndian.Uint32(body),
+			Discrete: int32(binary.LittleEndian.Uint32(body[4:])),
+		}
+		pointerAxis(ev.Axis).Discrete += ev.Discrete
+		return ev
+	case 9: // axis_value120
+		ev := WLPointerAxisValue120{
+			Axis:     binary.LittleEndian.Uint32(body),
+			Value120: int32(binary.LittleEndian.Uint32(body[4:])),
+		}
+		pointerAxis(ev.Axis).Value120 += ev.Value120
+		return ev
+	case 10: // axis_relative_direction
+		ev := WLPointerAxisRelativeDirection{
+			Axis:      binary.LittleEndian.Uint32(body),
+			Direction: binary.LittleEndian.Uint32(body[4:]),
+		}
+		pointerAxis(ev.Axis).Inverted = ev.Direction == WLPointerAxisRelativeDirectionInverted
+		return ev
+	}
+	return nil
+}
+
+var errNoKeymap = errors.New("wl_keyboard: compositor provides no keymap, keys are raw keycodes")
+
+// readKeymap maps the keymap fd and returns a copy of the keymap up to its
+// NUL, closing fd either way. Only xkb_v1 keymaps are read.
+func readKeymap(fd int, format, size uint32) ([]byte, error) {
+	defer closeRecvFD(fd)
+	switch {
+	case format == WLKeyboardKeymapFormatNoKeymap:
+		return nil, errNoKeymap
+	case format != WLKeyboardKeymapFormatXKBV1:
+		return nil, errors.New("wl_keyboard: unknown keymap format " + strconv.Itoa(int(format)))
+	case fd < 0:
+		return nil, errNoRecvFD
+	case size == 0:
+		return nil, errors.New("wl_keyboard: empty keymap")
+	}
+	// Since wl_seat v7 the fd must be mapped MAP_PRIVATE, which works for
+	// older ones too.
+	m, err := unix.Mmap(fd, 0, int(size), unix.PROT_READ, unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Munmap(m)
+	if i := bytes.IndexByte(m, 0); i >= 0 {
+		m = m[:i]
+	}
+	return bytes.Clone(m), nil
+}
+
+func handleWLKeyboardEvent(opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // keymap
+		format := binary.LittleEndian.Uint32(body)
+		keymap, err := readKeymap(takeFD(WLKeyboardID), format, binary.LittleEndian.Uint32(body[4:]))
+		return WLKeyboardKeymap{Format: format, Keymap: keymap, Err: err}
+	case 1: // enter
+		ev := WLKeyboardEnter{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Surface: binary.LittleEndian.Uint32(body[4:]),
+			Keys:    parseUint32s(body[8:]),
+		}
+		setFocus(&keyboardFocus, ev.Surface, false)
+		return ev
+	case 2: // leave
+		ev := WLKeyboardLeave{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Surface: binary.LittleEndian.Uint32(body[4:]),
+		}
+		setFocus(&keyboardFocus, ev.Surface, true)
+		stopKeyRepeat()
+		return ev
+	case 3: // key
+		ev := WLKeyboardKey{
+			Serial: binary.LittleEndian.Uint32(body),
+			Time:   binary.LittleEndian.Uint32(body[4:]),
+			Key:    binary.LittleEndian.Uint32(body[8:]),
+			State:  binary.LittleEndian.Uint32(body[12:]),
+		}
+		repeatKey(ev)
+		return ev
+	case 4: // modifiers
+		return WLKeyboardModifiers{
+			Serial:    binary.LittleEndian.Uint32(body),
+			Depressed: binary.LittleEndian.Uint32(body[4:]),
+			Latched:   binary.LittleEndian.Uint32(body[8:]),
+			Locked:    binary.LittleEndian.Uint32(body[12:]),
+			Group:     binary.LittleEndian.Uint32(body[16:]),
+		}
+	case 5: // repeat_info
+		ev := WLKeyboardRepeatInfo{
+			Rate:  int32(binary.LittleEndian.Uint32(body)),
+			Delay: int32(binary.LittleEndian.Uint32(body[4:])),
+		}
+		setKeyRepeat(ev.Rate, ev.Delay)
+		return ev
+	}
+	return nil
+}
+
+// touchPoint is the state of a touch point as of the last wl_touch::frame.
+type touchPoint struct {
+	ID      int32
+	Surface uint32
+	X, Y    float64
+	// Major and Minor are the axes of the contact ellipse and Orientation
+	// its angle in degrees, 0 until a wl_touch v6 compositor sends them.
+	Major, Minor float64
+	Orientation  float64
+	Up           bool
+}
+
+// touchPoints are the points down, updated through a frame and sent with
+// WLTouchFrame at its end. Points that went up are removed after that.
+var touchPoints = map[int32]*touchPoint{}
+
+func handleWLTouchEvent(opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // down
+		ev := WLTouchDown{
+			Serial:  binary.LittleEndian.Uint32(body),
+			Time:    binary.LittleEndian.Uint32(body[4:]),
+			Surface: binary.LittleEndian.Uint32(body[8:]),
+			ID:      int32(binary.LittleEndian.Uint32(body[12:])),
+			X:       fromFixed(binary.LittleEndian.Uint32(body[16:])),
+			Y:       fromFixed(binary.LittleEndian.Uint32(body[20:])),
+		}
+		touchPoints[ev.ID] = &touchPoint{ID: ev.ID, Surface: ev.Surface, X: ev.X, Y: ev.Y}
+		return ev
+	case 1: // up
+		ev := WLTouchUp{
+			Serial: binary.LittleEndian.Uint32(body),
+			Time:   binary.LittleEndian.Uint32(body[4:]),
+			ID:     int32(binary.LittleEndian.Uint32(body[8:])),
+		}
+		if p, ok := touchPoints[ev.ID]; ok {
+			p.Up = true
+		}
+		return ev
+	case 2: // motion
+		ev := WLTouchMotion{
+			Time: binary.LittleEndian.Uint32(body),
+			ID:   int32(binary.LittleEndian.Uint32(body[4:])),
+			X:    fromFixed(binary.LittleEndian.Uint32(body[8:])),
+			Y:    fromFixed(binary.LittleEndian.Uint32(body[12:])),
+		}
+		if p, ok := touchPoints[ev.ID]; ok {
+			p.X, p.Y = ev.X, ev.Y
+		}
+		return ev
+	case 3: // frame
+		ev := WLTouchFrame{Points: make([]touchPoint, 0, len(touchPoints))}
+		for id, p := range touchPoints {
+			ev.Points = append(ev.Points, *p)
+			if p.Up {
+				delete(touchPoints, id)
+			}
+		}
+		slices.SortFunc(ev.Points, func(a, b touchPoint) int { return cmp.Compare(a.ID, b.ID) })
+		return ev
+	case 4: // cancel
+		clear(touchPoints)
+		return WLTouchCancel{}
+	}
+	if interfaceVersion(WLTouchID) < 6 {
+		return nil
+	}
+	switch opcode {
+	case 5: // shape
+		if p, ok := touchPoints[int32(binary.LittleEndian.Uint32(body))]; ok {
+			p.Major = fromFixed(binary.LittleEndian.Uint32(body[4:]))
+			p.Minor = fromFixed(binary.LittleEndian.Uint32(body[8:]))
+		}
+	case 6: // orientation
+		if p, ok := touchPoints[int32(binary.LittleEndian.Uint32(body))]; ok {
+			p.Orientation = fromFixed(binary.LittleEndian.Uint32(body[4:]))
+		}
+	}
+	return nil
+}
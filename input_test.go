@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFocus(t *testing.T) {
+	resetTestState(t)
+	a, b := regObj(objWLSurface), regObj(objWLSurface)
+	keyboard := func(opcode, surface uint32) {
+		body := binary.LittleEndian.AppendUint32(nil, 1) // serial
+		body = binary.LittleEndian.AppendUint32(body, surface)
+		if opcode == 1 {
+			body = binary.LittleEndian.AppendUint32(body, 0) // no keys
+		}
+		handleWLKeyboardEvent(opcode, body)
+	}
+
+	keyboard(1, a)
+	if !hasKeyboardFocus(a) || hasKeyboardFocus(b) {
+		t.Fatal("keyboard focus not on the entered surface")
+	}
+	// A leave that arrives after the focus moved on keeps it.
+	keyboard(2, b)
+	if !hasKeyboardFocus(a) {
+		t.Error("keyboard focus lost to the leave of another surface")
+	}
+	keyboard(2, a)
+	if hasKeyboardFocus(a) {
+		t.Error("keyboard focus kept after leaving")
+	}
+
+	body := binary.LittleEndian.AppendUint32(nil, 1) // serial
+	body = binary.LittleEndian.AppendUint32(body, b)
+	body = binary.LittleEndian.AppendUint32(body, 0) // x
+	body = binary.LittleEndian.AppendUint32(body, 0) // y
+	handleWLPointerEvent(0, body)
+	if !hasPointerFocus(b) || hasPointerFocus(a) {
+		t.Fatal("pointer focus not on the entered surface")
+	}
+	keyboard(1, b)
+	forgetFocus(b)
+	if hasPointerFocus(b) || hasKeyboardFocus(b) {
+		t.Error("deleted surface still focused")
+	}
+	if hasKeyboardFocus(0) || hasPointerFocus(0) {
+		t.Error("no surface reported focused")
+	}
+}
+
+// words encodes the args of an event.
+func words(args ...uint32) []byte {
+	var body []byte
+	for _, arg := range args {
+		body = binary.LittleEndian.AppendUint32(body, arg)
+	}
+	return body
+}
+
+func TestWLTouch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ver  uint32
+		// major, minor and orientation are those of point 2 once the
+		// shape and orientation events are handled.
+		major, minor, orientation float64
+	}{
+		{"v5 ignores shape and orientation", 5, 0, 0, 0},
+		{"v6", 6, 8, 4, 90},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			clear(touchPoints)
+			t.Cleanup(func() { clear(touchPoints) })
+			WLTouchID = regObjVersion(objWLTouch, tc.ver)
+			s := regObj(objWLSurface)
+			p1 := touchPoint{ID: 1, Surface: s, X: 3, Y: 4}
+			p2 := touchPoint{ID: 2, Surface: s, X: 5, Y: 6, Major: tc.major, Minor: tc.minor, Orientation: tc.orientation}
+			p3 := touchPoint{ID: 3, Surface: s, X: 7, Y: 8}
+			p1Up := p1
+			p1Up.Up = true
+			for i, step := range []struct {
+				opcode uint32
+				body   []byte
+				want   Event
+			}{
+				{0, words(1, 10, s, 2, toFixed(1), toFixed(2)), WLTouchDown{Serial: 1, Time: 10, Surface: s, ID: 2, X: 1, Y: 2}},
+				{0, words(2, 10, s, 3, toFixed(7), toFixed(8)), WLTouchDown{Serial: 2, Time: 10, Surface: s, ID: 3, X: 7, Y: 8}},
+				{0, words(3, 10, s, 1, toFixed(3), toFixed(4)), WLTouchDown{Serial: 3, Time: 10, Surface: s, ID: 1, X: 3, Y: 4}},
+				{2, words(11, 2, toFixed(5), toFixed(6)), WLTouchMotion{Time: 11, ID: 2, X: 5, Y: 6}},
+				{5, words(2, toFixed(8), toFixed(4)), nil},
+				{6, words(2, toFixed(90)), nil},
+				{3, nil, WLTouchFrame{Points: []touchPoint{p1, p2, p3}}},
+				{1, words(4, 12, 1), WLTouchUp{Serial: 4, Time: 12, ID: 1}},
+				{3, nil, WLTouchFrame{Points: []touchPoint{p1Up, p2, p3}}},
+				{3, nil, WLTouchFrame{Points: []touchPoint{p2, p3}}},
+				{4, nil, WLTouchCancel{}},
+				{3, nil, WLTouchFrame{Points: []touchPoint{}}},
+			} {
+				got := handleWLTouchEvent(step.opcode, step.body)
+				if !reflect.DeepEqual(got, step.want) {
+					t.Errorf("event %d (opcode %d) is %+v, want %+v", i, step.opcode, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWLKeyboardKeymap(t *testing.T) {
+	const keymap = "xkb_keymap { };"
+	for _, tc := range []struct {
+		name   string
+		format uint32
+		// data is the content of the fd sent, size its size sent.
+		data string
+		size uint32
+		want WLKeyboardKeymap
+		err  error
+	}{
+		{"xkb_v1 trimmed at its NUL", WLKeyboardKeymapFormatXKBV1, keymap + "\x00\x00junk", uint32(len(keymap)) + 6,
+			WLKeyboardKeymap{Format: WLKeyboardKeymapFormatXKBV1, Keymap: []byte(keymap)}, nil},
+		{"xkb_v1 without a NUL", WLKeyboardKeymapFormatXKBV1, keymap, uint32(len(keymap)),
+			WLKeyboardKeymap{Format: WLKeyboardKeymapFormatXKBV1, Keymap: []byte(keymap)}, nil},
+		{"no_keymap", WLKeyboardKeymapFormatNoKeymap, "", 0,
+			WLKeyboardKeymap{Format: WLKeyboardKeymapFormatNoKeymap}, errNoKeymap},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			WLKeyboardID = regObj(objWLKeyboard)
+			fd, err := unix.MemfdCreate("keymap", unix.MFD_CLOEXEC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := unix.Write(fd, []byte(tc.data)); err != nil {
+				t.Fatal(err)
+			}
+			queueRecvFDs(unix.UnixRights(fd))
+			before := stats()
+
+			ev, ok := handleWLKeyboardEvent(0, words(tc.format, tc.size)).(WLKeyboardKeymap)
+			if !ok {
+				t.Fatalf("got %v, want a WLKeyboardKeymap", ev)
+			}
+			if !errors.Is(ev.Err, tc.err) {
+				t.Errorf("got error %v, want %v", ev.Err, tc.err)
+			}
+			ev.Err = nil
+			if !reflect.DeepEqual(ev, tc.want) {
+				t.Errorf("got %q, want %q", ev.Keymap, tc.want.Keymap)
+			}
+			if open := stats().sub(before).OpenRecvFDs; open != 0 {
+				t.Errorf("%d fds left open", open)
+			}
+			if _, err := unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != unix.EBADF {
+				t.Errorf("keymap fd not closed: %v", err)
+			}
+		})
+	}
+}
+
+func TestPointerSerials(t *testing.T) {
+	// event is a pointer event with serial on the pointer of seat, 0 or 1.
+	type event struct {
+		seat, opcode, serial uint32
+	}
+	enter := func(seat, serial uint32) event { return event{seat, 0, serial} }
+	leave := func(seat, serial uint32) event { return event{seat, 1, serial} }
+	button := func(seat, serial uint32) event { return event{seat, 3, serial} }
+	for _, tt := range []struct {
+		name   string
+		events []event
+		// want are the enter and button serials of each seat.
+		want [2]pointerSerials
+	}{
+		{"enter then button", []event{enter(0, 5), button(0, 6)}, [2]pointerSerials{{5, 6}, {}}},
+		{"button then enter", []event{button(0, 6), enter(0, 7)}, [2]pointerSerials{{7, 6}, {}}},
+		{"latest of each", []event{enter(0, 5), button(0, 6), button(0, 8), enter(0, 9)}, [2]pointerSerials{{9, 8}, {}}},
+		{"leave keeps them", []event{enter(0, 5), button(0, 6), leave(0, 7)}, [2]pointerSerials{{5, 6}, {}}},
+		{"per seat", []event{enter(0, 5), enter(1, 10), button(1, 11), button(0, 6)}, [2]pointerSerials{{5, 6}, {10, 11}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			seats := [2]uint32{regObj(objWLSeat), regObj(objWLSeat)}
+			surface := regObj(objWLSurface)
+			for _, ev := range tt.events {
+				// The pointer of the seat in use sends the event.
+				WLSeatID = seats[ev.seat]
+				var body []byte
+				switch ev.opcode {
+				case 0:
+					body = words(ev.serial, surface, 0, 0)
+				case 1:
+					body = words(ev.serial, surface)
+				case 3:
+					body = words(ev.serial, 100, 0x110, WLPointerButtonStatePressed)
+				}
+				handleWLPointerEvent(ev.opcode, body)
+			}
+			for i, seat := range seats {
+				got := pointerSerials{lastPointerEnterSerial(seat), lastPointerButtonSerial(seat)}
+				if got != tt.want[i] {
+					t.Errorf("seat %d: got serials %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// wl_keyboard leaves repeating held keys to the client, at the rate and
+// delay sent with repeat_info. The last key pressed repeats until it's
+// released, another key is pressed or the keyboard leaves the surface.
+// Repeats are timed by clk.
+
+// WLKeyboardKeyStateRepeated is the State of the WLKeyboardKey events
+// generated by key repeat, the value wl_keyboard v10 gives to the repeats
+// compositors send themselves.
+const WLKeyboardKeyStateRepeated = 2
+
+// keyRepeats delivers the repeats of the key held. A repeat the app hasn't
+// received by the next one is dropped, the next one supersedes it.
+var keyRepeats = make(chan WLKeyboardKey, 1)
+
+const (
+	defaultKeyRepeatRate  = 25
+	defaultKeyRepeatDelay = 600 * time.Millisecond
+)
+
+var (
+	keyRepeatMu sync.Mutex
+	// keyRepeatRate is in keys per second, 0 disables repeating, and
+	// keyRepeatDelay is the wait before the first repeat. They're the
+	// compositor's once it sends repeat_info.
+	keyRepeatRate  int32 = defaultKeyRepeatRate
+	keyRepeatDelay       = defaultKeyRepeatDelay
+	// keyRepeating is the key repeating, with the timer of its next repeat
+	// and the channel closed to stop it.
+	keyRepeating   uint32
+	keyRepeatTimer clockTimer
+	keyRepeatStop  chan struct{}
+)
+
+// modifierKeys don't repeat.
+var modifierKeys = map[uint32]bool{
+	KeyLeftCtrl: true, KeyRightCtrl: true, KeyLeftShift: true, KeyRightShift: true,
+	KeyLeftAlt: true, KeyRightAlt: true, KeyLeftMeta: true, KeyRightMeta: true,
+	KeyCapsLock: true,
+}
+
+// setKeyRepeat applies repeat_info, from the next key pressed.
+func setKeyRepeat(rate, delay int32) {
+	keyRepeatMu.Lock()
+	defer keyRepeatMu.Unlock()
+	keyRepeatRate, keyRepeatDelay = rate, time.Duration(delay)*time.Millisecond
+	stopKeyRepeatLocked()
+}
+
+// repeatKey starts repeating the key of ev if it was pressed, or stops
+// repeating it if it was released.
+func repeatKey(ev WLKeyboardKey) {
+	keyRepeatMu.Lock()
+	defer keyRepeatMu.Unlock()
+	if ev.State != WLKeyboardKeyStatePressed {
+		if ev.Key == keyRepeating {
+			stopKeyRepeatLocked()
+		}
+		return
+	}
+	stopKeyRepeatLocked()
+	if keyRepeatRate <= 0 || modifierKeys[ev.Key] {
+		return
+	}
+	keyRepeating, keyRepeatStop = ev.Key, make(chan struct{})
+	keyRepeatTimer = clk.NewTimer(keyRepeatDelay)
+	go runKeyRepeat(ev, keyRepeatTimer, keyRepeatDelay, time.Second/time.Duration(keyRepeatRate), keyRepeatStop)
+}
+
+// stopKeyRepeat stops repeating the key held, if any.
+func stopKeyRepeat() {
+	keyRepeatMu.Lock()
+	defer keyRepeatMu.Unlock()
+	stopKeyRepeatLocked()
+}
+
+func stopKeyRepeatLocked() {
+	if keyRepeatStop == nil {
+		return
+	}
+	keyRepeatTimer.Stop()
+	close(keyRepeatStop)
+	keyRepeating, keyRepeatTimer, keyRepeatStop = 0, nil, nil
+}
+
+// runKeyRepeat sends a repeat of ev each time t fires, t being set to
+// interval after the first. Time is ev's plus the time since, in
+// milliseconds like the compositor's.
+func runKeyRepeat(ev WLKeyboardKey, t clockTimer, delay, interval time.Duration, stop chan struct{}) {
+	ev.State = WLKeyboardKeyStateRepeated
+	elapsed := delay
+	for {
+		select {
+		case <-t.C():
+		case <-stop:
+			return
+		}
+		keyRepeatMu.Lock()
+		select {
+		case <-stop:
+			// Stopped as t fired.
+			keyRepeatMu.Unlock()
+			return
+		default:
+		}
+		t = clk.NewTimer(interval)
+		keyRepeatTimer = t
+		repeat := ev
+		repeat.Time += uint32(elapsed.Milliseconds())
+		elapsed += interval
+		select {
+		case keyRepeats <- repeat:
+		default:
+		}
+		keyRepeatMu.Unlock()
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRepeat(t *testing.T) {
+	// step handles the wl_keyboard event opcode with args, or with advance
+	// set moves the fake clock by it instead, which repeats repeat at time,
+	// or nothing if repeat is 0.
+	type step struct {
+		opcode       uint32
+		args         []uint32
+		advance      time.Duration
+		repeat, time uint32
+	}
+	info := func(rate, delay uint32) step { return step{opcode: 5, args: []uint32{rate, delay}} }
+	press := func(key, time uint32) step {
+		return step{opcode: 3, args: []uint32{1, time, key, WLKeyboardKeyStatePressed}}
+	}
+	release := func(key, time uint32) step {
+		return step{opcode: 3, args: []uint32{2, time, key, WLKeyboardKeyStateReleased}}
+	}
+	leave := step{opcode: 2, args: []uint32{3, 0}}
+	advance := func(d time.Duration, repeat, time uint32) step {
+		return step{advance: d, repeat: repeat, time: time}
+	}
+	const ms = time.Millisecond
+	for _, tt := range []struct {
+		name  string
+		steps []step
+	}{
+		{"held", []step{
+			info(10, 500),
+			press(30, 1000),
+			advance(499*ms, 0, 0),
+			advance(1*ms, 30, 1500),
+			advance(100*ms, 30, 1600),
+			advance(100*ms, 30, 1700),
+			release(30, 1750),
+			advance(time.Second, 0, 0),
+		}},
+		{"default rate and delay", []step{
+			press(30, 0),
+			advance(599*ms, 0, 0),
+			advance(1*ms, 30, 600),
+			advance(40*ms, 30, 640),
+		}},
+		{"released before the delay", []step{
+			info(10, 500),
+			press(30, 0),
+			advance(400*ms, 0, 0),
+			release(30, 400),
+			advance(time.Second, 0, 0),
+		}},
+		{"another key pressed", []step{
+			info(10, 500),
+			press(30, 0),
+			advance(500*ms, 30, 500),
+			press(31, 550),
+			advance(450*ms, 0, 0),
+			advance(50*ms, 31, 1050),
+			release(30, 1060),
+			advance(100*ms, 31, 1150),
+		}},
+		{"modifier", []step{
+			press(KeyLeftShift, 0),
+			advance(time.Second, 0, 0),
+		}},
+		{"rate 0", []step{
+			info(0, 500),
+			press(30, 0),
+			advance(time.Second, 0, 0),
+		}},
+		{"leave", []step{
+			info(10, 500),
+			press(30, 0),
+			advance(500*ms, 30, 500),
+			leave,
+			advance(time.Second, 0, 0),
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			fake := newFakeClock(time.Unix(0, 0))
+			clk = fake
+			WLKeyboardID = regObj(objWLKeyboard)
+			for i, s := range tt.steps {
+				if s.advance == 0 {
+					handleWLKeyboardEvent(s.opcode, words(s.args...))
+					continue
+				}
+				fake.advance(s.advance)
+				if s.repeat == 0 {
+					select {
+					case ev := <-keyRepeats:
+						t.Fatalf("step %d: got %+v, want no repeat", i, ev)
+					default:
+					}
+					continue
+				}
+				select {
+				case ev := <-keyRepeats:
+					want := WLKeyboardKey{Serial: 1, Time: s.time, Key: s.repeat, State: WLKeyboardKeyStateRepeated}
+					if ev != want {
+						t.Fatalf("step %d: got %+v, want %+v", i, ev, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("step %d: key %d not repeated", i, s.repeat)
+				}
+			}
+		})
+	}
+}
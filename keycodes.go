@@ -0,0 +1,105 @@
+package main
+
+// Linux evdev key codes from linux/input-event-codes.h, the codes
+// wl_keyboard::key carries. XKB keycodes are these plus 8, which is what a
+// keymap is indexed by and what xkbcommon functions take.
+const (
+	KeyEsc        = 1
+	Key1          = 2
+	Key2          = 3
+	Key3          = 4
+	Key4          = 5
+	Key5          = 6
+	Key6          = 7
+	Key7          = 8
+	Key8          = 9
+	Key9          = 10
+	Key0          = 11
+	KeyMinus      = 12
+	KeyEqual      = 13
+	KeyBackspace  = 14
+	KeyTab        = 15
+	KeyQ          = 16
+	KeyW          = 17
+	KeyE          = 18
+	KeyR          = 19
+	KeyT          = 20
+	KeyY          = 21
+	KeyU          = 22
+	KeyI          = 23
+	KeyO          = 24
+	KeyP          = 25
+	KeyLeftBrace  = 26
+	KeyRightBrace = 27
+	KeyEnter      = 28
+	KeyLeftCtrl   = 29
+	KeyA          = 30
+	KeyS          = 31
+	KeyD          = 32
+	KeyF          = 33
+	KeyG          = 34
+	KeyH          = 35
+	KeyJ          = 36
+	KeyK          = 37
+	KeyL          = 38
+	KeySemicolon  = 39
+	KeyApostrophe = 40
+	KeyGrave      = 41
+	KeyLeftShift  = 42
+	KeyBackslash  = 43
+	KeyZ          = 44
+	KeyX          = 45
+	KeyC          = 46
+	KeyV          = 47
+	KeyB          = 48
+	KeyN          = 49
+	KeyM          = 50
+	KeyComma      = 51
+	KeyDot        = 52
+	KeySlash      = 53
+	KeyRightShift = 54
+	KeyLeftAlt    = 56
+	KeySpace      = 57
+	KeyCapsLock   = 58
+	KeyF1         = 59
+	KeyF2         = 60
+	KeyF3         = 61
+	KeyF4         = 62
+	KeyF5         = 63
+	KeyF6         = 64
+	KeyF7         = 65
+	KeyF8         = 66
+	KeyF9         = 67
+	KeyF10        = 68
+	KeyF11        = 87
+	KeyF12        = 88
+	KeyRightCtrl  = 97
+	KeyRightAlt   = 100
+	KeyHome       = 102
+	KeyUp         = 103
+	KeyPageUp     = 104
+	KeyLeft       = 105
+	KeyRight      = 106
+	KeyEnd        = 107
+	KeyDown       = 108
+	KeyPageDown   = 109
+	KeyInsert     = 110
+	KeyDelete     = 111
+	KeyLeftMeta   = 125
+	KeyRightMeta  = 126
+)
+
+// xkbKeycodeOffset is what XKB keycodes are offset from evdev codes by.
+const xkbKeycodeOffset = 8
+
+// evdevCode converts an XKB keycode, e.g. from a keymap, to the evdev code
+// wl_keyboard::key reports and the Key constants are.
+func evdevCode(xkbKeycode uint32) uint32 {
+	return xkbKeycode - xkbKeycodeOffset
+}
+
+// xkbKeycode converts an evdev code from wl_keyboard::key to the XKB keycode
+// to look up in the keymap.
+func xkbKeycode(evdev uint32) uint32 {
+	return evdev + xkbKeycodeOffset
+}
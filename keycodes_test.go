@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestEvdevCode(t *testing.T) {
+	// XKB keycodes as in the evdev keymap of xkeyboard-config.
+	for _, tt := range []struct {
+		xkb, evdev uint32
+	}{
+		{9, KeyEsc},
+		{38, KeyA},
+		{65, KeySpace},
+		{133, KeyLeftMeta},
+	} {
+		if got := evdevCode(tt.xkb); got != tt.evdev {
+			t.Errorf("evdevCode(%d) = %d, want %d", tt.xkb, got, tt.evdev)
+		}
+		if got := xkbKeycode(tt.evdev); got != tt.xkb {
+			t.Errorf("xkbKeycode(%d) = %d, want %d", tt.evdev, got, tt.xkb)
+		}
+	}
+}
@@ -3,159 +3,272 @@ package main
 import (
 	"context"
 	"encoding/binary"
-	"encoding/hex"
 	"errors"
+	"io"
 	"log/slog"
 	"net"
 	"os"
-	"path"
 	"strconv"
+	"sync"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
 func main() {
 	ctx := context.Background()
-	socketPath := os.Getenv("WAYLAND_SOCKET")
-	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
-
-	if socketPath == "" && xdgRuntimeDir == "" {
-		panic(errors.New("wayland env vars not set, neither WAYLAND_SOCKET nor XDG_RUNTIME_DIR is set"))
-	}
-	if socketPath == "" {
-		socketPath = path.Join(xdgRuntimeDir, os.Getenv("WAYLAND_DISPLAY"))
-	}
-	if socketPath == "" {
-		socketPath = path.Join(xdgRuntimeDir, "wayland-0")
-	}
-
-	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
-	if err != nil {
-		panic(err)
-	}
-	defer conn.Close()
+	startupToken := takeStartupToken()
 
-	err = conn.SetReadDeadline(time.Time{})
+	conn, err := connect()
 	if err != nil {
 		panic(err)
 	}
+	defer disconnect(conn)
 
 	mustGetReg(conn)
-	mustSync(conn)
+	if startupRoundtrips {
+		err = roundtrip(ctx, conn)
+		if err != nil {
+			slog.ErrorContext(ctx, "startup roundtrip err", "err", err)
+			os.Exit(1)
+		}
+	}
 
-loop:
+	err = openWindow(ctx, conn)
+	if err != nil {
+		slog.ErrorContext(ctx, "initial configure err", "err", err)
+		os.Exit(1)
+	}
+	if startupToken != "" && XDGActivationID != 0 {
+		mustActivate(conn, startupToken, WLSurfaceID)
+	}
+	// From here on drawing happens in between events, leave reading and
+	// answering pings to the reader so a slow frame can't delay a pong.
+	startReader(conn)
+	if handleSignals {
+		defer watchSignals()()
+	}
 	for {
-		id, opcode, body, err := read(conn)
+		id, opcode, body, err := nextMsg()
+		if errors.Is(err, errDisconnected) {
+			break
+		}
 		if err != nil {
 			panic(err)
 		}
-		switch id {
-		case WLDisplayID:
-			err = handleWLDisplayEvent(opcode, body)
-			if err != nil {
-				slog.ErrorContext(ctx, "wl_display handler err", "err", err)
-				os.Exit(1)
-			}
-		case WLRegistryID:
-			if opcode != 0 {
-				// unhandled
-				continue
-			}
-			name := binary.LittleEndian.Uint32(body)
-			iface, off := parseStr(body[4:])
-			ver := binary.LittleEndian.Uint32(body[4+off:])
-			switch string(iface) {
-			case "wl_compositor":
-				WLCompositorID = mustRegBind(conn, objWLCompositor, name, ver, iface)
-			case "wl_shm":
-				WLShmID = mustRegBind(conn, objWLShm, name, ver, iface)
-			case "wl_output":
-				WLOutputID = mustRegBind(conn, objWLOutput, name, ver, iface)
-			case "xdg_wm_base":
-				XDGWMBaseID = mustRegBind(conn, objXDGWMBase, name, ver, iface)
-			case "zwlr_layer_shell_v1":
-				ZWLRLayerShellID = mustRegBind(conn, objZWLRLayerShell, name, ver, iface)
+		ev, err := handleEvent(ctx, conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			slog.ErrorContext(ctx, "wl_display handler err", "err", err)
+			os.Exit(1)
+		}
+		quit, err := reactRecovering(conn, id, opcode, ev)
+		if err != nil {
+			slog.ErrorContext(ctx, "event loop err", "err", err)
+			os.Exit(1)
+		}
+		if quit {
+			break
+		}
+	}
+}
+
+// reactRecovering is react recovering from panics, e.g. while drawing, like
+// handleEvent does when onHandlerPanic is set. id and opcode are of the
+// message ev was decoded from.
+func reactRecovering(conn *net.UnixConn, id, opcode uint32, ev Event) (quit bool, err error) {
+	if onHandlerPanic != nil {
+		defer func() {
+			if v := recover(); v != nil {
+				quit, err = false, recoverHandler(id, opcode, v)
 			}
-		case WLSyncCallbackID:
-			break loop
-		default:
-			slog.InfoContext(ctx, "wl msg", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
+		}()
+	}
+	return react(conn, ev), nil
+}
+
+// react is what the main loop does about ev besides handling it, drawing
+// when a frame is due. It reports whether the app should quit.
+func react(conn *net.UnixConn, ev Event) (quit bool) {
+	switch ev := ev.(type) {
+	case XDGToplevelClose:
+		return true
+	case WLKeyboardKey:
+		return ev.Key == KeyEsc && ev.State == WLKeyboardKeyStatePressed
+	case WLSurfaceFrameDone:
+		if latencyMode == latencyOneFrame && bufferBusy() {
+			setDrawPending(true)
+			return false
+		}
+		mustDraw(conn)
+	case XDGSurfaceConfigure:
+		// The configure is acked by the next commit, make one if no frame
+		// is coming. A hidden surface may not get the callback it's waiting
+		// for, commit without asking for another one then, the one pending
+		// still restarts drawing once it's done.
+		if ev.XDGSurface != XDGSurfaceID {
+			return false
+		}
+		mustApplyWindowSize(conn)
+		if !framePending() {
+			mustDraw(conn)
+		} else if !surfaceVisible() {
+			mustRepaint(conn)
+		}
+	case WLSurfaceScale:
+		// The next frame draws into the new buffer anyway, only draw now if
+		// there's none coming.
+		if mustSetScale(conn, ev.Scale) != nil && !framePending() {
+			mustDraw(conn)
+		}
+	case WLBufferRelease:
+		if setDrawPending(false) {
+			mustDraw(conn)
 		}
 	}
+	return false
+}
+
+// closeRequests gets a value when the compositor asks XDGTopLevelID to
+// close, for code that selects on it rather than looking for
+// XDGToplevelClose. Requests not received yet are coalesced into one.
+var closeRequests = make(chan struct{}, 1)
 
+// toplevelClosed returns the channel of the toplevel's close requests. Like
+// XDGToplevelClose they're advisory, ignoring them keeps the window open.
+func toplevelClosed() <-chan struct{} {
+	return closeRequests
+}
+
+// startupRoundtrips makes main wait for the globals after getting the
+// registry. Without it globals are bound as their events are handled, so
+// they may not all be bound before the first commit, and the app has to
+// call roundtrip itself where it needs them.
+var startupRoundtrips = true
+
+// openWindow creates the toplevel and commits its first buffer, with a
+// frame callback to start drawing. xdg_shell wants the role committed
+// without a buffer, and the configure that answers it acked, before a
+// buffer is attached. The error is awaitConfigure's.
+func openWindow(ctx context.Context, conn *net.UnixConn) error {
 	mustCreateSurface(conn)
 	mustGetXDGSurface(conn)
 	mustGetTopLevel(conn)
+	mustCommit(conn)
+	err := awaitConfigure(ctx, conn)
+	if err != nil {
+		return err
+	}
+	// The dmabuf feedback asked for while binding globals came before the
+	// configure. The buffer is laid out for 4 bytes per pixel.
+	if f := preferredFormat(bufFormat.hasAlpha()); f.bytesPerPixel() == 4 {
+		bufFormat = f
+	}
 	mustCreatePool(conn)
-	mustCreateBuffer(conn)
-	mustSync(conn)
-loop2:
+	mustCreateBuffer(conn, bufFormat)
+
+	mustFrame(conn)
+	mustAttach(conn)
+	damageSurface()
+	mustCommit(conn)
+	return nil
+}
+
+// awaitConfigure handles events until the xdg_surface's first configure, so
+// a buffer can be attached after. The commit attaching it acks the configure.
+func awaitConfigure(ctx context.Context, conn *net.UnixConn) error {
 	for {
 		id, opcode, body, err := read(conn)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		switch id {
-		case WLDisplayID:
-			handleWLDisplayEvent(opcode, body)
-		case WLSyncCallbackID: // wl_callback
-			break loop2
-		default:
-			slog.InfoContext(ctx, "wl msg", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
+		ev, err := handleEvent(ctx, conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			return err
+		}
+		if _, ok := ev.(XDGSurfaceConfigure); ok {
+			mustApplyWindowSize(conn)
+			return nil
 		}
 	}
+}
 
-	mustFrame(conn)
-	mustAttach(conn)
-	mustDamage(conn)
-	mustCommit(conn)
-loop3:
+// roundtrip handles events until the compositor has processed every request
+// sent so far, e.g. after mustGetReg until all globals have been announced
+// and bound. The error is a read error or the fatal wl_display::error.
+//
+// It waits for the callback's delete_id rather than its done, so the id is
+// freed before returning instead of by whoever reads next. done is a
+// destructor event, the compositor always sends it before the delete_id
+// that follows its destruction.
+func roundtrip(ctx context.Context, conn *net.UnixConn) error {
+	cb := mustSync(conn, nil)
 	for {
+		objMu.Lock()
+		freed := objects[cb] != objWLCallback
+		objMu.Unlock()
+		if freed {
+			return nil
+		}
 		id, opcode, body, err := read(conn)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		switch id {
-		case WLDisplayID:
-			handleWLDisplayEvent(opcode, body)
-		case XDGWMBaseID:
-			if opcode != 0 {
-				slog.InfoContext(ctx, "xdg_wm_base", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
-				continue
-			}
-			serial := binary.LittleEndian.Uint32(body)
-			mustPong(conn, serial)
-		case XDGSurfaceID:
-			if opcode != 0 {
-				slog.InfoContext(ctx, "xdg_surface", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
-				continue
-			}
-			serial := binary.LittleEndian.Uint32(body)
-			mustAckConfigure(conn, serial)
-		case XDGTopLevelID:
-			if opcode != 1 {
-				slog.InfoContext(ctx, "xdg_top_level", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
-				continue
-			}
-			break loop3
-		case WLFrameCallbackID:
-			objects[WLFrameCallbackID] = objNone
-			WLFrameCallbackID = 0
-			mustFrame(conn)
-
-			for i := range WLShmPoolBuf {
-				WLShmPoolBuf[i] += 4
-			}
-			mustAttach(conn)
-			mustDamage(conn)
-			mustCommit(conn)
-		default:
-			slog.InfoContext(ctx, "wl msg", "id", id, "opcode", opcode, "body", hex.EncodeToString(body))
+		_, err = handleEvent(ctx, conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			return err
 		}
 	}
 }
 
+var (
+	// frameMu guards WLFrameCallbackID, frameRequestedAt and drawPending,
+	// callbacks may be done on the dispatcher's goroutine while the app
+	// draws on another.
+	frameMu sync.Mutex
+	// drawPending is set when a frame was due while the buffer was still
+	// held by the compositor.
+	drawPending bool
+)
+
+// setDrawPending sets drawPending to pending and returns its old value.
+func setDrawPending(pending bool) (was bool) {
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	was, drawPending = drawPending, pending
+	return was
+}
+
+// framePending reports whether a frame callback requested by mustFrame is
+// still to be done.
+func framePending() bool {
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	return WLFrameCallbackID != 0
+}
+
+// mustDraw draws the next frame and commits it, asking for a frame callback
+// to draw the one after.
+func mustDraw(conn *net.UnixConn) {
+	mustFrame(conn)
+	mustRepaint(conn)
+}
+
+// mustRepaint draws and commits like mustDraw without asking for a frame
+// callback, for when one is already pending.
+func mustRepaint(conn *net.UnixConn) {
+	for i := range WLShmPoolBuf {
+		WLShmPoolBuf[i] += 4
+	}
+	mustAttach(conn)
+	if diffDamage {
+		addDiffDamage(WLShmPoolBuf, int32(bufWidth), int32(bufHeight), int32(bufWidth*4))
+	} else {
+		damageSurface()
+	}
+	mustCommit(conn)
+}
+
 type objType uint8
 
 const (
@@ -173,13 +286,97 @@ const (
 	objXDGSurface
 	objXDGTopLevel
 	objZWLRLayerShell
+	objZWLROutputPowerManager
+	objZWLROutputPower
+	objZWLRGammaControlManager
+	objZWLRGammaControl
+	objWLSeat
+	objZWPVirtualKeyboardManager
+	objZWPVirtualKeyboard
+	objZWLRVirtualPointerManager
+	objZWLRVirtualPointer
+	objZWPKeyboardShortcutsInhibitManager
+	objZWPKeyboardShortcutsInhibitor
+	// objCustom is any object whose events go to its objHandlers entry.
+	objCustom
+	objWLPointer
+	objWLKeyboard
+	objWLFrameCallback
+	objXDGActivation
+	objXDGActivationToken
+	objWPColorManager
+	objWPColorManagementSurface
+	objWPImageDescriptionCreatorParams
+	objWPImageDescriptionCreatorICC
+	objWPImageDescription
+	objWPTearingControlManager
+	objWPTearingControl
+	objWPAlphaModifier
+	objWPAlphaModifierSurface
+	objWPFifoManager
+	objWPFifo
+	objWPCommitTimingManager
+	objWPCommitTimer
+	objWLDataDeviceManager
+	objWLDataDevice
+	objWLTouch
+	objWLSubcompositor
+	objWLSubsurface
+	objXDGPositioner
+	objXDGPopup
+	objWLDataOffer
+	objXDGToplevelIconManager
+	objXDGToplevelIcon
+	objWPPresentation
+	objWPPresentationFeedback
+	objWLRegion
+	objZWPLinuxDmabuf
+	objZWPLinuxDmabufFeedback
 )
 
 const objectsLen = 1 << 8
 
-var objects = [objectsLen]objType{objNone, objWLDisplay, objWLRegistry}
+var objects = [objectsLen]objType{objNone, objWLDisplay}
+
+// serverObjects are the live objects the compositor created, with ids from
+// serverIDMin, guarded by objMu. The compositor announces them in events
+// (like wl_data_device::data_offer) and the client destroys them, no
+// delete_id follows.
+var serverObjects = map[uint32]objType{}
+
+// regServerObj registers the object id the compositor created.
+func regServerObj(id uint32, t objType) error {
+	if id < serverIDMin {
+		return corruptMsgErr{id: id, reason: "new object id isn't in the server range"}
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	if cur, ok := serverObjects[id]; ok {
+		return corruptMsgErr{id: id, reason: "new object id is already used by a " + cur.String()}
+	}
+	serverObjects[id] = t
+	return nil
+}
+
+// forgetServerObj removes id from serverObjects, once the client destroyed
+// it.
+func forgetServerObj(id uint32) {
+	objMu.Lock()
+	delete(serverObjects, id)
+	objMu.Unlock()
+}
+
+// objVersions is the version of each object in objects, 0 if unknown.
+var objVersions = [objectsLen]uint32{0, 1}
+
+// objMu guards objects, objVersions and objHandlers, which the dispatcher
+// goroutine updates on delete_id while requests may be registering new
+// objects.
+var objMu sync.Mutex
 
 func regObj(t objType) (id uint32) {
+	objMu.Lock()
+	defer objMu.Unlock()
 	id = 1
 	for id < objectsLen {
 		if objects[id] == 0 {
@@ -188,19 +385,55 @@ func regObj(t objType) (id uint32) {
 		}
 		id++
 	}
+	checkObjLeaks(t)
 	return id
 }
 
+// regObjVersion registers a new object bound at version ver.
+func regObjVersion(t objType, ver uint32) (id uint32) {
+	id = regObj(t)
+	objMu.Lock()
+	objVersions[id] = ver
+	objMu.Unlock()
+	return id
+}
+
+// regChildObj registers a new object created by a request on parent, which
+// it takes the version of.
+func regChildObj(t objType, parent uint32) (id uint32) {
+	return regObjVersion(t, interfaceVersion(parent))
+}
+
+// interfaceVersion returns the version the object id was bound or created
+// at, 0 for unknown objects.
+func interfaceVersion(id uint32) uint32 {
+	if id >= objectsLen {
+		return 0
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	return objVersions[id]
+}
+
+// surfaceVersion returns the version of WLSurfaceID, the version
+// wl_compositor was bound at.
+func surfaceVersion() uint32 {
+	return interfaceVersion(WLSurfaceID)
+}
+
 const WLDisplayID = 1
-const WLRegistryID = 2
 
 var (
 	// IDs
+	WLRegistryID      uint32
 	WLCompositorID    uint32
-	WLSyncCallbackID  uint32
 	WLShmID           uint32
 	WLShmPoolID       uint32
-	WLOutputID        uint32
+	WLOutputID        uint32 // the first of WLOutputIDs
+	WLSeatID          uint32 // the first of WLSeatIDs, the one input is read from
+	WLPointerID       uint32
+	WLKeyboardID      uint32
+	WLTouchID         uint32
 	WLBufferID        uint32
 	WLSurfaceID       uint32
 	XDGWMBaseID       uint32
@@ -209,38 +442,206 @@ var (
 	ZWLRLayerShellID  uint32
 	WLFrameCallbackID uint32
 
+	ZWLROutputPowerManagerID  uint32
+	ZWLRGammaControlManagerID uint32
+
+	ZWPVirtualKeyboardManagerID uint32
+	ZWPVirtualKeyboardID        uint32
+
+	ZWLRVirtualPointerManagerID uint32
+	ZWLRVirtualPointerID        uint32
+
+	ZWPKeyboardShortcutsInhibitManagerID uint32
+
+	XDGActivationID uint32
+
+	WPColorManagerID           uint32
+	WPColorManagementSurfaceID uint32
+
+	WPTearingControlManagerID uint32
+	WPTearingControlID        uint32
+
+	WPAlphaModifierID        uint32
+	WPAlphaModifierSurfaceID uint32
+
+	WPFifoManagerID         uint32
+	WPFifoID                uint32
+	WPCommitTimingManagerID uint32
+	WPCommitTimerID         uint32
+
+	// Every wl_output and wl_seat bound
+	WLOutputIDs []uint32
+	WLSeatIDs   []uint32
+
+	WLSubcompositorID uint32
+
+	WLDataDeviceManagerID uint32
+	WLDataDeviceID        uint32
+
+	XDGToplevelIconManagerID uint32
+
+	WPPresentationID uint32
+
+	ZWPLinuxDmabufID uint32
+
 	// WLShmPool stuff
 	WLShmPoolFile *os.File
 	WLShmPoolBuf  []byte
 )
 
+// Preferred window size in surface coordinates, used until the compositor
+// picks one and for dimensions it leaves to the client
+const (
+	winWidth  = 100
+	winHeight = 100
+)
+
+// Window size in surface coordinates, as of the last configure applied
+var (
+	windowWidth  uint32 = winWidth
+	windowHeight uint32 = winHeight
+)
+
+// WLBuffer size in pixels, the window size times the buffer scale
+var (
+	bufWidth  uint32 = winWidth
+	bufHeight uint32 = winHeight
+)
+
 const WORD_SIZE = 4
 const HEADER_SIZE = 2 * WORD_SIZE
 
 var headerBytes = make([]byte, HEADER_SIZE)
 
-func read(conn net.Conn) (id, opcode uint32, body []byte, err error) {
-	_, err = conn.Read(headerBytes)
+func read(conn *net.UnixConn) (id, opcode uint32, body []byte, err error) {
+	if flushPolicy == flushOnDispatch {
+		err = flush(conn)
+		if err != nil {
+			return
+		}
+	}
+	fdsBefore := counters.fdsIn.Load()
+	n, err := readWithFDs(conn, headerBytes)
 	if err != nil {
+		if n > 0 {
+			err = truncatedMsgErr{got: n, want: HEADER_SIZE, err: err}
+		}
 		return
 	}
 	id = binary.LittleEndian.Uint32(headerBytes[0:])
 	sizeNOpcode := binary.LittleEndian.Uint32(headerBytes[4:])
 	size := sizeNOpcode >> 16
 	opcode = sizeNOpcode & 0xffff
-	body = make([]byte, size-HEADER_SIZE)
-	_, err = conn.Read(body)
+	err = checkHeader(id, size)
+	if err != nil {
+		return
+	}
+	body = getBody(int(size - HEADER_SIZE))
+	n, err = readWithFDs(conn, body)
+	if err != nil {
+		err = truncatedMsgErr{id: id, opcode: opcode, got: HEADER_SIZE + n, want: int(size), err: err}
+		return
+	}
+	logMsg(id, opcode, body, int(counters.fdsIn.Load()-fdsBefore))
+	counters.msgsIn.Add(1)
+	counters.bytesIn.Add(uint64(size))
 	return
 }
 
+// errConnLost is wrapped by read errors meaning the compositor went away,
+// as opposed to the plain io.EOF of it closing the connection in between
+// messages.
+var errConnLost = errors.New("wayland connection lost")
+
+// errStreamCorrupt is wrapped by read errors meaning a message header makes
+// no sense, so the stream is out of sync, either from a misparsed message or
+// a broken compositor. Messages can't be delimited any other way, there's no
+// recovering from it.
+var errStreamCorrupt = errors.New("wayland stream corrupt")
+
+// serverIDMin is the first id of the range the compositor allocates from.
+const serverIDMin = 0xff000000
+
+// corruptMsgErr is returned by read for a header that fails checkHeader.
+type corruptMsgErr struct {
+	id, size uint32
+	reason   string
+}
+
+func (err corruptMsgErr) Error() string {
+	return errStreamCorrupt.Error() + ": message for object " + strconv.FormatUint(uint64(err.id), 10) +
+		" of " + strconv.FormatUint(uint64(err.size), 10) + " bytes: " + err.reason
+}
+
+func (err corruptMsgErr) Unwrap() error {
+	return errStreamCorrupt
+}
+
+// checkHeader checks the header of an event is plausible: its size fits the
+// header and is word aligned, and it's for an object that exists, client ids
+// being below objectsLen and compositor ones from serverIDMin.
+func checkHeader(id, size uint32) error {
+	switch {
+	case size < HEADER_SIZE:
+		return corruptMsgErr{id: id, size: size, reason: "smaller than the header"}
+	case size%WORD_SIZE != 0:
+		return corruptMsgErr{id: id, size: size, reason: "size isn't a multiple of 4"}
+	case id == 0:
+		return corruptMsgErr{id: id, size: size, reason: "null object"}
+	case id >= objectsLen && id < serverIDMin:
+		return corruptMsgErr{id: id, size: size, reason: "object id out of the client and server ranges"}
+	}
+	if id < objectsLen {
+		objMu.Lock()
+		t := objects[id]
+		objMu.Unlock()
+		if t == objNone {
+			return corruptMsgErr{id: id, size: size, reason: "unknown object"}
+		}
+	}
+	return nil
+}
+
+// truncatedMsgErr is returned by read when the connection breaks partway
+// through a message.
+type truncatedMsgErr struct {
+	id, opcode uint32
+	got, want  int
+	err        error
+}
+
+func (err truncatedMsgErr) Error() string {
+	msg := "truncated message, got " + strconv.Itoa(err.got) + " of " + strconv.Itoa(err.want) + " bytes"
+	if err.id != 0 {
+		msg += " for object " + strconv.FormatUint(uint64(err.id), 10) + " opcode " + strconv.FormatUint(uint64(err.opcode), 10)
+	}
+	return errConnLost.Error() + ": " + msg + ": " + err.err.Error()
+}
+
+func (err truncatedMsgErr) Unwrap() []error {
+	return []error{errConnLost, err.err}
+}
+
 type wlDisplayErr struct {
 	id   uint32
 	code uint32
-	msg  []byte
+	msg  string
+	// obj describes the object as of the error, see describeObj.
+	obj string
+	// known is the code's error from protocolErrors, if there.
+	known error
 }
 
 func (err wlDisplayErr) Error() string {
-	return "wl_display::error object " + strconv.FormatUint(uint64(err.id), 10) + " code " + strconv.FormatUint(uint64(err.code), 10) + ": " + string(err.msg)
+	obj := err.obj
+	if obj == "" {
+		obj = "object " + strconv.FormatUint(uint64(err.id), 10)
+	}
+	return "wl_display::error on " + obj + ", code " + strconv.FormatUint(uint64(err.code), 10) + ": " + err.msg
+}
+
+func (err wlDisplayErr) Unwrap() error {
+	return err.known
 }
 
 func handleWLDisplayEvent(opcode uint32, body []byte) error {
@@ -249,34 +650,164 @@ func handleWLDisplayEvent(opcode uint32, body []byte) error {
 	case 0: // error
 		code := binary.LittleEndian.Uint32(body[4:])
 		msg, _ := parseStr(body[8:])
-		return wlDisplayErr{id: object, code: code, msg: msg}
+		return wlDisplayErr{id: object, code: code, msg: string(msg), obj: describeObj(object), known: protocolErrors[objTypeOf(object)][code]}
 	case 1: // delete_id
+		objMu.Lock()
 		objects[object] = objNone
+		objVersions[object] = 0
+		surfaceRoles[object] = roleNone
+		xdgSurfaceRoles[object] = xdgRoleNone
+		objHandlers[object] = nil
+		callbackHandlers[object] = nil
+		objMu.Unlock()
+		forgetRequests(object)
+		forgetFocus(object)
 	}
 	return nil
 }
 
+// handleObjEvent handles events for objects without a fixed ID.
+func handleObjEvent(ctx context.Context, conn *net.UnixConn, id, opcode uint32, body []byte) (Event, error) {
+	if id >= objectsLen {
+		// Created by the compositor.
+		objMu.Lock()
+		t := serverObjects[id]
+		objMu.Unlock()
+		switch t {
+		case objWLDataOffer:
+			if ev, ok := handleWLDataOfferEvent(id, opcode, body); ok {
+				return ev, nil
+			}
+		}
+		logUnhandled(ctx, "wl msg", id, opcode, body)
+		return nil, nil
+	}
+	objMu.Lock()
+	t, h, cb := objects[id], objHandlers[id], callbackHandlers[id]
+	objMu.Unlock()
+	switch t {
+	case objWLOutput:
+		return handleWLOutputEvent(conn, id, opcode, body), nil
+	case objWLSeat:
+		return handleWLSeatEvent(conn, id, opcode, body), nil
+	case objWLBuffer:
+		if opcode == 0 { // release
+			retired, err := handleBufferRelease(conn, id)
+			if err != nil {
+				return nil, err
+			}
+			if retired {
+				return nil, nil
+			}
+			return WLBufferRelease{Buffer: id}, nil
+		}
+	case objWPImageDescription:
+		return handleWPImageDescriptionEvent(conn, id, opcode, body), nil
+	case objXDGActivationToken:
+		return handleXDGActivationTokenEvent(opcode, body), nil
+	case objWPPresentationFeedback:
+		return handleWPPresentationFeedbackEvent(id, opcode, body), nil
+	case objZWPLinuxDmabufFeedback:
+		handleZWPLinuxDmabufFeedbackEvent(id, opcode, body)
+		return nil, nil
+	case objXDGSurface:
+		// Surfaces other than XDGSurfaceID, like popups.
+		if opcode == 0 { // configure
+			serial := binary.LittleEndian.Uint32(body)
+			buf := makeMsgBuf(id, 4, WORD_SIZE)
+			buf = binary.LittleEndian.AppendUint32(buf, serial)
+			err := write(conn, buf)
+			if err != nil {
+				return nil, err
+			}
+			return XDGSurfaceConfigure{XDGSurface: id, Serial: serial}, nil
+		}
+	case objXDGPopup:
+		return handleXDGPopupEvent(id, opcode, body), nil
+	case objWLCallback, objWLFrameCallback:
+		// done is the only event. The callback is dead after it but its id
+		// stays taken until the delete_id that follows, reusing it earlier
+		// would have that delete_id free the new object's slot.
+		if cb != nil {
+			return cb(binary.LittleEndian.Uint32(body)), nil
+		}
+	case objZWLROutputPower:
+		return handleZWLROutputPowerEvent(conn, id, opcode, body), nil
+	case objZWLRGammaControl:
+		return handleZWLRGammaControlEvent(conn, id, opcode, body), nil
+	case objZWPKeyboardShortcutsInhibitor:
+		return handleZWPKeyboardShortcutsInhibitorEvent(id, opcode), nil
+	case objWLRegistry:
+		return decodeWLRegistryEvent(id, opcode, body), nil
+	case objCustom:
+		if h != nil {
+			h(conn, id, opcode, body)
+			return nil, nil
+		}
+		fallthrough
+	default:
+		logUnhandled(ctx, "wl msg", id, opcode, body)
+	}
+	return nil, nil
+}
+
+// mustGetReg creates the registry globals are bound through.
 func mustGetReg(conn *net.UnixConn) {
+	WLRegistryID = mustGetRegistry(conn)
+}
+
+// mustGetRegistry creates a registry. Registries other than WLRegistryID
+// don't bind anything, their globals are returned as WLRegistryGlobal and
+// WLRegistryGlobalRemove events with the registry's id.
+func mustGetRegistry(conn *net.UnixConn) (id uint32) {
+	id = regChildObj(objWLRegistry, WLDisplayID)
 	msgBytes := makeMsgBuf(WLDisplayID, 1, WORD_SIZE)
-	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, WLRegistryID)
-	_, err := conn.Write(msgBytes)
+	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, id)
+	err := write(conn, msgBytes)
 	if err != nil {
 		panic(err)
 	}
+	return id
 }
 
-func mustSync(conn *net.UnixConn) {
-	WLSyncCallbackID = regObj(objWLCallback)
+// callbackHandlers are called with the data of the done event of each
+// wl_callback, and return the event to pass on if any. Guarded by objMu.
+var callbackHandlers [objectsLen]func(data uint32) Event
+
+// regCallback registers a new wl_callback created by a request on parent,
+// with done called on its done event.
+func regCallback(t objType, parent uint32, done func(data uint32) Event) (id uint32) {
+	id = regChildObj(t, parent)
+	objMu.Lock()
+	callbackHandlers[id] = done
+	objMu.Unlock()
+	return id
+}
+
+// mustSync asks for a callback done once the compositor has processed every
+// request before it. Its data, the event serial, is rarely of use. The
+// callback's id is freed by the delete_id following done.
+func mustSync(conn *net.UnixConn, done func(data uint32) Event) (id uint32) {
+	id = regCallback(objWLCallback, WLDisplayID, done)
 	msgBytes := makeMsgBuf(WLDisplayID, 0, WORD_SIZE)
-	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, WLSyncCallbackID)
-	_, err := conn.Write(msgBytes)
+	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, id)
+	err := write(conn, msgBytes)
 	if err != nil {
 		panic(err)
 	}
+	return id
 }
 
+// mustRegBind binds the global name at the lower of ver, the version the
+// compositor advertised, and the version this package supports.
 func mustRegBind(conn *net.UnixConn, t objType, name, ver uint32, iface []byte) (id uint32) {
-	id = regObj(t)
+	if v := supportedVersion(string(iface)); v != 0 && v < ver {
+		ver = v
+	}
+	id = regObjVersion(t, ver)
+	globalsMu.Lock()
+	boundGlobals[name] = id
+	globalsMu.Unlock()
 	strLen := uint32(len(iface) + 1)
 	padding := (4 - strLen%4) % 4
 	msgBytes := makeMsgBuf(WLRegistryID, 0, WORD_SIZE*4+strLen+padding)
@@ -289,7 +820,7 @@ func mustRegBind(conn *net.UnixConn, t objType, name, ver uint32, iface []byte)
 	}
 	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, ver)
 	msgBytes = binary.LittleEndian.AppendUint32(msgBytes, id)
-	_, err := conn.Write(msgBytes)
+	err := write(conn, msgBytes)
 	if err != nil {
 		panic(err)
 	}
@@ -297,135 +828,237 @@ func mustRegBind(conn *net.UnixConn, t objType, name, ver uint32, iface []byte)
 }
 
 func mustCreatePool(conn *net.UnixConn) {
-	buf := makeMsgBuf(WLShmID, 0, WORD_SIZE*2)
-	WLShmPoolID = regObj(objWLShmPool)
-	buf = binary.LittleEndian.AppendUint32(buf, WLShmPoolID)
-	const size = 100 * 100 * 4
-	buf = binary.LittleEndian.AppendUint32(buf, size)
 	var err error
-	WLShmPoolFile, err = os.CreateTemp("", "wl_shm_pool")
-	if err != nil {
-		panic(err)
-	}
-	err = WLShmPoolFile.Truncate(size)
-	if err != nil {
-		panic(err)
-	}
-
-	fd := int(WLShmPoolFile.Fd())
-	WLShmPoolBuf, err = unix.Mmap(fd, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
-	if err != nil {
-		panic(err)
-	}
-	_, _, err = conn.WriteMsgUnix(buf, unix.UnixRights(fd), nil)
+	WLShmPoolID, WLShmPoolFile, WLShmPoolBuf, err = createPool(conn, int64(bufWidth)*int64(bufHeight)*4)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func mustCreateBuffer(conn *net.UnixConn) {
-	buf := makeMsgBuf(WLShmPoolID, 0, WORD_SIZE*6)
-	WLBufferID = regObj(objWLBuffer)
-	buf = binary.LittleEndian.AppendUint32(buf, WLBufferID)
-	buf = binary.LittleEndian.AppendUint32(buf, 0)
-	buf = binary.LittleEndian.AppendUint32(buf, 100)
-	buf = binary.LittleEndian.AppendUint32(buf, 100)
-	buf = binary.LittleEndian.AppendUint32(buf, 100*4)
-	buf = binary.LittleEndian.AppendUint32(buf, 1)
-	_, err := conn.Write(buf)
+func mustCreateBuffer(conn *net.UnixConn, format shmFormat) {
+	var err error
+	WLBufferID, err = createBuffer(conn, WLShmPoolID, len(WLShmPoolBuf), shmBuffer{
+		width:  int32(bufWidth),
+		height: int32(bufHeight),
+		stride: int32(bufWidth * 4),
+		format: format,
+	})
 	if err != nil {
 		panic(err)
 	}
 }
+
 func mustCreateSurface(conn *net.UnixConn) {
+	WLSurfaceID = mustNewSurface(conn)
+}
+
+// mustNewSurface creates a wl_surface besides WLSurfaceID, e.g. for a
+// subsurface or a cursor.
+func mustNewSurface(conn *net.UnixConn) (id uint32) {
 	buf := makeMsgBuf(WLCompositorID, 0, WORD_SIZE)
-	WLSurfaceID = regObj(objWLSurface)
-	buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
-	_, err := conn.Write(buf)
+	id = regChildObj(objWLSurface, WLCompositorID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
+	return id
 }
-func mustAttach(conn *net.UnixConn) {
+
+// mustAttachNull attaches no buffer, the commit that follows unmaps the
+// surface until a buffer is attached again.
+func mustAttachNull(conn *net.UnixConn) {
 	buf := makeMsgBuf(WLSurfaceID, 1, WORD_SIZE*3)
-	buf = binary.LittleEndian.AppendUint32(buf, WLBufferID)
 	buf = binary.LittleEndian.AppendUint32(buf, 0)
 	buf = binary.LittleEndian.AppendUint32(buf, 0)
-	_, err := conn.Write(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
 }
-func mustDamage(conn *net.UnixConn) {
-	buf := makeMsgBuf(WLSurfaceID, 9, WORD_SIZE*4)
+func mustAttach(conn *net.UnixConn) {
+	buf := makeMsgBuf(WLSurfaceID, 1, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, WLBufferID)
+	// The offset has to be 0 since wl_surface v5, offset sets it instead.
 	buf = binary.LittleEndian.AppendUint32(buf, 0)
 	buf = binary.LittleEndian.AppendUint32(buf, 0)
-	buf = binary.LittleEndian.AppendUint32(buf, 100)
-	buf = binary.LittleEndian.AppendUint32(buf, 100)
-	_, err := conn.Write(buf)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
+	bufferAttached = true
 }
+
+// mustCommit applies the staged surface state and commits it, along with the
+// buffer attached since the last commit if any.
 func mustCommit(conn *net.UnixConn) {
+	mustApplySurfaceState(conn)
 	buf := makeMsgBuf(WLSurfaceID, 6, 0)
-	_, err := conn.Write(buf)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
+	if bufferAttached {
+		bufferAttached = false
+		markBufferBusy(WLBufferID)
+	}
 }
 
 var wlFrameCallBuf []byte
 
 func mustFrame(conn *net.UnixConn) {
-	WLFrameCallbackID = regObj(objWLCallback)
+	var id uint32
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	id = regCallback(objWLFrameCallback, WLSurfaceID, func(data uint32) Event {
+		frameMu.Lock()
+		defer frameMu.Unlock()
+		// Only the latest callback drives drawing, one replaced by another
+		// mustFrame would start a second chain of frames.
+		if id != WLFrameCallbackID {
+			return nil
+		}
+		WLFrameCallbackID = 0
+		return WLSurfaceFrameDone{Time: data}
+	})
+	WLFrameCallbackID = id
+	frameRequestedAt = clk.Now()
 	if wlFrameCallBuf == nil {
 		wlFrameCallBuf = makeMsgBuf(WLSurfaceID, 3, WORD_SIZE)
 		wlFrameCallBuf = binary.LittleEndian.AppendUint32(wlFrameCallBuf, WLFrameCallbackID)
 	} else {
 		binary.LittleEndian.PutUint32(wlFrameCallBuf[HEADER_SIZE:], WLFrameCallbackID)
 	}
-	_, err := conn.Write(wlFrameCallBuf)
+	err := write(conn, wlFrameCallBuf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// requestFrame asks for a frame callback on WLSurfaceID like mustFrame, but
+// delivers its done time on the returned channel instead of as a
+// WLSurfaceFrameDone event, for render loops selecting on it. The channel
+// gets one value, sent once the callback is done, and is then closed. The
+// request takes effect with the next commit.
+func requestFrame(conn *net.UnixConn) <-chan uint32 {
+	done := make(chan uint32, 1)
+	id := regCallback(objWLFrameCallback, WLSurfaceID, func(data uint32) Event {
+		done <- data
+		close(done)
+		return nil
+	})
+	buf := makeMsgBuf(WLSurfaceID, 3, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
+	return done
+}
+
+var errFrameTimeout = errors.New("wl_surface: no frame callback before the timeout, the surface may be hidden")
+
+// commitAndWait commits with a frame callback and waits for it, i.e. until
+// the compositor wants the next frame, which means the committed one was
+// used, and returns the callback's timestamp. Hidden surfaces may get no
+// frame callbacks at all, so it gives up after timeout. Events have to be
+// handled on another goroutine meanwhile, by startDispatcher for instance.
+func commitAndWait(conn *net.UnixConn, timeout time.Duration) (ms uint32, err error) {
+	done := requestFrame(conn)
+	mustCommit(conn)
+	if flushPolicy != flushEveryRequest {
+		err = flush(conn)
+		if err != nil {
+			return 0, err
+		}
+	}
+	select {
+	case ms = <-done:
+		return ms, nil
+	case <-clk.After(timeout):
+		return 0, errFrameTimeout
+	}
 }
 func mustGetXDGSurface(conn *net.UnixConn) {
+	err := assignRole(WLSurfaceID, roleXDGSurface)
+	if err != nil {
+		panic(err)
+	}
 	buf := makeMsgBuf(XDGWMBaseID, 2, WORD_SIZE*2)
-	XDGSurfaceID = regObj(objXDGSurface)
+	XDGSurfaceID = regChildObj(objXDGSurface, XDGWMBaseID)
 	buf = binary.LittleEndian.AppendUint32(buf, XDGSurfaceID)
 	buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
-	_, err := conn.Write(buf)
+	err = write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
 }
 func mustGetTopLevel(conn *net.UnixConn) {
+	err := assignXDGRole(XDGSurfaceID, xdgRoleToplevel)
+	if err != nil {
+		panic(err)
+	}
 	buf := makeMsgBuf(XDGSurfaceID, 1, WORD_SIZE)
-	XDGTopLevelID = regObj(objXDGTopLevel)
+	XDGTopLevelID = regChildObj(objXDGTopLevel, XDGSurfaceID)
 	buf = binary.LittleEndian.AppendUint32(buf, XDGTopLevelID)
-	_, err := conn.Write(buf)
+	err = write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
 }
+
+// setParent makes XDGTopLevelID a child of the toplevel parent, e.g. for a
+// dialog, so it's stacked above and minimized with it. parent has to be
+// mapped, an unmapped parent is treated as no parent. 0 unsets the parent.
+func setParent(conn *net.UnixConn, parent uint32) error {
+	if parent != 0 {
+		if objTypeOf(parent) != objXDGTopLevel {
+			return errors.New("xdg_toplevel: parent " + strconv.Itoa(int(parent)) + " isn't an xdg_toplevel")
+		}
+		if parent == XDGTopLevelID {
+			return errors.New("xdg_toplevel: a toplevel can't be its own parent")
+		}
+	}
+	buf := makeMsgBuf(XDGTopLevelID, 1, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, parent) // 0 is null
+	return write(conn, buf)
+}
+
+// configureMu guards what the compositor asks of the surface, which the
+// dispatcher goroutine records while the app draws and commits:
+// pendingConfigure, pendingWindowWidth and pendingWindowHeight,
+// toplevelResizing, preferredScale and preferredTransform. Applying any of
+// it is left to the goroutine drawing, as it replaces the buffer.
+var configureMu sync.Mutex
+
+// pendingConfigure is the serial of XDGSurfaceID's latest configure, 0 once
+// acked. Acking a configure means the next commit applies it, so it's acked
+// right before the commit, and a burst of configures in between is only
+// acked as the latest.
+var pendingConfigure uint32
+
 func mustAckConfigure(conn *net.UnixConn, serial uint32) {
 	buf := makeMsgBuf(XDGSurfaceID, 4, WORD_SIZE)
 	buf = binary.LittleEndian.AppendUint32(buf, serial)
-	_, err := conn.Write(buf)
+	err := write(conn, buf)
 	if err != nil {
 		panic(err)
 	}
 }
 func mustPong(conn *net.UnixConn, serial uint32) {
-	buf := makeMsgBuf(XDGWMBaseID, 3, WORD_SIZE)
-	buf = binary.LittleEndian.AppendUint32(buf, serial)
-	_, err := conn.Write(buf)
+	err := pong(conn, serial)
 	if err != nil {
 		panic(err)
 	}
 }
 
+func pong(conn *net.UnixConn, serial uint32) error {
+	buf := makeMsgBuf(XDGWMBaseID, 3, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, serial)
+	return write(conn, buf)
+}
+
 func makeMsgBuf(id uint32, opcode uint16, dataLen uint32) []byte {
 	msgLen := HEADER_SIZE + dataLen
 	buf := make([]byte, HEADER_SIZE, msgLen)
@@ -434,6 +1067,70 @@ func makeMsgBuf(id uint32, opcode uint16, dataLen uint32) []byte {
 	return buf
 }
 
+// writeTempFile writes b to an unlinked temp file and returns it rewound, for
+// passing its fd to the compositor.
+func writeTempFile(pattern string, b []byte) (*os.File, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// toFixed converts f to wl_fixed_t, a signed 24.8 fixed point number.
+func toFixed(f float64) uint32 {
+	return uint32(int32(f * 256))
+}
+
+func fromFixed(v uint32) float64 {
+	return float64(int32(v)) / 256
+}
+
+// strSize returns the size of s as a string argument, length word included.
+func strSize(s string) uint32 {
+	n := uint32(len(s) + 1)
+	return WORD_SIZE + n + (4-n%4)%4
+}
+
+// appendStr appends s as a string argument.
+func appendStr(b []byte, s string) []byte {
+	n := uint32(len(s) + 1)
+	b = binary.LittleEndian.AppendUint32(b, n)
+	b = append(b, s...)
+	b = append(b, 0)
+	for range (4 - n%4) % 4 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// parseArray returns the array at the start of b and the offset past it.
+func parseArray(b []byte) ([]byte, uint32) {
+	n := binary.LittleEndian.Uint32(b)
+	end := 4 + n
+	pad := (4 - n%4) % 4
+	return b[4:end], end + pad
+}
+
+// parseUint32s returns the uint32 array at the start of b.
+func parseUint32s(b []byte) []uint32 {
+	arr, _ := parseArray(b)
+	words := make([]uint32, len(arr)/WORD_SIZE)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(arr[i*WORD_SIZE:])
+	}
+	return words
+}
+
 func parseStr(b []byte) ([]byte, uint32) {
 	n := binary.LittleEndian.Uint32(b)
 	end := 4 + n
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRoundtripFreesCallback(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	done := 0
+	cb := mustSync(conn, func(data uint32) Event {
+		done++
+		return nil
+	})
+	err := roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != 1 {
+		t.Errorf("earlier sync done %d times, want 1", done)
+	}
+	if got := objTypeOf(cb); got != objNone {
+		t.Errorf("callback %d still registered as %v", cb, got)
+	}
+	if id := regObj(objWLCallback); id > cb+1 {
+		t.Errorf("got id %d after the roundtrip, the callbacks weren't freed", id)
+	}
+}
+
+func TestSetParent(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	XDGTopLevelID = regObj(objXDGTopLevel)
+	parent := regObj(objXDGTopLevel)
+
+	for _, bad := range []uint32{XDGTopLevelID, WLSurfaceID, objectsLen, serverIDMin} {
+		err := setParent(conn, bad)
+		if err == nil {
+			t.Errorf("set %d as the parent", bad)
+		}
+	}
+	for _, good := range []uint32{parent, 0} {
+		err := setParent(conn, good)
+		if err != nil {
+			t.Errorf("setting %d as the parent: %v", good, err)
+		}
+	}
+}
+
+func TestCheckHeader(t *testing.T) {
+	resetTestState(t)
+	for _, tt := range []struct {
+		name     string
+		id, size uint32
+		ok       bool
+	}{
+		{"display", WLDisplayID, 12, true},
+		{"server object", serverIDMin, 8, true},
+		{"smaller than the header", WLDisplayID, 4, false},
+		{"unaligned", WLDisplayID, 10, false},
+		{"null object", 0, 8, false},
+		{"between the ranges", objectsLen, 8, false},
+		{"unknown client object", objectsLen - 1, 8, false},
+	} {
+		err := checkHeader(tt.id, tt.size)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, errStreamCorrupt) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, errStreamCorrupt)
+		}
+	}
+}
+
+func TestReadCorruptHeader(t *testing.T) {
+	resetTestState(t)
+	client, server := newConnPair(t)
+	msg := binary.LittleEndian.AppendUint32(nil, objectsLen)
+	msg = binary.LittleEndian.AppendUint32(msg, 8<<16)
+	_, err := server.Write(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, body, err := read(client)
+	releaseBody(body)
+	if !errors.Is(err, errStreamCorrupt) {
+		t.Errorf("got %v, want %v", err, errStreamCorrupt)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	header := binary.LittleEndian.AppendUint32(nil, WLDisplayID)
+	header = binary.LittleEndian.AppendUint32(header, 16<<16|1)
+	for _, tt := range []struct {
+		name    string
+		written []byte
+		// want is the truncation read reports, nil for a clean io.EOF.
+		want *truncatedMsgErr
+	}{
+		{"closed between messages", nil, nil},
+		{"closed in the header", header[:5], &truncatedMsgErr{got: 5, want: HEADER_SIZE}},
+		{"closed in the body", append(header, 1, 2, 3, 4), &truncatedMsgErr{id: WLDisplayID, opcode: 1, got: 12, want: 16}},
+		{"closed after the header", header, &truncatedMsgErr{id: WLDisplayID, opcode: 1, got: 8, want: 16}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			client, server := newConnPair(t)
+			if _, err := server.Write(tt.written); err != nil {
+				t.Fatal(err)
+			}
+			server.Close()
+			_, _, body, err := read(client)
+			releaseBody(body)
+			if tt.want == nil {
+				if err != io.EOF {
+					t.Fatalf("got %v, want a plain %v", err, io.EOF)
+				}
+				return
+			}
+			var got truncatedMsgErr
+			if !errors.As(err, &got) {
+				t.Fatalf("got %v, want a truncatedMsgErr", err)
+			}
+			if !errors.Is(err, errConnLost) || !errors.Is(err, io.EOF) || !isConnErr(err) {
+				t.Errorf("got %v, want a connection loss wrapping %v", err, io.EOF)
+			}
+			got.err = nil
+			if got != *tt.want {
+				t.Errorf("got %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+// strictShell is a mockCompositor hook enforcing xdg_shell's startup
+// sequence: the toplevel's first commit has no buffer and is answered with
+// a configure, which has to be acked before a buffer is committed. It
+// learns the objects from the requests creating them.
+type strictShell struct {
+	compositor, wmBase uint32
+	serial             uint32
+
+	mu                            sync.Mutex
+	m                             *mockCompositor
+	surface, xdgSurface, toplevel uint32
+	configured, acked, attached   bool
+	steps, violations             []string
+}
+
+func (s *strictShell) onRequest(id, opcode uint32, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arg := func(i int) uint32 { return binary.LittleEndian.Uint32(body[i*4:]) }
+	switch {
+	case id == s.compositor && opcode == 0:
+		s.surface = arg(0)
+	case id == s.wmBase && opcode == 2 && arg(1) == s.surface:
+		s.xdgSurface = arg(0)
+	case id == s.xdgSurface && opcode == 1:
+		s.toplevel = arg(0)
+	case id == s.xdgSurface && opcode == 4:
+		if !s.configured || arg(0) != s.serial {
+			s.violations = append(s.violations, "ack_configure of a serial not sent")
+		}
+		s.acked = true
+		s.steps = append(s.steps, "ack_configure")
+	case id == s.surface && opcode == 1:
+		s.attached = arg(0) != 0
+	case id == s.surface && opcode == 6:
+		switch {
+		case s.toplevel == 0:
+		case !s.configured && s.attached:
+			s.violations = append(s.violations, "buffer committed before the initial configure")
+		case !s.configured:
+			s.configured = true
+			s.steps = append(s.steps, "initial commit")
+			s.m.emit(s.toplevel, 0, 0, 0, 0)
+			s.m.emit(s.xdgSurface, 0, s.serial)
+		case s.attached && !s.acked:
+			s.violations = append(s.violations, "buffer committed before ack_configure")
+		case s.attached:
+			s.steps = append(s.steps, "buffer commit")
+		}
+		s.attached = false
+	}
+}
+
+func TestStartupOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		open       func(ctx context.Context, conn *net.UnixConn) error
+		steps      []string
+		violations []string
+	}{
+		{"openWindow", openWindow,
+			[]string{"initial commit", "ack_configure", "buffer commit"}, nil},
+		{"attaching before the configure", func(ctx context.Context, conn *net.UnixConn) error {
+			mustCreateSurface(conn)
+			mustGetXDGSurface(conn)
+			mustGetTopLevel(conn)
+			mustCreatePool(conn)
+			mustCreateBuffer(conn, bufFormat)
+			mustAttach(conn)
+			mustCommit(conn)
+			return roundtrip(ctx, conn)
+		}, nil, []string{"buffer committed before the initial configure"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			WLCompositorID = regObjVersion(objWLCompositor, 6)
+			WLShmID = regObjVersion(objWLShm, 1)
+			XDGWMBaseID = regObj(objXDGWMBase)
+			shell := &strictShell{compositor: WLCompositorID, wmBase: XDGWMBaseID, serial: 7}
+			conn, m := startMockCompositor(t, shell.onRequest)
+			shell.mu.Lock()
+			shell.m = m
+			shell.mu.Unlock()
+			t.Cleanup(func() {
+				unix.Munmap(WLShmPoolBuf)
+				WLShmPoolFile.Close()
+			})
+			// A compositor that never configures fails the test instead of
+			// hanging it.
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			err := tt.open(context.Background(), conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := roundtrip(context.Background(), conn); err != nil {
+				t.Fatal(err)
+			}
+			shell.mu.Lock()
+			defer shell.mu.Unlock()
+			if !slices.Equal(shell.steps, tt.steps) || !slices.Equal(shell.violations, tt.violations) {
+				t.Errorf("got steps %q and violations %q, want %q and %q", shell.steps, shell.violations, tt.steps, tt.violations)
+			}
+		})
+	}
+}
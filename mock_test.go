@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// newConnPair returns both ends of a connected unix socketpair, closed when
+// the test ends.
+func newConnPair(tb testing.TB) (client, server *net.UnixConn) {
+	tb.Helper()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+		tb.Cleanup(func() { c.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+// resetTestState puts the globals tests touch back to how a new client
+// starts, and restores the knobs a test may turn when it ends.
+func resetTestState(tb testing.TB) {
+	tb.Helper()
+	resetObjects()
+	frameRequestedAt = clk.Now()
+	pendingSurface = surfaceState{scale: 1, alpha: math.MaxUint32}
+	currentSurface = pendingSurface
+	pendingDamage, pendingSurfaceDamage = nil, nil
+	bufWidth, bufHeight = winWidth, winHeight
+	windowWidth, windowHeight = winWidth, winHeight
+	readMu.Lock()
+	readQ = nil
+	readMu.Unlock()
+
+	savedClk, savedFlush, savedLatency, savedDiff := clk, flushPolicy, latencyMode, diffDamage
+	tb.Cleanup(func() {
+		clk, flushPolicy, latencyMode, diffDamage = savedClk, savedFlush, savedLatency, savedDiff
+		outMu.Lock()
+		outBuf, outFDs = outBuf[:0], outFDs[:0]
+		outMu.Unlock()
+	})
+}
+
+// mockCompositor is the compositor end of a socketpair, answering just
+// enough to drive a client in process: wl_display.sync is done right away,
+// and a wl_surface commit releases the buffer attached with it, unless
+// holdBuffers, then does the frame callbacks requested before it.
+// Everything else is only passed to onRequest.
+type mockCompositor struct {
+	conn *net.UnixConn
+	// onRequest, if set, sees every request on the mock's goroutine.
+	onRequest func(id, opcode uint32, body []byte)
+	// hidden holds frame callbacks back like a compositor throttling a
+	// hidden surface, until show.
+	hidden atomic.Bool
+	// holdBuffers keeps each committed buffer until another is committed,
+	// like a compositor sampling it while compositing, rather than
+	// releasing it right away.
+	holdBuffers atomic.Bool
+
+	mu       sync.Mutex
+	out      []byte
+	fds      []int
+	frames   []uint32
+	attached uint32
+	held     uint32
+	time     uint32
+	done     chan struct{}
+}
+
+// startMockCompositor connects a client to a new mockCompositor, which
+// stops when the test ends.
+func startMockCompositor(tb testing.TB, onRequest func(id, opcode uint32, body []byte)) (*net.UnixConn, *mockCompositor) {
+	tb.Helper()
+	client, server := newConnPair(tb)
+	m := &mockCompositor{conn: server, onRequest: onRequest, done: make(chan struct{})}
+	go m.serve()
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+		<-m.done
+		for _, fd := range m.fds {
+			unix.Close(fd)
+		}
+	})
+	return client, m
+}
+
+// newMockSurface starts a mockCompositor and sets up the client as main
+// does up to its first frame: a wl_surface with a wl_buffer of
+// bufWidth×bufHeight in its own pool.
+func newMockSurface(tb testing.TB, onRequest func(id, opcode uint32, body []byte)) (*net.UnixConn, *mockCompositor) {
+	tb.Helper()
+	resetTestState(tb)
+	conn, m := startMockCompositor(tb, onRequest)
+	WLCompositorID = regObjVersion(objWLCompositor, 6)
+	WLShmID = regObjVersion(objWLShm, 1)
+	mustCreateSurface(conn)
+	mustCreatePool(conn)
+	tb.Cleanup(func() {
+		unix.Munmap(WLShmPoolBuf)
+		WLShmPoolFile.Close()
+	})
+	mustCreateBuffer(conn, bufFormat)
+	return conn, m
+}
+
+func (m *mockCompositor) serve() {
+	defer close(m.done)
+	chunk := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(outFDsMax*4))
+	var msgs []byte
+	for {
+		n, oobn, _, _, err := m.conn.ReadMsgUnix(chunk, oob)
+		if oobn > 0 {
+			m.queueFDs(oob[:oobn])
+		}
+		if err != nil || n == 0 {
+			return
+		}
+		msgs = append(msgs, chunk[:n]...)
+		start := 0
+		for len(msgs)-start >= HEADER_SIZE {
+			id := binary.LittleEndian.Uint32(msgs[start:])
+			sizeNOpcode := binary.LittleEndian.Uint32(msgs[start+4:])
+			size := int(sizeNOpcode >> 16)
+			if size < HEADER_SIZE {
+				return
+			}
+			if len(msgs)-start < size {
+				break
+			}
+			m.handle(id, sizeNOpcode&0xffff, msgs[start+HEADER_SIZE:start+size])
+			start += size
+		}
+		msgs = msgs[:copy(msgs, msgs[start:])]
+	}
+}
+
+func (m *mockCompositor) queueFDs(oob []byte) {
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, cmsg := range cmsgs {
+		fds, err := unix.ParseUnixRights(&cmsg)
+		if err == nil {
+			m.fds = append(m.fds, fds...)
+		}
+	}
+}
+
+// takeFDs returns the fds received so far, the caller closes them.
+func (m *mockCompositor) takeFDs() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fds := m.fds
+	m.fds = nil
+	return fds
+}
+
+func (m *mockCompositor) handle(id, opcode uint32, body []byte) {
+	if m.onRequest != nil {
+		m.onRequest(id, opcode, body)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id == WLDisplayID {
+		if opcode == 0 { // sync
+			callback := binary.LittleEndian.Uint32(body)
+			m.send(callback, 0, 0)
+			m.send(WLDisplayID, 1, callback)
+		}
+		return
+	}
+	if objTypeOf(id) != objWLSurface {
+		return
+	}
+	switch opcode {
+	case 1: // attach
+		m.attached = binary.LittleEndian.Uint32(body)
+	case 3: // frame
+		m.frames = append(m.frames, binary.LittleEndian.Uint32(body))
+	case 6: // commit
+		if m.attached != 0 {
+			release := m.attached
+			if m.holdBuffers.Load() {
+				release, m.held = m.held, m.attached
+			}
+			if release != 0 && release != m.held {
+				m.send(release, 0)
+			}
+			m.attached = 0
+		}
+		if !m.hidden.Load() {
+			m.doneFrames()
+		}
+	}
+}
+
+// releaseHeld releases the buffer kept by holdBuffers.
+func (m *mockCompositor) releaseHeld() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.held != 0 {
+		m.send(m.held, 0)
+		m.held = 0
+	}
+}
+
+// show stops holding frame callbacks back and does the ones requested
+// while hidden.
+func (m *mockCompositor) show() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hidden.Store(false)
+	m.doneFrames()
+}
+
+// pendingFrames returns how many frame callbacks are waiting to be done.
+func (m *mockCompositor) pendingFrames() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.frames)
+}
+
+func (m *mockCompositor) doneFrames() {
+	m.time += 16
+	for _, callback := range m.frames {
+		m.send(callback, 0, m.time)
+		m.send(WLDisplayID, 1, callback)
+	}
+	m.frames = m.frames[:0]
+}
+
+// emit sends an event with uint32 args from outside the mock's goroutine.
+func (m *mockCompositor) emit(id, opcode uint32, args ...uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.send(id, opcode, args...)
+}
+
+// send writes an event with uint32 args, m.mu held.
+func (m *mockCompositor) send(id, opcode uint32, args ...uint32) {
+	size := HEADER_SIZE + WORD_SIZE*len(args)
+	m.out = binary.LittleEndian.AppendUint32(m.out[:0], id)
+	m.out = binary.LittleEndian.AppendUint32(m.out, uint32(size)<<16|opcode)
+	for _, arg := range args {
+		m.out = binary.LittleEndian.AppendUint32(m.out, arg)
+	}
+	m.conn.Write(m.out)
+}
+
+// nextEvent reads and handles messages until one decodes to an event.
+func nextEvent(tb testing.TB, conn *net.UnixConn) Event {
+	tb.Helper()
+	for {
+		id, opcode, body, err := read(conn)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ev, err := handleEvent(context.Background(), conn, id, opcode, body)
+		releaseBody(body)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if ev != nil {
+			return ev
+		}
+	}
+}
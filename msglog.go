@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The message log records every message read, for replaying a session's
+// events against the handlers without a compositor. Each record is
+//
+//	size   uint32, of the rest of the record
+//	time   int64, unix nanoseconds when it was read
+//	id     uint32
+//	opcode uint32
+//	fds    uint32, fds received along with the message
+//	body   [size-20]byte
+//
+// all little-endian. The fds themselves aren't recorded.
+
+const msgLogHeaderSize = 8 + 3*WORD_SIZE
+
+var (
+	msgLogMu sync.Mutex
+	msgLog   *bufio.Writer
+	msgLogF  *os.File
+)
+
+// recordMsgs starts logging every message read to a new file at path,
+// replacing the log being recorded if any.
+func recordMsgs(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = stopRecording()
+	msgLogMu.Lock()
+	msgLogF, msgLog = f, bufio.NewWriter(f)
+	msgLogMu.Unlock()
+	return err
+}
+
+// stopRecording flushes and closes the message log.
+func stopRecording() error {
+	msgLogMu.Lock()
+	defer msgLogMu.Unlock()
+	if msgLogF == nil {
+		return nil
+	}
+	err := errors.Join(msgLog.Flush(), msgLogF.Close())
+	msgLogF, msgLog = nil, nil
+	return err
+}
+
+// logMsg appends a message to the log if one is being recorded. Write errors
+// stop the recording rather than the connection.
+func logMsg(id, opcode uint32, body []byte, fds int) {
+	msgLogMu.Lock()
+	defer msgLogMu.Unlock()
+	if msgLog == nil {
+		return
+	}
+	rec := make([]byte, 0, WORD_SIZE+msgLogHeaderSize+len(body))
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(msgLogHeaderSize+len(body)))
+	rec = binary.LittleEndian.AppendUint64(rec, uint64(clk.Now().UnixNano()))
+	rec = binary.LittleEndian.AppendUint32(rec, id)
+	rec = binary.LittleEndian.AppendUint32(rec, opcode)
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(fds))
+	rec = append(rec, body...)
+	_, err := msgLog.Write(rec)
+	if err != nil {
+		msgLogF.Close()
+		msgLogF, msgLog = nil, nil
+	}
+}
+
+// loggedMsg is a record of the message log.
+type loggedMsg struct {
+	time       time.Time
+	id, opcode uint32
+	fds        int
+	body       []byte
+}
+
+// readLoggedMsg reads the next record, io.EOF at the end of the log.
+func readLoggedMsg(r io.Reader) (m loggedMsg, err error) {
+	var sizeBytes [WORD_SIZE]byte
+	_, err = io.ReadFull(r, sizeBytes[:])
+	if err != nil {
+		return m, err
+	}
+	size := binary.LittleEndian.Uint32(sizeBytes[:])
+	if size < msgLogHeaderSize {
+		return m, errors.New("message log: record shorter than its header")
+	}
+	rec := make([]byte, size)
+	_, err = io.ReadFull(r, rec)
+	if err != nil {
+		return m, io.ErrUnexpectedEOF
+	}
+	m.time = time.Unix(0, int64(binary.LittleEndian.Uint64(rec)))
+	m.id = binary.LittleEndian.Uint32(rec[8:])
+	m.opcode = binary.LittleEndian.Uint32(rec[12:])
+	m.fds = int(binary.LittleEndian.Uint32(rec[16:]))
+	m.body = rec[msgLogHeaderSize:]
+	return m, nil
+}
+
+// replayFile feeds the messages logged at path to handleEvent and returns
+// the events decoded. Requests the handlers make in reply go to conn, which
+// can be one end of a socketpair nothing reads. Recorded fds aren't
+// available, handlers taking one get -1. The object table has to be in the
+// state it was in when recording started, normally fresh.
+func replayFile(ctx context.Context, conn *net.UnixConn, path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var events []Event
+	for {
+		m, err := readLoggedMsg(r)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		ev, err := handleEvent(ctx, conn, m.id, m.opcode, m.body)
+		if err != nil {
+			return events, err
+		}
+		if ev != nil {
+			events = append(events, ev)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// The maps of output state are guarded by globalsMu, the dispatcher
+// goroutine updates them.
+
+// outputScales maps each bound wl_output to its scale.
+var outputScales = map[uint32]int32{}
+
+// outputNames maps each bound wl_output to its name, like "DP-1", which
+// unlike the object stays the same across reconnects and hotplugs. Outputs
+// whose global was removed are dropped.
+var outputNames = map[uint32]string{}
+
+// surfaceOutputs are the outputs WLSurfaceID is on, per wl_surface::enter
+// and leave.
+var surfaceOutputs = map[uint32]bool{}
+
+// outputInfo is a wl_output's properties as of its last done.
+type outputInfo struct {
+	Name, Description   string
+	Make, Model         string
+	X, Y                int32
+	PhysWidth           int32 // mm
+	PhysHeight          int32 // mm
+	Subpixel, Transform uint32
+	// Width, Height and Refresh are of the current mode, Refresh in mHz.
+	Width, Height, Refresh int32
+	Scale                  int32
+}
+
+// wl_output::mode flags
+const (
+	WLOutputModeCurrent   = 1
+	WLOutputModePreferred = 2
+)
+
+var (
+	// outputs are the properties of each bound wl_output, applied on done.
+	outputs = map[uint32]outputInfo{}
+	// pendingOutputs collect the properties sent since the last done,
+	// starting from the applied ones.
+	pendingOutputs = map[uint32]*outputInfo{}
+)
+
+// onOutputChange, if set, is called once per wl_output::done with the
+// output's properties, after they were all applied together. Outputs bound
+// below version 2 have no done and call it on every property instead.
+var onOutputChange func(conn *net.UnixConn, id uint32, info outputInfo)
+
+// handleWLOutputEvent stages the properties an output sends and applies them
+// together on done, so nothing sees e.g. the new mode with the old scale.
+func handleWLOutputEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	if opcode == 2 { // done
+		return applyOutput(conn, id)
+	}
+	globalsMu.Lock()
+	p := pendingOutputs[id]
+	if p == nil {
+		info := outputs[id]
+		if info.Scale == 0 {
+			info.Scale = 1
+		}
+		p = &info
+		pendingOutputs[id] = p
+	}
+	switch opcode {
+	case 0: // geometry
+		p.X = int32(binary.LittleEndian.Uint32(body))
+		p.Y = int32(binary.LittleEndian.Uint32(body[4:]))
+		p.PhysWidth = int32(binary.LittleEndian.Uint32(body[8:]))
+		p.PhysHeight = int32(binary.LittleEndian.Uint32(body[12:]))
+		p.Subpixel = binary.LittleEndian.Uint32(body[16:])
+		mk, off := parseStr(body[20:])
+		model, off2 := parseStr(body[20+off:])
+		p.Make, p.Model = string(mk), string(model)
+		p.Transform = binary.LittleEndian.Uint32(body[20+off+off2:])
+	case 1: // mode
+		if binary.LittleEndian.Uint32(body)&WLOutputModeCurrent == 0 {
+			break
+		}
+		p.Width = int32(binary.LittleEndian.Uint32(body[4:]))
+		p.Height = int32(binary.LittleEndian.Uint32(body[8:]))
+		p.Refresh = int32(binary.LittleEndian.Uint32(body[12:]))
+	case 3: // scale
+		p.Scale = int32(binary.LittleEndian.Uint32(body))
+	case 4: // name
+		name, _ := parseStr(body)
+		p.Name = string(name)
+	case 5: // description
+		desc, _ := parseStr(body)
+		p.Description = string(desc)
+	default:
+		globalsMu.Unlock()
+		return nil
+	}
+	globalsMu.Unlock()
+	objMu.Lock()
+	ver := objVersions[id]
+	objMu.Unlock()
+	if ver < 2 {
+		return applyOutput(conn, id)
+	}
+	return nil
+}
+
+// applyOutput applies the properties staged for output id.
+func applyOutput(conn *net.UnixConn, id uint32) Event {
+	globalsMu.Lock()
+	p := pendingOutputs[id]
+	if p == nil {
+		globalsMu.Unlock()
+		return nil
+	}
+	delete(pendingOutputs, id)
+	outputs[id] = *p
+	outputScales[id] = p.Scale
+	if p.Name != "" {
+		outputNames[id] = p.Name
+	}
+	onSurface := surfaceOutputs[id]
+	globalsMu.Unlock()
+	var ev Event
+	if onSurface {
+		ev = updateScale()
+	}
+	if onOutputChange != nil {
+		onOutputChange(conn, id, *p)
+	}
+	return ev
+}
+
+// outputByName returns the bound wl_output named name.
+func outputByName(name string) (uint32, error) {
+	globalsMu.Lock()
+	defer globalsMu.Unlock()
+	for id, n := range outputNames {
+		if n == name {
+			return id, nil
+		}
+	}
+	return 0, errors.New("wl_output: no output named " + name + ", it may have been unplugged")
+}
+
+// setFullscreen makes the window fullscreen on the output named outputName,
+// or on the one the compositor picks if outputName is "".
+func setFullscreen(conn *net.UnixConn, outputName string) error {
+	var output uint32
+	if outputName != "" {
+		var err error
+		output, err = outputByName(outputName)
+		if err != nil {
+			return err
+		}
+	}
+	buf := makeMsgBuf(XDGTopLevelID, 11, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, output) // 0 is null
+	return write(conn, buf)
+}
+
+func handleWLSurfaceEvent(conn *net.UnixConn, opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // enter
+		globalsMu.Lock()
+		surfaceOutputs[binary.LittleEndian.Uint32(body)] = true
+		globalsMu.Unlock()
+		return updateScale()
+	case 1: // leave
+		globalsMu.Lock()
+		delete(surfaceOutputs, binary.LittleEndian.Uint32(body))
+		globalsMu.Unlock()
+		return updateScale()
+	case 2: // preferred_buffer_scale
+		return preferScale(int32(binary.LittleEndian.Uint32(body)))
+	case 3: // preferred_buffer_transform
+		transform := binary.LittleEndian.Uint32(body)
+		configureMu.Lock()
+		preferredTransform = transform
+		configureMu.Unlock()
+		return WLSurfacePreferredTransform{Transform: transform}
+	}
+	return nil
+}
+
+// preferredTransform is the buffer transform the compositor suggests for
+// WLSurfaceID since wl_surface v6, the transform of the output it's mostly
+// on. Rendering rotated content with it lets the compositor skip rotating.
+// Guarded by configureMu.
+var preferredTransform uint32 = WLOutputTransformNormal
+
+// preferredScale is the scale the surface should be rendered at as of the
+// events handled so far, which mustSetScale applies. Guarded by
+// configureMu.
+var preferredScale int32 = 1
+
+// preferScale records scale as the one to render at, returning a
+// WLSurfaceScale event if it changed.
+func preferScale(scale int32) Event {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	if scale < 1 || scale == preferredScale {
+		return nil
+	}
+	preferredScale = scale
+	return WLSurfaceScale{Scale: scale}
+}
+
+// updateScale prefers the highest scale of the outputs the surface is on.
+// Since wl_surface v6 the compositor sends the scale to use instead, which
+// wins over guessing it from the outputs.
+func updateScale() Event {
+	if surfaceVersion() >= 6 {
+		return nil
+	}
+	var scale int32 = 1
+	globalsMu.Lock()
+	for output := range surfaceOutputs {
+		scale = max(scale, outputScales[output])
+	}
+	globalsMu.Unlock()
+	return preferScale(scale)
+}
+
+// mustSetScale renders the surface at scale, reallocating the buffer if it
+// changed, and returns a WLSurfaceScale event then. Surfaces older than
+// set_buffer_scale stay at 1 and leave scaling to the compositor.
+func mustSetScale(conn *net.UnixConn, scale int32) Event {
+	if scale < 1 || scale == pendingSurface.scale || surfaceVersion() < 3 {
+		return nil
+	}
+	pendingSurface.scale = scale
+	mustResizeBuffer(conn, windowWidth*uint32(scale), windowHeight*uint32(scale))
+	return WLSurfaceScale{Scale: scale}
+}
+
+// poolStrategyType decides what mustResizeBuffer does with the pool.
+type poolStrategyType uint8
+
+const (
+	// poolExact replaces the pool with one of the new buffer's size.
+	poolExact poolStrategyType = iota
+	// poolHighWater keeps the pool during an interactive resize, growing it
+	// when needed but never shrinking it, so a drag-resize doesn't map a new
+	// pool every frame. The first resize after the gesture shrinks it back
+	// to the buffer's size.
+	poolHighWater
+)
+
+var poolStrategy = poolExact
+
+// windowArena holds the window's buffers while poolHighWater keeps one pool
+// across an interactive resize, nil otherwise. windowArenaBuf is the one
+// WLBufferID is.
+var (
+	windowArena    *sharedArena
+	windowArenaBuf arenaBuffer
+)
+
+// mustResizeBuffer replaces the buffer with one of the given size, in a new
+// pool or, per poolStrategy, the current one. The new buffer still has to be
+// attached. The old one is only destroyed, and its memory reused, once the
+// compositor released it, see retireBuffer.
+func mustResizeBuffer(conn *net.UnixConn, w, h uint32) {
+	retireBuffer(conn)
+	// Whatever the compositor holds is retired, the new buffer is free.
+	markBufferReleased()
+	bufWidth, bufHeight = w, h
+	if poolStrategy == poolHighWater && interactiveResize() {
+		if windowArena == nil {
+			var err error
+			// Room for this buffer and the next while this one is held.
+			windowArena, err = newSharedArena(conn, 2*int64(w)*int64(h)*4)
+			if err != nil {
+				panic(err)
+			}
+		}
+		b, err := windowArena.alloc(conn, int32(w), int32(h), bufFormat)
+		if err != nil {
+			panic(err)
+		}
+		windowArenaBuf = b
+		WLBufferID = b.id
+		WLShmPoolBuf = windowArena.pixels(b)[:int64(b.stride)*int64(b.height)]
+		return
+	}
+	mustCreatePool(conn)
+	mustCreateBuffer(conn, bufFormat)
+}
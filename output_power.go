@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// zwlr_output_power_v1::mode
+const (
+	ZWLROutputPowerModeOff = 0
+	ZWLROutputPowerModeOn  = 1
+)
+
+// outputPowerMu guards zwlrOutputPowers and zwlrOutputPowerModes, which the
+// dispatcher goroutine updates.
+var outputPowerMu sync.Mutex
+
+// zwlrOutputPowers maps a wl_output id to its zwlr_output_power_v1 id.
+var zwlrOutputPowers = map[uint32]uint32{}
+
+// zwlrOutputPowerModes holds the last mode reported for a wl_output id.
+var zwlrOutputPowerModes = map[uint32]uint32{}
+
+func mustGetOutputPower(conn *net.UnixConn, output uint32) (id uint32) {
+	outputPowerMu.Lock()
+	defer outputPowerMu.Unlock()
+	if id, ok := zwlrOutputPowers[output]; ok {
+		return id
+	}
+	buf := makeMsgBuf(ZWLROutputPowerManagerID, 0, WORD_SIZE*2)
+	id = regChildObj(objZWLROutputPower, ZWLROutputPowerManagerID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, output)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	zwlrOutputPowers[output] = id
+	return id
+}
+
+// mustSetOutputPower turns the monitor behind output on or off (DPMS).
+func mustSetOutputPower(conn *net.UnixConn, output uint32, on bool) {
+	id := mustGetOutputPower(conn, output)
+	var mode uint32 = ZWLROutputPowerModeOff
+	if on {
+		mode = ZWLROutputPowerModeOn
+	}
+	buf := makeMsgBuf(id, 0, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, mode)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustDestroyOutputPower(conn *net.UnixConn, id uint32) {
+	buf := makeMsgBuf(id, 1, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleZWLROutputPowerEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	outputPowerMu.Lock()
+	defer outputPowerMu.Unlock()
+	var output uint32
+	for o, p := range zwlrOutputPowers {
+		if p == id {
+			output = o
+			break
+		}
+	}
+	switch opcode {
+	case 0: // mode
+		mode := binary.LittleEndian.Uint32(body)
+		zwlrOutputPowerModes[output] = mode
+		return ZWLROutputPowerMode{Output: output, Mode: mode}
+	case 1: // failed
+		// The output is gone or another client took control of it, the
+		// object is inert and only good for destroying.
+		delete(zwlrOutputPowers, output)
+		delete(zwlrOutputPowerModes, output)
+		mustDestroyOutputPower(conn, id)
+		return ZWLROutputPowerFailed{Output: output}
+	}
+	return nil
+}
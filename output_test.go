@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+)
+
+func TestHighWaterResizeKeepsHeldMemory(t *testing.T) {
+	type created struct{ id, off, size uint32 }
+	var (
+		mu       sync.Mutex
+		buffers  = map[uint32]created{}
+		poolGone bool
+	)
+	conn, m := newMockSurface(t, func(id, opcode uint32, body []byte) {
+		if objTypeOf(id) != objWLShmPool {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch opcode {
+		case 0: // create_buffer
+			b := created{
+				id:   binary.LittleEndian.Uint32(body),
+				off:  binary.LittleEndian.Uint32(body[4:]),
+				size: binary.LittleEndian.Uint32(body[12:]) * binary.LittleEndian.Uint32(body[16:]),
+			}
+			buffers[b.id] = b
+		case 1: // destroy
+			poolGone = true
+		}
+	})
+	m.holdBuffers.Store(true)
+	poolStrategy, toplevelResizing = poolHighWater, true
+	defer func() { poolStrategy, toplevelResizing = poolExact, false }()
+
+	mustDraw(conn)
+	prev := uint32(0)
+	for i := range 4 {
+		mustResizeBuffer(conn, winWidth+uint32(i)*10, winHeight+uint32(i)*10)
+		mustDraw(conn)
+		mustRoundtrip(t, conn)
+		mu.Lock()
+		cur, held := buffers[WLBufferID], buffers[prev]
+		mu.Unlock()
+		if prev != 0 && cur.off < held.off+held.size && held.off < cur.off+cur.size {
+			t.Fatalf("resize %d: buffer at %d+%d overlaps the held one at %d+%d", i, cur.off, cur.size, held.off, held.size)
+		}
+		prev = WLBufferID
+	}
+	if windowArena == nil {
+		t.Fatal("no arena during the resize")
+	}
+	mu.Lock()
+	poolGone = false
+	mu.Unlock()
+	toplevelResizing = false
+	mustResizeBuffer(conn, winWidth, winHeight)
+	mustRoundtrip(t, conn)
+	if windowArena != nil {
+		t.Error("arena kept after the resize ended")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !poolGone {
+		t.Error("arena pool not destroyed after the resize ended")
+	}
+}
+
+func TestResizePoolCapsSize(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	mem, err := resizePool(conn, WLShmPoolID, WLShmPoolFile, WLShmPoolBuf, 1<<31)
+	if err == nil {
+		t.Fatal("pool resized past an int32")
+	}
+	if len(mem) != len(WLShmPoolBuf) {
+		t.Error("mapping changed on a failed resize")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// xdgRole is what an xdg_surface is used as. Like surface roles it's given at
+// most once, an xdg_surface is either a toplevel or a popup for its lifetime.
+type xdgRole uint8
+
+const (
+	xdgRoleNone xdgRole = iota
+	xdgRoleToplevel
+	xdgRolePopup
+)
+
+var xdgRoleNames = [...]string{
+	xdgRoleNone:     "none",
+	xdgRoleToplevel: "xdg_toplevel",
+	xdgRolePopup:    "xdg_popup",
+}
+
+func (r xdgRole) String() string {
+	return xdgRoleNames[r]
+}
+
+// xdgSurfaceRoles is the role of each xdg_surface in objects, guarded by
+// objMu.
+var xdgSurfaceRoles [objectsLen]xdgRole
+
+// assignXDGRole gives xdgSurface role, or returns the error the compositor
+// would otherwise send as the already_constructed protocol error.
+func assignXDGRole(xdgSurface uint32, role xdgRole) error {
+	if xdgSurface >= objectsLen {
+		return errors.New("xdg_surface: no xdg_surface " + strconv.Itoa(int(xdgSurface)))
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	if cur := xdgSurfaceRoles[xdgSurface]; cur != xdgRoleNone {
+		return errors.New("xdg_surface: can't make it an " + role.String() + ", it's already an " + cur.String())
+	}
+	xdgSurfaceRoles[xdgSurface] = role
+	return nil
+}
+
+// xdgRoleOf returns the role of xdgSurface, xdgRoleNone if it has none yet.
+func xdgRoleOf(xdgSurface uint32) xdgRole {
+	if xdgSurface >= objectsLen {
+		return xdgRoleNone
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	return xdgSurfaceRoles[xdgSurface]
+}
+
+// getXDGSurface creates an xdg_surface for surface, which mustGetXDGSurface
+// does for WLSurfaceID.
+func getXDGSurface(conn *net.UnixConn, surface uint32) (id uint32, err error) {
+	err = assignRole(surface, roleXDGSurface)
+	if err != nil {
+		return 0, err
+	}
+	buf := makeMsgBuf(XDGWMBaseID, 2, WORD_SIZE*2)
+	id = regChildObj(objXDGSurface, XDGWMBaseID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, surface)
+	return id, write(conn, buf)
+}
+
+// createPositioner creates an xdg_positioner placing a width x height popup
+// relative to the anchor rectangle at x, y in the parent's window geometry,
+// centered on it as anchor and gravity are left unset.
+func createPositioner(conn *net.UnixConn, width, height, x, y, anchorWidth, anchorHeight int32) (id uint32, err error) {
+	if width <= 0 || height <= 0 || anchorWidth <= 0 || anchorHeight <= 0 {
+		return 0, errors.New("xdg_positioner: the size and anchor rectangle must be positive")
+	}
+	buf := makeMsgBuf(XDGWMBaseID, 1, WORD_SIZE)
+	id = regChildObj(objXDGPositioner, XDGWMBaseID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = makeMsgBuf(id, 1, WORD_SIZE*2) // set_size
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(width))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(height))
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = makeMsgBuf(id, 2, WORD_SIZE*4) // set_anchor_rect
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(x))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(y))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(anchorWidth))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(anchorHeight))
+	return id, write(conn, buf)
+}
+
+// getPopup makes xdgSurface a popup of the xdg_surface parent, placed by
+// positioner. The positioner's state is copied, it can be destroyed after.
+func getPopup(conn *net.UnixConn, xdgSurface, parent, positioner uint32) (id uint32, err error) {
+	if parent != 0 && objTypeOf(parent) != objXDGSurface {
+		return 0, errors.New("xdg_surface: popup parent isn't an xdg_surface")
+	}
+	err = assignXDGRole(xdgSurface, xdgRolePopup)
+	if err != nil {
+		return 0, err
+	}
+	buf := makeMsgBuf(xdgSurface, 2, WORD_SIZE*3)
+	id = regChildObj(objXDGPopup, xdgSurface)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, parent) // 0 is null
+	buf = binary.LittleEndian.AppendUint32(buf, positioner)
+	return id, write(conn, buf)
+}
+
+func handleXDGPopupEvent(id, opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // configure
+		return XDGPopupConfigure{
+			Popup:  id,
+			X:      int32(binary.LittleEndian.Uint32(body)),
+			Y:      int32(binary.LittleEndian.Uint32(body[4:])),
+			Width:  int32(binary.LittleEndian.Uint32(body[8:])),
+			Height: int32(binary.LittleEndian.Uint32(body[12:])),
+		}
+	case 1: // popup_done
+		return XDGPopupDone{Popup: id}
+	}
+	return nil
+}
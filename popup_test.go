@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestGetPopup(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	XDGSurfaceID = regObj(objXDGSurface)
+	parent := regObj(objXDGSurface)
+	positioner := regObj(objXDGPositioner)
+
+	for _, bad := range []uint32{WLSurfaceID, objectsLen, serverIDMin} {
+		_, err := getPopup(conn, XDGSurfaceID, bad, positioner)
+		if err == nil {
+			t.Errorf("made a popup of %d", bad)
+		}
+	}
+	_, err := getPopup(conn, objectsLen, parent, positioner)
+	if err == nil {
+		t.Error("made a popup of an xdg_surface past the object table")
+	}
+	if got := xdgRoleOf(XDGSurfaceID); got != xdgRoleNone {
+		t.Fatalf("got role %v after failed getPopups, want %v", got, xdgRoleNone)
+	}
+
+	popup, err := getPopup(conn, XDGSurfaceID, parent, positioner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if objTypeOf(popup) != objXDGPopup || xdgRoleOf(XDGSurfaceID) != xdgRolePopup {
+		t.Error("getPopup didn't make a popup")
+	}
+	_, err = getPopup(conn, XDGSurfaceID, parent, positioner)
+	if err == nil {
+		t.Error("made a popup of an xdg_surface that's already one")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// presentation-time reports when each commit's content actually reached the
+// screen, timestamped by the display hardware where possible, for measuring
+// latency or scheduling frames against the real refresh cycle.
+
+// wp_presentation_feedback::kind
+const (
+	WPPresentationFeedbackKindVsync        = 0x1
+	WPPresentationFeedbackKindHwClock      = 0x2
+	WPPresentationFeedbackKindHwCompletion = 0x4
+	WPPresentationFeedbackKindZeroCopy     = 0x8
+)
+
+// presentationClock is the clock the compositor timestamps with, per
+// wp_presentation::clock_id, e.g. unix.CLOCK_MONOTONIC.
+var presentationClock uint32
+
+// lastFeedbackID is the wp_presentation_feedback of the last commit that
+// asked for one, to match against the events' Feedback.
+var lastFeedbackID uint32
+
+// requestFeedback stages a request for feedback on the next commit, a
+// WPPresentationFeedbackPresented or WPPresentationFeedbackDiscarded event
+// follows for it. Call it before every commit that needs one.
+func requestFeedback() error {
+	err := requireGlobal(WPPresentationID, "wp_presentation")
+	if err != nil {
+		return err
+	}
+	pendingSurface.feedback = true
+	return nil
+}
+
+// mustRequestFeedback sends wp_presentation::feedback for the commit about
+// to be sent. The feedback object is destroyed by the compositor once
+// presented or discarded.
+func mustRequestFeedback(conn *net.UnixConn) {
+	lastFeedbackID = regChildObj(objWPPresentationFeedback, WPPresentationID)
+	buf := makeMsgBuf(WPPresentationID, 1, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+	buf = binary.LittleEndian.AppendUint32(buf, lastFeedbackID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleWPPresentationEvent(opcode uint32, body []byte) {
+	if opcode == 0 { // clock_id
+		presentationClock = binary.LittleEndian.Uint32(body)
+	}
+}
+
+func handleWPPresentationFeedbackEvent(id, opcode uint32, body []byte) Event {
+	switch opcode {
+	case 1: // presented
+		secHi := uint64(binary.LittleEndian.Uint32(body))
+		secLo := uint64(binary.LittleEndian.Uint32(body[4:]))
+		nsec := uint64(binary.LittleEndian.Uint32(body[8:]))
+		seqHi := uint64(binary.LittleEndian.Uint32(body[16:]))
+		seqLo := uint64(binary.LittleEndian.Uint32(body[20:]))
+		return WPPresentationFeedbackPresented{
+			Feedback: id,
+			Time:     (secHi<<32|secLo)*1e9 + nsec,
+			Refresh:  binary.LittleEndian.Uint32(body[12:]),
+			Seq:      seqHi<<32 | seqLo,
+			Flags:    binary.LittleEndian.Uint32(body[24:]),
+		}
+	case 2: // discarded
+		return WPPresentationFeedbackDiscarded{Feedback: id}
+	}
+	// sync_output is of no use without tracking outputs by feedback.
+	return nil
+}
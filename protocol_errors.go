@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// objTypeInterfaces is the interface name of each objType.
+var objTypeInterfaces = [...]string{
+	objNone:                               "none",
+	objWLDisplay:                          "wl_display",
+	objWLRegistry:                         "wl_registry",
+	objWLCallback:                         "wl_callback",
+	objWLCompositor:                       "wl_compositor",
+	objWLShm:                              "wl_shm",
+	objWLShmPool:                          "wl_shm_pool",
+	objWLOutput:                           "wl_output",
+	objWLSurface:                          "wl_surface",
+	objWLBuffer:                           "wl_buffer",
+	objXDGWMBase:                          "xdg_wm_base",
+	objXDGSurface:                         "xdg_surface",
+	objXDGTopLevel:                        "xdg_toplevel",
+	objZWLRLayerShell:                     "zwlr_layer_shell_v1",
+	objZWLROutputPowerManager:             "zwlr_output_power_manager_v1",
+	objZWLROutputPower:                    "zwlr_output_power_v1",
+	objZWLRGammaControlManager:            "zwlr_gamma_control_manager_v1",
+	objZWLRGammaControl:                   "zwlr_gamma_control_v1",
+	objWLSeat:                             "wl_seat",
+	objZWPVirtualKeyboardManager:          "zwp_virtual_keyboard_manager_v1",
+	objZWPVirtualKeyboard:                 "zwp_virtual_keyboard_v1",
+	objZWLRVirtualPointerManager:          "zwlr_virtual_pointer_manager_v1",
+	objZWLRVirtualPointer:                 "zwlr_virtual_pointer_v1",
+	objZWPKeyboardShortcutsInhibitManager: "zwp_keyboard_shortcuts_inhibit_manager_v1",
+	objZWPKeyboardShortcutsInhibitor:      "zwp_keyboard_shortcuts_inhibitor_v1",
+	objCustom:                             "custom",
+	objWLPointer:                          "wl_pointer",
+	objWLKeyboard:                         "wl_keyboard",
+	objWLFrameCallback:                    "wl_callback",
+	objXDGActivation:                      "xdg_activation_v1",
+	objXDGActivationToken:                 "xdg_activation_token_v1",
+	objWPColorManager:                     "wp_color_manager_v1",
+	objWPColorManagementSurface:           "wp_color_management_surface_v1",
+	objWPImageDescriptionCreatorParams:    "wp_image_description_creator_params_v1",
+	objWPImageDescriptionCreatorICC:       "wp_image_description_creator_icc_v1",
+	objWPImageDescription:                 "wp_image_description_v1",
+	objWPTearingControlManager:            "wp_tearing_control_manager_v1",
+	objWPTearingControl:                   "wp_tearing_control_v1",
+	objWPAlphaModifier:                    "wp_alpha_modifier_v1",
+	objWPAlphaModifierSurface:             "wp_alpha_modifier_surface_v1",
+	objWPFifoManager:                      "wp_fifo_manager_v1",
+	objWPFifo:                             "wp_fifo_v1",
+	objWPCommitTimingManager:              "wp_commit_timing_manager_v1",
+	objWPCommitTimer:                      "wp_commit_timer_v1",
+	objWLDataDeviceManager:                "wl_data_device_manager",
+	objWLDataDevice:                       "wl_data_device",
+	objWLTouch:                            "wl_touch",
+	objWLSubcompositor:                    "wl_subcompositor",
+	objWLSubsurface:                       "wl_subsurface",
+	objXDGPositioner:                      "xdg_positioner",
+	objXDGPopup:                           "xdg_popup",
+	objWLDataOffer:                        "wl_data_offer",
+	objXDGToplevelIconManager:             "xdg_toplevel_icon_manager_v1",
+	objXDGToplevelIcon:                    "xdg_toplevel_icon_v1",
+	objWPPresentation:                     "wp_presentation",
+	objWPPresentationFeedback:             "wp_presentation_feedback",
+	objWLRegion:                           "wl_region",
+	objZWPLinuxDmabuf:                     "zwp_linux_dmabuf_v1",
+	objZWPLinuxDmabufFeedback:             "zwp_linux_dmabuf_feedback_v1",
+}
+
+func (t objType) String() string {
+	if int(t) < len(objTypeInterfaces) {
+		return objTypeInterfaces[t]
+	}
+	return "objType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// requestNames names the requests of the interfaces most errors are about,
+// by opcode. Others are shown as their opcode.
+var requestNames = map[objType][]string{
+	objWLDisplay:    {"sync", "get_registry"},
+	objWLRegistry:   {"bind"},
+	objWLCompositor: {"create_surface", "create_region"},
+	objWLShm:        {"create_pool", "release"},
+	objWLShmPool:    {"create_buffer", "destroy", "resize"},
+	objWLBuffer:     {"destroy"},
+	objWLSurface: {"destroy", "attach", "damage", "frame", "set_opaque_region", "set_input_region",
+		"commit", "set_buffer_transform", "set_buffer_scale", "damage_buffer", "offset"},
+	objXDGWMBase:  {"destroy", "create_positioner", "get_xdg_surface", "pong"},
+	objXDGSurface: {"destroy", "get_toplevel", "get_popup", "set_window_geometry", "ack_configure"},
+	objXDGTopLevel: {"destroy", "set_parent", "set_title", "set_app_id", "show_window_menu", "move",
+		"resize", "set_max_size", "set_min_size", "set_maximized", "unset_maximized",
+		"set_fullscreen", "unset_fullscreen", "set_minimized"},
+	objXDGPositioner: {"destroy", "set_size", "set_anchor_rect", "set_anchor", "set_gravity",
+		"set_constraint_adjustment", "set_offset", "set_reactive", "set_parent_size", "set_parent_configure"},
+	objXDGPopup:               {"destroy", "grab", "reposition"},
+	objXDGToplevelIconManager: {"destroy", "create_icon", "set_icon"},
+	objXDGToplevelIcon:        {"destroy", "set_name", "add_buffer"},
+	objWLDataOffer:            {"accept", "receive", "destroy", "finish", "set_actions"},
+	objXDGActivationToken:     {"set_serial", "set_app_id", "set_surface", "commit", "destroy"},
+	objWPPresentation:         {"destroy", "feedback"},
+	objWLRegion:               {"destroy", "add", "subtract"},
+}
+
+func requestName(t objType, opcode uint16) string {
+	if names := requestNames[t]; int(opcode) < len(names) {
+		return names[opcode]
+	}
+	return "request " + strconv.Itoa(int(opcode))
+}
+
+// recentRequestsLen is how many of the latest requests to each object are
+// kept to explain protocol errors.
+const recentRequestsLen = 4
+
+type requestRing struct {
+	opcodes [recentRequestsLen]uint16
+	n       int
+}
+
+// recentRequests are the latest requests sent to each object, guarded by
+// outMu.
+var recentRequests [objectsLen]requestRing
+
+// recordRequest notes the request in msg, outMu must be held.
+func recordRequest(msg []byte) {
+	if len(msg) < HEADER_SIZE {
+		return
+	}
+	id := binary.LittleEndian.Uint32(msg)
+	if id >= objectsLen {
+		return
+	}
+	r := &recentRequests[id]
+	r.opcodes[r.n%recentRequestsLen] = binary.LittleEndian.Uint16(msg[4:])
+	r.n++
+}
+
+// forgetRequests drops the requests recorded for id, once it's freed.
+func forgetRequests(id uint32) {
+	if id >= objectsLen {
+		return
+	}
+	outMu.Lock()
+	recentRequests[id] = requestRing{}
+	outMu.Unlock()
+}
+
+// lastRequests returns the names of the latest requests sent to id of type
+// t, oldest first.
+func lastRequests(id uint32, t objType) []string {
+	if id >= objectsLen {
+		return nil
+	}
+	outMu.Lock()
+	r := recentRequests[id]
+	outMu.Unlock()
+	var names []string
+	for i := max(0, r.n-recentRequestsLen); i < r.n; i++ {
+		names = append(names, requestName(t, r.opcodes[i%recentRequestsLen]))
+	}
+	return names
+}
+
+// describeObj returns id as interface@id, with the requests last sent to
+// it, like "wl_surface@7 after attach, commit".
+func describeObj(id uint32) string {
+	t := objTypeOf(id)
+	if id >= objectsLen && t == objNone {
+		return "object@" + strconv.FormatUint(uint64(id), 10)
+	}
+	s := t.String() + "@" + strconv.FormatUint(uint64(id), 10)
+	if reqs := lastRequests(id, t); len(reqs) > 0 {
+		s += " after " + strings.Join(reqs, ", ")
+	}
+	return s
+}
+
+// objTypeOf returns the type of the client or server object id, objNone if
+// it isn't live.
+func objTypeOf(id uint32) objType {
+	objMu.Lock()
+	defer objMu.Unlock()
+	if id < objectsLen {
+		return objects[id]
+	}
+	return serverObjects[id]
+}
+
+// protocolErrors are the errors of some interfaces by code, a wlDisplayErr
+// about one unwraps to it.
+var protocolErrors = map[objType]map[uint32]error{
+	objXDGToplevelIcon: {
+		1: errIconInvalidBuffer,
+		2: errIconImmutable,
+		3: errIconNoBuffer,
+	},
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestProtocolErrorEnrichment(t *testing.T) {
+	// wlError is a canned wl_display.error about object id.
+	wlError := func(id, code uint32, msg string) []byte {
+		return appendStr(words(id, code), msg)
+	}
+	for _, tt := range []struct {
+		name string
+		// send sends requests and returns the object the error is about.
+		send  func(conn *net.UnixConn) uint32
+		code  uint32
+		msg   string
+		want  string
+		known error
+	}{
+		{"after commit", func(conn *net.UnixConn) uint32 {
+			WLSurfaceID = regObj(objWLSurface)
+			mustCommit(conn)
+			return WLSurfaceID
+		}, 2, "buffer size mismatch", "wl_display::error on wl_surface@2 after commit, code 2: buffer size mismatch", nil},
+		{"latest requests", func(conn *net.UnixConn) uint32 {
+			WLSurfaceID = regObjVersion(objWLSurface, 4)
+			WLBufferID = regObj(objWLBuffer)
+			for range 2 {
+				mustAttach(conn)
+				addDamage(0, 0, 1, 1)
+				mustCommit(conn)
+			}
+			return WLSurfaceID
+		}, 2, "bad", "wl_display::error on wl_surface@2 after commit, attach, damage_buffer, commit, code 2: bad", nil},
+		{"no requests", func(conn *net.UnixConn) uint32 {
+			return regObj(objWLRegion)
+		}, 0, "bad", "wl_display::error on wl_region@2, code 0: bad", nil},
+		{"known error", func(conn *net.UnixConn) uint32 {
+			id := regObj(objXDGToplevelIcon)
+			if err := write(conn, makeMsgBuf(id, 1, 0)); err != nil {
+				panic(err)
+			}
+			return id
+		}, 2, "immutable", "wl_display::error on xdg_toplevel_icon_v1@2 after set_name, code 2: immutable", errIconImmutable},
+		{"unknown object", func(conn *net.UnixConn) uint32 {
+			return 0xff000001
+		}, 1, "gone", "wl_display::error on object@4278190081, code 1: gone", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			conn, _ := newConnPair(t)
+			id := tt.send(conn)
+			_, err := dispatchEvent(context.Background(), conn, WLDisplayID, 0, wlError(id, tt.code, tt.msg))
+			var wlErr wlDisplayErr
+			if !errors.As(err, &wlErr) || err.Error() != tt.want {
+				t.Fatalf("got %v, want %s", err, tt.want)
+			}
+			if known := errors.Unwrap(err); known != tt.known {
+				t.Errorf("got %v wrapped, want %v", known, tt.known)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// A compositor pings through xdg_wm_base to tell whether the client is
+// still responsive, and may flag it as hung (offering to kill it) if the pong
+// doesn't come back within a few seconds. When the loop handling events also
+// draws, one slow frame delays every pong queued behind it, so the reader
+// started by startReader answers pings itself the moment they're read.
+
+type readMsg struct {
+	id, opcode uint32
+	body       []byte
+	err        error
+}
+
+var (
+	readMu   sync.Mutex
+	readCond = sync.NewCond(&readMu)
+	readQ    []readMsg
+	// readerPongs is set once startReader runs, handleEvent then leaves
+	// pings to it.
+	readerPongs atomic.Bool
+	// readerDone is closed when the reader stops, nil if none was started
+	// since the last disconnect. Guarded by readMu.
+	readerDone chan struct{}
+	// readerConn is the connection the reader reads. Guarded by readMu.
+	readerConn *net.UnixConn
+)
+
+// startReader reads events on a new goroutine, answering pings right away and
+// queuing everything for nextMsg. The queue isn't bounded, a stalled consumer
+// only costs memory. The reader stops after the first read error, which
+// nextMsg returns once the queue before it is drained. There's one reader per
+// connection, calling it again does nothing.
+func startReader(conn *net.UnixConn) {
+	readMu.Lock()
+	defer readMu.Unlock()
+	if readerDone != nil {
+		return
+	}
+	readerPongs.Store(true)
+	readerDone, readerConn = make(chan struct{}), conn
+	done := readerDone
+	go func() {
+		defer close(done)
+		for {
+			id, opcode, body, err := read(conn)
+			if errors.Is(err, net.ErrClosed) {
+				// disconnect closed it.
+				err = errDisconnected
+			}
+			if err == nil && id == XDGWMBaseID && opcode == 0 {
+				// A failed write means conn is broken or being closed by
+				// disconnect, the next read says which.
+				pong(conn, binary.LittleEndian.Uint32(body))
+			}
+			readMu.Lock()
+			readQ = append(readQ, readMsg{id: id, opcode: opcode, body: body, err: err})
+			readMu.Unlock()
+			readCond.Signal()
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// interruptReader queues err for nextMsg after the messages read so far,
+// making the loop reading them stop without conn being closed under it.
+func interruptReader(err error) {
+	readMu.Lock()
+	readQ = append(readQ, readMsg{err: err})
+	readMu.Unlock()
+	readCond.Signal()
+}
+
+// nextMsg returns the next message queued by startReader, waiting for one if
+// needed.
+func nextMsg() (id, opcode uint32, body []byte, err error) {
+	readMu.Lock()
+	defer readMu.Unlock()
+	for len(readQ) == 0 {
+		readCond.Wait()
+	}
+	m := readQ[0]
+	readQ[0] = readMsg{}
+	readQ = readQ[1:]
+	return m.id, m.opcode, m.body, m.err
+}
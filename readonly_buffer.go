@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readOnlySeals keep a memfd's size and seals fixed, so the compositor can't
+// be made to fault on a pool shrunk under it.
+const readOnlySeals = unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_SEAL
+
+// readOnlyWriteSeal also seals the contents against writes. libwayland maps
+// every pool PROT_READ|PROT_WRITE, which fails for a write-sealed memfd and
+// ends in a fatal invalid_fd, so it's only for compositors known to map
+// pools read-only.
+var readOnlyWriteSeal = false
+
+// createReadOnlyBuffer uploads pixels, laid out as b at offset 0, once into
+// a sealed memfd and returns a buffer of them that any number of surfaces can
+// attach, for content that never changes like icons and backgrounds. Nothing
+// is mapped client side so the buffer never has to be waited on, the
+// compositor could still write to it unless readOnlyWriteSeal is set.
+func createReadOnlyBuffer(conn *net.UnixConn, pixels []byte, b shmBuffer) (id uint32, err error) {
+	b.offset = 0
+	size := int64(len(pixels))
+	err = b.validate(len(pixels))
+	if err != nil {
+		return 0, err
+	}
+	fd, err := unix.MemfdCreate("wl_shm_readonly", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return 0, errors.New("wl_shm: creating a read-only pool: " + err.Error())
+	}
+	f := os.NewFile(uintptr(fd), "wl_shm_readonly")
+	defer f.Close()
+	_, err = f.Write(pixels)
+	if err != nil {
+		return 0, err
+	}
+	seals := readOnlySeals
+	if readOnlyWriteSeal {
+		seals |= unix.F_SEAL_WRITE
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, seals)
+	if err != nil {
+		return 0, errors.New("wl_shm: sealing a read-only pool: " + err.Error())
+	}
+	buf := makeMsgBuf(WLShmID, 0, WORD_SIZE*2)
+	pool := regChildObj(objWLShmPool, WLShmID)
+	buf = binary.LittleEndian.AppendUint32(buf, pool)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	err = writeFD(conn, buf, int(f.Fd()))
+	if err != nil {
+		return 0, err
+	}
+	id, err = createBuffer(conn, pool, len(pixels), b)
+	// The buffer keeps the memory alive, the pool isn't needed anymore.
+	destroyErr := write(conn, makeMsgBuf(pool, 1, 0))
+	return id, errors.Join(err, destroyErr)
+}
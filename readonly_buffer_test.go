@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// readOnlyPoolFD creates a 2×2 read-only buffer of pixels and returns the fd
+// the mock compositor got for its pool.
+func readOnlyPoolFD(t *testing.T, pixels []byte) int {
+	t.Helper()
+	conn, m := newMockSurface(t, nil)
+	err := roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range m.takeFDs() {
+		unix.Close(fd)
+	}
+	_, err = createReadOnlyBuffer(conn, pixels, shmBuffer{width: 2, height: 2, stride: 8, format: WLShmFormatXRGB8888})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds := m.takeFDs()
+	if len(fds) != 1 {
+		t.Fatalf("compositor got %d fds, want 1", len(fds))
+	}
+	t.Cleanup(func() { unix.Close(fds[0]) })
+	return fds[0]
+}
+
+func TestReadOnlyBufferMapsLikeLibwayland(t *testing.T) {
+	pixels := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+	fd := readOnlyPoolFD(t, pixels)
+	seals, err := unix.FcntlInt(uintptr(fd), unix.F_GET_SEALS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readOnlySeals; seals != want {
+		t.Errorf("got seals %#x, want %#x", seals, want)
+	}
+	// libwayland's wl_shm maps pools shared and writable.
+	mem, err := unix.Mmap(fd, 0, len(pixels), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+	if !bytes.Equal(mem, pixels) {
+		t.Errorf("pool holds %v, want %v", mem, pixels)
+	}
+}
+
+func TestReadOnlyBufferWriteSeal(t *testing.T) {
+	readOnlyWriteSeal = true
+	defer func() { readOnlyWriteSeal = false }()
+	pixels := bytes.Repeat([]byte{1, 2, 3, 4}, 4)
+	fd := readOnlyPoolFD(t, pixels)
+	seals, err := unix.FcntlInt(uintptr(fd), unix.F_GET_SEALS, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seals&unix.F_SEAL_WRITE == 0 {
+		t.Errorf("got seals %#x, want F_SEAL_WRITE", seals)
+	}
+	_, err = unix.Mmap(fd, 0, len(pixels), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if !errors.Is(err, unix.EPERM) {
+		t.Errorf("writable mapping of a write-sealed pool got %v, want EPERM", err)
+	}
+	mem, err := unix.Mmap(fd, 0, len(pixels), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(mem)
+	if !bytes.Equal(mem, pixels) {
+		t.Errorf("pool holds %v, want %v", mem, pixels)
+	}
+}
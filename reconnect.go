@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// backoff spaces out reconnection attempts: the nth delay is base*2^n capped
+// at max, minus up to jitter of it at random so clients of a restarting
+// compositor don't all reconnect at once.
+type backoff struct {
+	base, max time.Duration
+	jitter    float64 // 0 to 1
+
+	attempt int
+	// rand returns a number in [0, 1), rand.Float64 if nil.
+	rand func() float64
+}
+
+// reconnectBackoff is used by reconnect between failed attempts.
+var reconnectBackoff = backoff{
+	base:   100 * time.Millisecond,
+	max:    10 * time.Second,
+	jitter: 0.5,
+}
+
+// next returns the delay before the next attempt.
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 63 && b.base<<b.attempt > 0 && b.base<<b.attempt < b.max {
+		d = b.base << b.attempt
+	}
+	b.attempt++
+	r := rand.Float64
+	if b.rand != nil {
+		r = b.rand
+	}
+	return d - time.Duration(float64(d)*b.jitter*r())
+}
+
+// reset starts the delays over from base.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// sleep waits d on clk or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := clk.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reconnect connects to the compositor like connect, retrying with
+// reconnectBackoff until it succeeds or ctx is done. The new connection has
+// no objects so the object table is cleared, the caller has to get the
+// registry and recreate its state.
+func reconnect(ctx context.Context) (*net.UnixConn, error) {
+	for {
+		conn, err := connect()
+		if err == nil {
+			reconnectBackoff.reset()
+			resetObjects()
+			return conn, nil
+		}
+		err = sleep(ctx, reconnectBackoff.next())
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// resetObjects forgets every object but the display, for a new connection.
+func resetObjects() {
+	objMu.Lock()
+	objects = [objectsLen]objType{objNone, objWLDisplay}
+	objVersions = [objectsLen]uint32{0, 1}
+	surfaceRoles = [objectsLen]surfaceRole{}
+	xdgSurfaceRoles = [objectsLen]xdgRole{}
+	objHandlers = [objectsLen]eventHandler{}
+	callbackHandlers = [objectsLen]func(uint32) Event{}
+	clear(serverObjects)
+	objMu.Unlock()
+	configureMu.Lock()
+	pendingConfigure, toplevelResizing = 0, false
+	pendingWindowWidth, pendingWindowHeight = winWidth, winHeight
+	preferredScale, preferredTransform = 1, WLOutputTransformNormal
+	configureMu.Unlock()
+	focusMu.Lock()
+	keyboardFocus, pointerFocus = 0, 0
+	focusMu.Unlock()
+	keyRepeatMu.Lock()
+	stopKeyRepeatLocked()
+	keyRepeatRate, keyRepeatDelay = defaultKeyRepeatRate, defaultKeyRepeatDelay
+	keyRepeatMu.Unlock()
+	pointerSerialsMu.Lock()
+	clear(pointerSerialsBySeat)
+	pointerSerialsMu.Unlock()
+	dataOffersMu.Lock()
+	clear(dataOffers)
+	dndOffer, selectionOffer = 0, 0
+	dataOffersMu.Unlock()
+	colorMu.Lock()
+	clear(colorIntents)
+	clear(colorFeatures)
+	clear(colorTFs)
+	clear(colorPrimaries)
+	colorAdvertised = false
+	clear(imageDescriptions)
+	pendingColorProfile.desc, pendingColorProfile.intent = 0, 0
+	colorMu.Unlock()
+	outputPowerMu.Lock()
+	clear(zwlrOutputPowers)
+	clear(zwlrOutputPowerModes)
+	outputPowerMu.Unlock()
+	gammaMu.Lock()
+	clear(zwlrGammaControls)
+	gammaMu.Unlock()
+	shortcutsMu.Lock()
+	clear(shortcutsInhibitors)
+	shortcutsMu.Unlock()
+	outMu.Lock()
+	clear(recentRequests[:])
+	outMu.Unlock()
+	titleMu.Lock()
+	title, titleSentAt = "", time.Time{}
+	titleMu.Unlock()
+	compositorFormats, dmabufPendingFormats = nil, nil
+	if dmabufFormatTable != nil {
+		unix.Munmap(dmabufFormatTable)
+		dmabufFormatTable = nil
+	}
+	globalsMu.Lock()
+	clear(globals)
+	clear(boundGlobals)
+	clear(outputNames)
+	clear(outputScales)
+	clear(surfaceOutputs)
+	clear(outputs)
+	clear(pendingOutputs)
+	clear(seatNames)
+	clear(seatCaps)
+	globalsMu.Unlock()
+	WLOutputIDs, WLOutputID = nil, 0
+	WLSeatIDs, WLSeatID = nil, 0
+	// Requests still queued were for the old connection's objects.
+	outMu.Lock()
+	for _, fd := range outFDs {
+		unix.Close(fd)
+	}
+	outBuf, outFDs, outMsgs = outBuf[:0], outFDs[:0], 0
+	outMu.Unlock()
+	resetIDs()
+}
+
+// resetIDs zeroes the id of every object, which would name another object
+// or none on the new connection. wlFrameCallBuf is dropped too since it's
+// addressed to WLSurfaceID, and the buffer is no longer held by anyone.
+func resetIDs() {
+	WLRegistryID, WLCompositorID, WLShmID, WLShmPoolID, WLBufferID = 0, 0, 0, 0, 0
+	WLSurfaceID, WLPointerID, WLKeyboardID, WLTouchID = 0, 0, 0, 0
+	XDGWMBaseID, XDGSurfaceID, XDGTopLevelID, ZWLRLayerShellID = 0, 0, 0, 0
+	ZWLROutputPowerManagerID, ZWLRGammaControlManagerID = 0, 0
+	ZWPVirtualKeyboardManagerID, ZWPVirtualKeyboardID = 0, 0
+	ZWLRVirtualPointerManagerID, ZWLRVirtualPointerID = 0, 0
+	ZWPKeyboardShortcutsInhibitManagerID, XDGActivationID = 0, 0
+	WPColorManagerID, WPColorManagementSurfaceID = 0, 0
+	WPTearingControlManagerID, WPTearingControlID = 0, 0
+	WPAlphaModifierID, WPAlphaModifierSurfaceID = 0, 0
+	WPFifoManagerID, WPFifoID, WPCommitTimingManagerID, WPCommitTimerID = 0, 0, 0, 0
+	WLSubcompositorID, WLDataDeviceManagerID, WLDataDeviceID = 0, 0, 0
+	XDGToplevelIconManagerID, WPPresentationID, ZWPLinuxDmabufID = 0, 0, 0
+	lastFeedbackID = 0
+	frameMu.Lock()
+	WLFrameCallbackID, drawPending = 0, false
+	frameMu.Unlock()
+	wlFrameCallBuf = nil
+	bufferAttached = false
+	markBufferReleased()
+	bufferMu.Lock()
+	clear(retiredBuffers)
+	bufferMu.Unlock()
+	if windowArena != nil {
+		unix.Munmap(windowArena.mem)
+		windowArena.f.Close()
+		windowArena = nil
+	}
+}
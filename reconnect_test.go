@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResetObjectsForgetsOutputsAndSeats(t *testing.T) {
+	resetTestState(t)
+	conn, _ := startMockCompositor(t, nil)
+	WLRegistryID = regObj(objWLRegistry)
+	announceGlobal(t, conn, 10, "wl_output", 4)
+	announceGlobal(t, conn, 20, "wl_seat", 9)
+	outputNames[WLOutputID] = "DP-1"
+	outputScales[WLOutputID] = 2
+	surfaceOutputs[WLOutputID] = true
+	outputs[WLOutputID] = outputInfo{Name: "DP-1"}
+	pendingOutputs[WLOutputID] = &outputInfo{}
+	seatNames[WLSeatID] = "seat0"
+	seatCaps[WLSeatID] = 3
+
+	resetObjects()
+	for name, n := range map[string]int{
+		"outputNames":    len(outputNames),
+		"outputScales":   len(outputScales),
+		"surfaceOutputs": len(surfaceOutputs),
+		"outputs":        len(outputs),
+		"pendingOutputs": len(pendingOutputs),
+		"seatNames":      len(seatNames),
+		"seatCaps":       len(seatCaps),
+		"WLOutputIDs":    len(WLOutputIDs),
+		"WLSeatIDs":      len(WLSeatIDs),
+	} {
+		if n != 0 {
+			t.Errorf("%s has %d entries after resetObjects", name, n)
+		}
+	}
+	if WLOutputID != 0 || WLSeatID != 0 {
+		t.Errorf("WLOutputID %d and WLSeatID %d after resetObjects", WLOutputID, WLSeatID)
+	}
+}
+
+func TestResetObjectsZeroesIDs(t *testing.T) {
+	resetTestState(t)
+	ids := map[string]*uint32{
+		"WLRegistryID":      &WLRegistryID,
+		"WLCompositorID":    &WLCompositorID,
+		"WLSurfaceID":       &WLSurfaceID,
+		"WLBufferID":        &WLBufferID,
+		"XDGTopLevelID":     &XDGTopLevelID,
+		"WLKeyboardID":      &WLKeyboardID,
+		"WPPresentationID":  &WPPresentationID,
+		"ZWPLinuxDmabufID":  &ZWPLinuxDmabufID,
+		"WLFrameCallbackID": &WLFrameCallbackID,
+	}
+	for _, id := range ids {
+		*id = 5
+	}
+	wlFrameCallBuf = makeMsgBuf(5, 3, WORD_SIZE)
+
+	resetObjects()
+	for name, id := range ids {
+		if *id != 0 {
+			t.Errorf("%s is %d after resetObjects", name, *id)
+		}
+	}
+	if wlFrameCallBuf != nil {
+		t.Error("wlFrameCallBuf kept after resetObjects")
+	}
+}
+
+func TestResetObjectsForgetsProtocolState(t *testing.T) {
+	resetTestState(t)
+	dataOffers[serverIDMin] = &dataOffer{}
+	dndOffer, selectionOffer = serverIDMin, serverIDMin
+	colorIntents[0], colorFeatures[0], colorTFs[1], colorPrimaries[1] = true, true, true, true
+	colorAdvertised = true
+	imageDescriptions[5] = &imageDescription{ready: true}
+	pendingColorProfile.desc, pendingColorProfile.intent = 5, 1
+	zwlrOutputPowers[6], zwlrOutputPowerModes[6] = 7, 1
+	zwlrGammaControls[8] = &gammaControl{id: 9, size: 256}
+	shortcutsInhibitors[10] = &shortcutsInhibitor{id: 11}
+	pointerSerialsBySeat[12] = pointerSerials{enter: 1}
+
+	resetObjects()
+	for name, n := range map[string]int{
+		"dataOffers":           len(dataOffers),
+		"colorIntents":         len(colorIntents),
+		"colorFeatures":        len(colorFeatures),
+		"colorTFs":             len(colorTFs),
+		"colorPrimaries":       len(colorPrimaries),
+		"imageDescriptions":    len(imageDescriptions),
+		"zwlrOutputPowers":     len(zwlrOutputPowers),
+		"zwlrOutputPowerModes": len(zwlrOutputPowerModes),
+		"zwlrGammaControls":    len(zwlrGammaControls),
+		"shortcutsInhibitors":  len(shortcutsInhibitors),
+		"pointerSerialsBySeat": len(pointerSerialsBySeat),
+	} {
+		if n != 0 {
+			t.Errorf("%s has %d entries after resetObjects", name, n)
+		}
+	}
+	if dndOffer != 0 || selectionOffer != 0 {
+		t.Errorf("dndOffer %d and selectionOffer %d after resetObjects", dndOffer, selectionOffer)
+	}
+	if colorAdvertised || pendingColorProfile.desc != 0 {
+		t.Errorf("colorAdvertised %v and pendingColorProfile %+v after resetObjects", colorAdvertised, pendingColorProfile)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	const ms = time.Millisecond
+	for _, tc := range []struct {
+		name    string
+		b       backoff
+		attempt int
+		want    []time.Duration
+	}{
+		{
+			name: "doubling up to max",
+			b:    backoff{base: 100 * ms, max: time.Second},
+			want: []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, time.Second, time.Second},
+		},
+		{
+			name: "jitter",
+			b:    backoff{base: 100 * ms, max: time.Second, jitter: 0.5, rand: func() float64 { return 0.5 }},
+			want: []time.Duration{75 * ms, 150 * ms, 300 * ms, 600 * ms, 750 * ms},
+		},
+		{
+			name: "full jitter",
+			b:    backoff{base: 100 * ms, max: time.Second, jitter: 1, rand: func() float64 { return 0.9 }},
+			want: []time.Duration{10 * ms, 20 * ms},
+		},
+		{
+			name:    "shift overflowing",
+			b:       backoff{base: time.Second, max: time.Hour},
+			attempt: 40,
+			want:    []time.Duration{time.Hour, time.Hour},
+		},
+		{
+			name:    "past 63 attempts",
+			b:       backoff{base: 1, max: time.Minute},
+			attempt: 70,
+			want:    []time.Duration{time.Minute},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := tc.b
+			b.attempt = tc.attempt
+			for i, want := range tc.want {
+				if got := b.next(); got != want {
+					t.Errorf("delay %d is %v, want %v", i, got, want)
+				}
+			}
+			b.reset()
+			first := tc.b
+			if got, want := b.next(), first.next(); got != want {
+				t.Errorf("first delay after reset is %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// awaitTimer waits for a timer to be set on c and returns how long until it
+// fires.
+func awaitTimer(t *testing.T, c *fakeClock) time.Duration {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		if len(c.timers) > 0 {
+			d := c.timers[0].at.Sub(c.now)
+			c.mu.Unlock()
+			return d
+		}
+		c.mu.Unlock()
+	}
+	t.Fatal("no timer set")
+	return 0
+}
+
+// useReconnectBackoff sets reconnectBackoff to b and the compositor socket
+// to name, for the test.
+func useReconnectBackoff(t *testing.T, b backoff, name string) {
+	saved := reconnectBackoff
+	reconnectBackoff = b
+	t.Cleanup(func() { reconnectBackoff = saved })
+	t.Setenv("WAYLAND_SOCKET", "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("WAYLAND_DISPLAY", name)
+}
+
+func TestReconnect(t *testing.T) {
+	const ms = time.Millisecond
+	resetTestState(t)
+	fake := newFakeClock(time.Unix(0, 0))
+	clk = fake
+	name := "@golang-wayland-reconnect-" + strconv.Itoa(os.Getpid())
+	useReconnectBackoff(t, backoff{base: 100 * ms, max: 250 * ms, jitter: 0.5, rand: func() float64 { return 0.5 }}, name)
+	WLSurfaceID = regObj(objWLSurface)
+	surface := WLSurfaceID
+
+	type result struct {
+		conn *net.UnixConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := reconnect(context.Background())
+		done <- result{conn, err}
+	}()
+	for i, want := range []time.Duration{75 * ms, 150 * ms, 187500 * time.Microsecond} {
+		got := awaitTimer(t, fake)
+		if got != want {
+			t.Fatalf("delay %d is %v, want %v", i, got, want)
+		}
+		if i == 2 {
+			l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+		}
+		fake.advance(got)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	res.conn.Close()
+	if reconnectBackoff.attempt != 0 {
+		t.Errorf("backoff at attempt %d after connecting, want it reset", reconnectBackoff.attempt)
+	}
+	if WLSurfaceID != 0 || objTypeOf(surface) != objNone {
+		t.Errorf("the old connection's surface %d is still known after reconnecting", surface)
+	}
+}
+
+func TestReconnectCanceled(t *testing.T) {
+	resetTestState(t)
+	fake := newFakeClock(time.Unix(0, 0))
+	clk = fake
+	useReconnectBackoff(t, backoff{base: time.Second, max: time.Second}, "@golang-wayland-reconnect-none-"+strconv.Itoa(os.Getpid()))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := reconnect(ctx)
+		done <- err
+	}()
+	awaitTimer(t, fake)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
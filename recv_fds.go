@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Fds sent by the compositor arrive out of band and aren't tied to a
+// particular message, read queues them and decoders take them in order for
+// each fd argument of the event they decode, recording the object the fd
+// came for. Whoever takes an fd owns it and must release it with closeRecvFD,
+// this is the decoder itself unless the fd is handed over in an Event, in
+// which case it's documented on the event.
+
+var (
+	recvFDsMu sync.Mutex
+	recvFDs   []int
+	// recvFDOwners maps each taken and still open fd to its object.
+	recvFDOwners = map[int]uint32{}
+	recvFDWarned bool
+)
+
+// recvFDThreshold is the number of taken and still open fds above which
+// takeFD warns once about a possible leak, 0 disables the check. The default
+// process limit is usually 1024, it's far more than any client keeps open
+// legitimately.
+var recvFDThreshold = 64
+
+var errNoRecvFD = errors.New("event has an fd argument but no fd was received")
+
+// recvFDsOOB fits the 28 fds the compositor sends at most per message.
+var recvFDsOOB = make([]byte, unix.CmsgSpace(28*4))
+
+// readWithFDs fills b, queuing any fds received along with it. It returns the
+// number of bytes read before an error.
+func readWithFDs(conn *net.UnixConn, b []byte) (read int, err error) {
+	for read < len(b) {
+		n, oobn, _, _, err := conn.ReadMsgUnix(b[read:], recvFDsOOB)
+		if oobn > 0 {
+			queueRecvFDs(recvFDsOOB[:oobn])
+		}
+		read += n
+		if err != nil {
+			return read, err
+		}
+		if n == 0 {
+			return read, io.EOF
+		}
+	}
+	return read, nil
+}
+
+func queueRecvFDs(oob []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		fds, err := unix.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		counters.fdsIn.Add(uint64(len(fds)))
+		recvFDsMu.Lock()
+		recvFDs = append(recvFDs, fds...)
+		recvFDsMu.Unlock()
+	}
+}
+
+// takeFD returns the next received fd for an event of object id, or -1 if
+// none was received.
+func takeFD(id uint32) int {
+	recvFDsMu.Lock()
+	defer recvFDsMu.Unlock()
+	if len(recvFDs) == 0 {
+		slog.Error(errNoRecvFD.Error(), "id", id)
+		return -1
+	}
+	fd := recvFDs[0]
+	recvFDs = recvFDs[1:]
+	recvFDOwners[fd] = id
+	if recvFDThreshold > 0 {
+		over := len(recvFDOwners) >= recvFDThreshold
+		if over && !recvFDWarned {
+			perObj := map[uint32]int{}
+			for _, o := range recvFDOwners {
+				perObj[o]++
+			}
+			slog.Warn("received fds piling up, possible fd leak", "open", len(recvFDOwners), "last_object", id, "per_object", perObj)
+		}
+		recvFDWarned = over
+	}
+	return fd
+}
+
+// closeRecvFD closes an fd returned by takeFD.
+func closeRecvFD(fd int) error {
+	if fd < 0 {
+		return nil
+	}
+	recvFDsMu.Lock()
+	delete(recvFDOwners, fd)
+	recvFDsMu.Unlock()
+	return unix.Close(fd)
+}
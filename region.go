@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+)
+
+// createRegion creates a wl_region made of rects, in surface coordinates.
+// Surfaces copy a region when it's set, so it can be destroyed right after.
+func createRegion(conn *net.UnixConn, rects ...rect) (id uint32, err error) {
+	buf := makeMsgBuf(WLCompositorID, 1, WORD_SIZE)
+	id = regChildObj(objWLRegion, WLCompositorID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err = write(conn, buf)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rects {
+		buf := makeMsgBuf(id, 1, WORD_SIZE*4) // add
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.x))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.y))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.w))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(r.h))
+		err = write(conn, buf)
+		if err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+func destroyRegion(conn *net.UnixConn, id uint32) error {
+	return write(conn, makeMsgBuf(id, 0, 0))
+}
+
+// setOpaqueRegion stages r, in surface coordinates, as the part of the
+// surface whose content is opaque, so the compositor can skip drawing what's
+// behind it. An empty r unsets it. With autoOpaque it's derived instead.
+func setOpaqueRegion(r rect) {
+	pendingSurface.opaque = r
+}
+
+// autoOpaque makes every commit set the opaque region to the whole surface
+// when the buffer format has no alpha channel and no alpha multiplier is
+// set, and unset it otherwise, following resizes and scale changes. It
+// overrides setOpaqueRegion.
+var autoOpaque bool
+
+// setAutoOpaque turns autoOpaque on or off, effective from the next commit.
+func setAutoOpaque(on bool) {
+	autoOpaque = on
+	if !on {
+		pendingSurface.opaque = rect{}
+	}
+}
+
+// autoOpaqueRegion is the opaque region autoOpaque sets for s.
+func (s surfaceState) autoOpaqueRegion() rect {
+	if s.detach || bufFormat.hasAlpha() || s.alpha != math.MaxUint32 {
+		return rect{}
+	}
+	w, h := s.size()
+	return rect{0, 0, int32(w), int32(h)}
+}
+
+func mustSetOpaqueRegion(conn *net.UnixConn, r rect) {
+	var region uint32
+	if r.w > 0 && r.h > 0 {
+		var err error
+		region, err = createRegion(conn, r)
+		if err != nil {
+			panic(err)
+		}
+	}
+	buf := makeMsgBuf(WLSurfaceID, 4, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, region) // 0 is null
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	if region != 0 {
+		err = destroyRegion(conn, region)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
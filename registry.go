@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// globalHandler is called for each global of its interface announced by the
+// registry.
+type globalHandler func(conn *net.UnixConn, name, ver uint32, iface []byte)
+
+// eventHandler handles an event for the object id. body is only valid until
+// it returns, it's reused for later messages, see releaseBody.
+type eventHandler func(conn *net.UnixConn, id, opcode uint32, body []byte)
+
+// globalHandlers maps an interface name to its globalHandler, globals of
+// interfaces without one are ignored.
+var globalHandlers = map[string]globalHandler{
+	"wl_compositor":                             bindGlobal(&WLCompositorID, objWLCompositor),
+	"wl_shm":                                    bindGlobal(&WLShmID, objWLShm),
+	"wl_output":                                 bindEachGlobal(&WLOutputIDs, &WLOutputID, objWLOutput),
+	"wl_seat":                                   bindEachGlobal(&WLSeatIDs, &WLSeatID, objWLSeat),
+	"xdg_wm_base":                               bindGlobal(&XDGWMBaseID, objXDGWMBase),
+	"zwlr_layer_shell_v1":                       bindGlobal(&ZWLRLayerShellID, objZWLRLayerShell),
+	"zwlr_output_power_manager_v1":              bindGlobal(&ZWLROutputPowerManagerID, objZWLROutputPowerManager),
+	"zwlr_gamma_control_manager_v1":             bindGlobal(&ZWLRGammaControlManagerID, objZWLRGammaControlManager),
+	"zwp_virtual_keyboard_manager_v1":           bindGlobal(&ZWPVirtualKeyboardManagerID, objZWPVirtualKeyboardManager),
+	"zwlr_virtual_pointer_manager_v1":           bindGlobal(&ZWLRVirtualPointerManagerID, objZWLRVirtualPointerManager),
+	"zwp_keyboard_shortcuts_inhibit_manager_v1": bindGlobal(&ZWPKeyboardShortcutsInhibitManagerID, objZWPKeyboardShortcutsInhibitManager),
+	"xdg_activation_v1":                         bindGlobal(&XDGActivationID, objXDGActivation),
+	"wp_color_manager_v1":                       bindGlobal(&WPColorManagerID, objWPColorManager),
+	"wp_tearing_control_manager_v1":             bindGlobal(&WPTearingControlManagerID, objWPTearingControlManager),
+	"wp_alpha_modifier_v1":                      bindGlobal(&WPAlphaModifierID, objWPAlphaModifier),
+	"wp_fifo_manager_v1":                        bindGlobal(&WPFifoManagerID, objWPFifoManager),
+	"wp_commit_timing_manager_v1":               bindGlobal(&WPCommitTimingManagerID, objWPCommitTimingManager),
+	"wl_data_device_manager":                    bindGlobal(&WLDataDeviceManagerID, objWLDataDeviceManager),
+	"wl_subcompositor":                          bindGlobal(&WLSubcompositorID, objWLSubcompositor),
+	"xdg_toplevel_icon_manager_v1":              bindGlobal(&XDGToplevelIconManagerID, objXDGToplevelIconManager),
+	"wp_presentation":                           bindGlobal(&WPPresentationID, objWPPresentation),
+	"zwp_linux_dmabuf_v1":                       bindDmabuf,
+}
+
+// supportedVersions is the highest version of each global interface whose
+// requests and events this package handles, globals are never bound above it.
+// Adding requests or event decoders from a newer version means bumping the
+// entry here.
+var supportedVersions = map[string]uint32{
+	"wl_compositor":                             6, // wl_surface::preferred_buffer_scale
+	"wl_shm":                                    1,
+	"wl_output":                                 4, // wl_output::name
+	"wl_seat":                                   9, // wl_pointer::axis_relative_direction
+	"xdg_wm_base":                               1,
+	"zwlr_layer_shell_v1":                       1,
+	"zwlr_output_power_manager_v1":              1,
+	"zwlr_gamma_control_manager_v1":             1,
+	"zwp_virtual_keyboard_manager_v1":           1,
+	"zwlr_virtual_pointer_manager_v1":           1,
+	"zwp_keyboard_shortcuts_inhibit_manager_v1": 1,
+	"xdg_activation_v1":                         1,
+	"wp_color_manager_v1":                       1,
+	"wp_tearing_control_manager_v1":             1,
+	"wp_alpha_modifier_v1":                      1,
+	"wp_fifo_manager_v1":                        1,
+	"wp_commit_timing_manager_v1":               1,
+	"wl_data_device_manager":                    3, // wl_data_offer::set_actions
+	"wl_subcompositor":                          1,
+	"xdg_toplevel_icon_manager_v1":              1,
+	"wp_presentation":                           1,
+	"zwp_linux_dmabuf_v1":                       4, // get_default_feedback
+}
+
+// childInterfaces maps the interfaces of objects created through a global,
+// rather than bound, to that global's interface. Their version is the one
+// the global was bound at.
+var childInterfaces = map[string]string{
+	"wl_surface":                             "wl_compositor",
+	"wl_region":                              "wl_compositor",
+	"wl_shm_pool":                            "wl_shm",
+	"wl_buffer":                              "wl_shm",
+	"wl_pointer":                             "wl_seat",
+	"wl_keyboard":                            "wl_seat",
+	"wl_touch":                               "wl_seat",
+	"xdg_surface":                            "xdg_wm_base",
+	"xdg_toplevel":                           "xdg_wm_base",
+	"xdg_popup":                              "xdg_wm_base",
+	"xdg_positioner":                         "xdg_wm_base",
+	"zwlr_layer_surface_v1":                  "zwlr_layer_shell_v1",
+	"zwlr_output_power_v1":                   "zwlr_output_power_manager_v1",
+	"zwlr_gamma_control_v1":                  "zwlr_gamma_control_manager_v1",
+	"zwp_virtual_keyboard_v1":                "zwp_virtual_keyboard_manager_v1",
+	"zwlr_virtual_pointer_v1":                "zwlr_virtual_pointer_manager_v1",
+	"zwp_keyboard_shortcuts_inhibitor_v1":    "zwp_keyboard_shortcuts_inhibit_manager_v1",
+	"xdg_activation_token_v1":                "xdg_activation_v1",
+	"wp_color_management_surface_v1":         "wp_color_manager_v1",
+	"wp_image_description_creator_params_v1": "wp_color_manager_v1",
+	"wp_image_description_creator_icc_v1":    "wp_color_manager_v1",
+	"wp_image_description_v1":                "wp_color_manager_v1",
+	"wp_tearing_control_v1":                  "wp_tearing_control_manager_v1",
+	"wp_alpha_modifier_surface_v1":           "wp_alpha_modifier_v1",
+	"wp_fifo_v1":                             "wp_fifo_manager_v1",
+	"wp_commit_timer_v1":                     "wp_commit_timing_manager_v1",
+	"wl_data_device":                         "wl_data_device_manager",
+	"wl_data_offer":                          "wl_data_device_manager",
+	"wl_subsurface":                          "wl_subcompositor",
+	"xdg_toplevel_icon_v1":                   "xdg_toplevel_icon_manager_v1",
+	"wp_presentation_feedback":               "wp_presentation",
+	"zwp_linux_dmabuf_feedback_v1":           "zwp_linux_dmabuf_v1",
+	"zwp_linux_buffer_params_v1":             "zwp_linux_dmabuf_v1",
+}
+
+// supportedVersion returns the highest version of iface this package
+// supports, the one of its global for interfaces in childInterfaces, 0 if it
+// doesn't know iface. Globals bound through registerGlobalHandler are bound
+// at the advertised version unless given an entry in supportedVersions.
+func supportedVersion(iface string) uint32 {
+	if global, ok := childInterfaces[iface]; ok {
+		return supportedVersions[global]
+	}
+	return supportedVersions[iface]
+}
+
+// objHandlers routes the events of objCustom objects, guarded by objMu.
+var objHandlers [objectsLen]eventHandler
+
+// bindGlobal returns a globalHandler binding the global to *id, for
+// interfaces with a single global. Further globals of the interface aren't
+// bound.
+func bindGlobal(id *uint32, t objType) globalHandler {
+	return func(conn *net.UnixConn, name, ver uint32, iface []byte) {
+		objMu.Lock()
+		bound := *id != 0 && objects[*id] == t
+		objMu.Unlock()
+		if bound {
+			return
+		}
+		*id = mustRegBind(conn, t, name, ver, iface)
+	}
+}
+
+// bindEachGlobal returns a globalHandler binding every global of an
+// interface that may have several, like wl_output, appending them to *ids.
+// *first is set to the first one bound still alive unless it's alive itself,
+// so it may be switched to another.
+func bindEachGlobal(ids *[]uint32, first *uint32, t objType) globalHandler {
+	return func(conn *net.UnixConn, name, ver uint32, iface []byte) {
+		id := mustRegBind(conn, t, name, ver, iface)
+		objMu.Lock()
+		*ids = slices.DeleteFunc(*ids, func(id uint32) bool { return objects[id] != t })
+		objMu.Unlock()
+		*ids = append(*ids, id)
+		if !slices.Contains(*ids, *first) {
+			*first = (*ids)[0]
+		}
+	}
+}
+
+// dropGlobal removes obj, a global that's gone, from *ids bound by
+// bindEachGlobal, moving *first to the next one still bound if it was obj.
+func dropGlobal(ids *[]uint32, first *uint32, obj uint32) {
+	*ids = slices.DeleteFunc(*ids, func(id uint32) bool { return id == obj })
+	if *first != obj {
+		return
+	}
+	*first = 0
+	if len(*ids) > 0 {
+		*first = (*ids)[0]
+	}
+}
+
+// registerGlobalHandler makes the registry bind globals of iface, a protocol
+// this package doesn't know about. factory is called with the new object and
+// the advertised name and version, and returns the handler for the object's
+// events. Objects the handler creates itself can be routed the same way with
+// regObjHandler.
+func registerGlobalHandler(iface string, factory func(conn *net.UnixConn, id, name, ver uint32) eventHandler) {
+	globalHandlers[iface] = func(conn *net.UnixConn, name, ver uint32, iface []byte) {
+		id := mustRegBind(conn, objCustom, name, ver, iface)
+		h := factory(conn, id, name, ver)
+		objMu.Lock()
+		objHandlers[id] = h
+		objMu.Unlock()
+	}
+}
+
+// regObjHandler registers a new object whose events go to h.
+func regObjHandler(h eventHandler) (id uint32) {
+	id = regObj(objCustom)
+	objMu.Lock()
+	objHandlers[id] = h
+	objMu.Unlock()
+	return id
+}
+
+// errUnsupportedGlobal is wrapped by the errors of requests needing an
+// optional global the compositor doesn't advertise.
+var errUnsupportedGlobal = errors.New("not supported by the compositor")
+
+// unsupportedGlobalErr is the interface of the missing global.
+type unsupportedGlobalErr string
+
+func (err unsupportedGlobalErr) Error() string {
+	return string(err) + ": " + errUnsupportedGlobal.Error()
+}
+
+func (err unsupportedGlobalErr) Unwrap() error {
+	return errUnsupportedGlobal
+}
+
+// requireGlobal returns an unsupportedGlobalErr for iface unless its global
+// was bound to id. Requests on optional globals check it first, sending
+// them to id 0 would corrupt the stream.
+func requireGlobal(id uint32, iface string) error {
+	if id == 0 {
+		return unsupportedGlobalErr(iface)
+	}
+	return nil
+}
+
+// errVersionTooOld is wrapped by the errors of requests the object's version
+// doesn't have yet, because the compositor advertised its global at a lower
+// version than the one they were added in.
+var errVersionTooOld = errors.New("protocol version too old")
+
+// versionTooOldErr is request of iface needing version required, with the
+// object at version available.
+type versionTooOldErr struct {
+	iface, request      string
+	required, available uint32
+}
+
+func (err versionTooOldErr) Error() string {
+	return err.iface + "::" + err.request + ": " + errVersionTooOld.Error() + ", it needs version " +
+		strconv.FormatUint(uint64(err.required), 10) + " but the compositor offers " + strconv.FormatUint(uint64(err.available), 10)
+}
+
+func (err versionTooOldErr) Unwrap() error {
+	return errVersionTooOld
+}
+
+// requireVersion returns a versionTooOldErr unless object id is at version
+// since or above, the version request was added in. Requests added after
+// version 1 of their interface check it first, sending them anyway is a
+// fatal protocol error.
+func requireVersion(id, since uint32, request string) error {
+	ver := interfaceVersion(id)
+	if ver >= since {
+		return nil
+	}
+	return versionTooOldErr{iface: objTypeOf(id).String(), request: request, required: since, available: ver}
+}
+
+// global is a global announced by WLRegistryID.
+type global struct {
+	Name      uint32
+	Interface string
+	Version   uint32
+}
+
+var (
+	globalsMu sync.Mutex
+	// globals are the globals currently announced by WLRegistryID,
+	// grouped by interface in the order they were announced.
+	globals = map[string][]global{}
+	// boundGlobals maps the name of each global bound to its object.
+	boundGlobals = map[uint32]uint32{}
+)
+
+// globalsOf returns the globals of iface currently announced, e.g. every
+// wl_output, whether or not they've been bound.
+func globalsOf(iface string) []global {
+	globalsMu.Lock()
+	defer globalsMu.Unlock()
+	return slices.Clone(globals[iface])
+}
+
+// onGlobal, if set, is called for every global announced, after it was bound
+// if it has a globalHandler. obj is the object it was bound to, 0 if it
+// wasn't. The registry is handled for the connection's lifetime, so this
+// also sees globals added later, like a monitor or tablet plugged in.
+var onGlobal func(conn *net.UnixConn, g global, obj uint32)
+
+// onGlobalRemove, if set, is called for every global removed, obj is the
+// object it was bound to or 0. Outputs and seats are already released then,
+// other objects stay alive until destroyed.
+var onGlobalRemove func(conn *net.UnixConn, name, obj uint32)
+
+// mustReleaseRemoved releases obj, bound to a global that's gone, if it's a
+// wl_output or wl_seat at a version that has release, and the devices of the
+// seat if it's WLSeatID. The objects are dead after it, even though their
+// ids stay taken until the compositor's delete_id.
+func mustReleaseRemoved(conn *net.UnixConn, obj uint32) {
+	var opcode uint16
+	var since uint32
+	switch objTypeOf(obj) {
+	case objWLOutput:
+		opcode, since = 0, 3
+	case objWLSeat:
+		opcode, since = 3, 5
+		if obj == WLSeatID {
+			mustReleaseSeatDevices(conn)
+		}
+		pointerSerialsMu.Lock()
+		delete(pointerSerialsBySeat, obj)
+		pointerSerialsMu.Unlock()
+	default:
+		return
+	}
+	if interfaceVersion(obj) < since {
+		return
+	}
+	err := write(conn, makeMsgBuf(obj, opcode, 0))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// handleWLRegistryEvent keeps globals up to date and binds the globals
+// WLRegistryID announces that have a globalHandler, at startup and after.
+func handleWLRegistryEvent(conn *net.UnixConn, id, opcode uint32, body []byte) Event {
+	ev := decodeWLRegistryEvent(id, opcode, body)
+	switch ev := ev.(type) {
+	case WLRegistryGlobal:
+		g := global{Name: ev.Name, Interface: ev.Interface, Version: ev.Version}
+		globalsMu.Lock()
+		globals[ev.Interface] = append(globals[ev.Interface], g)
+		globalsMu.Unlock()
+		if h, ok := globalHandlers[ev.Interface]; ok {
+			h(conn, ev.Name, ev.Version, []byte(ev.Interface))
+		}
+		if onGlobal != nil {
+			globalsMu.Lock()
+			obj := boundGlobals[ev.Name]
+			globalsMu.Unlock()
+			onGlobal(conn, g, obj)
+		}
+	case WLRegistryGlobalRemove:
+		globalsMu.Lock()
+		obj, ok := boundGlobals[ev.Name]
+		if ok {
+			delete(boundGlobals, ev.Name)
+			delete(outputNames, obj)
+			delete(outputs, obj)
+			delete(pendingOutputs, obj)
+			delete(seatNames, obj)
+			delete(seatCaps, obj)
+		}
+		for iface, gs := range globals {
+			gs = slices.DeleteFunc(gs, func(g global) bool { return g.Name == ev.Name })
+			if len(gs) == 0 {
+				delete(globals, iface)
+			} else {
+				globals[iface] = gs
+			}
+		}
+		globalsMu.Unlock()
+		if ok {
+			current := obj == WLSeatID
+			mustReleaseRemoved(conn, obj)
+			dropGlobal(&WLOutputIDs, &WLOutputID, obj)
+			dropGlobal(&WLSeatIDs, &WLSeatID, obj)
+			if current && WLSeatID != 0 {
+				// Input moves to the next seat.
+				globalsMu.Lock()
+				caps := seatCaps[WLSeatID]
+				globalsMu.Unlock()
+				mustGetSeatDevices(conn, caps)
+			}
+		}
+		if onGlobalRemove != nil {
+			onGlobalRemove(conn, ev.Name, obj)
+		}
+	}
+	return ev
+}
+
+func decodeWLRegistryEvent(id, opcode uint32, body []byte) Event {
+	switch opcode {
+	case 0: // global
+		name := binary.LittleEndian.Uint32(body)
+		iface, off := parseStr(body[4:])
+		ver := binary.LittleEndian.Uint32(body[4+off:])
+		return WLRegistryGlobal{Registry: id, Name: name, Interface: string(iface), Version: ver}
+	case 1: // global_remove
+		return WLRegistryGlobalRemove{Registry: id, Name: binary.LittleEndian.Uint32(body)}
+	}
+	return nil
+}
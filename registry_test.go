@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// announceGlobal handles a wl_registry.global event for name.
+func announceGlobal(t *testing.T, conn *net.UnixConn, name uint32, iface string, ver uint32) {
+	t.Helper()
+	body := binary.LittleEndian.AppendUint32(nil, name)
+	body = appendStr(body, iface)
+	body = binary.LittleEndian.AppendUint32(body, ver)
+	handleWLRegistryEvent(conn, WLRegistryID, 0, body)
+}
+
+func removeGlobal(conn *net.UnixConn, name uint32) {
+	handleWLRegistryEvent(conn, WLRegistryID, 1, binary.LittleEndian.AppendUint32(nil, name))
+}
+
+func TestGlobalRemoveDropsOutputsAndSeats(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	conn, _ := startMockCompositor(t, nil)
+	WLRegistryID = regObj(objWLRegistry)
+	announceGlobal(t, conn, 10, "wl_output", 4)
+	announceGlobal(t, conn, 11, "wl_output", 4)
+	announceGlobal(t, conn, 20, "wl_seat", 9)
+	first, second := WLOutputIDs[0], WLOutputIDs[1]
+	if WLOutputID != first {
+		t.Fatalf("WLOutputID is %d, want %d", WLOutputID, first)
+	}
+
+	removeGlobal(conn, 10)
+	if !slices.Equal(WLOutputIDs, []uint32{second}) || WLOutputID != second {
+		t.Errorf("after removing the first output got %v and WLOutputID %d, want [%d] and %d", WLOutputIDs, WLOutputID, second, second)
+	}
+	removeGlobal(conn, 11)
+	if len(WLOutputIDs) != 0 || WLOutputID != 0 {
+		t.Errorf("after removing every output got %v and WLOutputID %d", WLOutputIDs, WLOutputID)
+	}
+	if len(WLSeatIDs) != 1 || WLSeatID == 0 {
+		t.Errorf("seat dropped with the outputs, got %v and WLSeatID %d", WLSeatIDs, WLSeatID)
+	}
+	removeGlobal(conn, 20)
+	if len(WLSeatIDs) != 0 || WLSeatID != 0 {
+		t.Errorf("after removing the seat got %v and WLSeatID %d", WLSeatIDs, WLSeatID)
+	}
+}
+
+func TestOnGlobal(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	conn, _ := startMockCompositor(t, nil)
+	WLRegistryID = regObj(objWLRegistry)
+	bound := map[uint32]uint32{}
+	var removed []uint32
+	onGlobal = func(conn *net.UnixConn, g global, obj uint32) { bound[g.Name] = obj }
+	onGlobalRemove = func(conn *net.UnixConn, name, obj uint32) {
+		if obj != bound[name] {
+			t.Errorf("global %d removed with object %d, was bound to %d", name, obj, bound[name])
+		}
+		removed = append(removed, name)
+	}
+	t.Cleanup(func() { onGlobal, onGlobalRemove = nil, nil })
+
+	announceGlobal(t, conn, 10, "wl_output", 4)
+	announceGlobal(t, conn, 30, "wp_made_up_v1", 1)
+	if obj, ok := bound[10]; !ok || objTypeOf(obj) != objWLOutput {
+		t.Errorf("onGlobal got object %d for wl_output, want its wl_output", obj)
+	}
+	if obj, ok := bound[30]; !ok || obj != 0 {
+		t.Errorf("onGlobal got object %d for an unbound global, want 0", obj)
+	}
+	removeGlobal(conn, 10)
+	removeGlobal(conn, 30)
+	if !slices.Equal(removed, []uint32{10, 30}) {
+		t.Errorf("onGlobalRemove got %v, want [10 30]", removed)
+	}
+}
+
+func TestGlobalRemoveReleases(t *testing.T) {
+	type request struct {
+		obj    *uint32
+		opcode uint32
+	}
+	var output, seat, pointer, keyboard uint32
+	for _, tc := range []struct {
+		name  string
+		iface string
+		ver   uint32
+		obj   *uint32
+		// want are the requests the removal sends.
+		want []request
+	}{
+		{"output", "wl_output", 4, &output, []request{{&output, 0}}},
+		{"output before release", "wl_output", 2, &output, nil},
+		{"seat", "wl_seat", 9, &seat, []request{{&pointer, 1}, {&keyboard, 0}, {&seat, 3}}},
+		{"seat before release", "wl_seat", 4, &seat, []request{{&pointer, 1}, {&keyboard, 0}}},
+		{"seat before device release", "wl_seat", 2, &seat, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			var mu sync.Mutex
+			var got [][2]uint32
+			recording := false
+			conn, _ := startMockCompositor(t, func(id, opcode uint32, body []byte) {
+				mu.Lock()
+				if recording {
+					got = append(got, [2]uint32{id, opcode})
+				}
+				mu.Unlock()
+			})
+			WLRegistryID = regObj(objWLRegistry)
+			announceGlobal(t, conn, 10, tc.iface, tc.ver)
+			output, seat = WLOutputID, WLSeatID
+			if seat != 0 {
+				handleWLSeatEvent(conn, seat, 0, binary.LittleEndian.AppendUint32(nil, WLSeatCapabilityPointer|WLSeatCapabilityKeyboard))
+			}
+			pointer, keyboard = WLPointerID, WLKeyboardID
+			mustRoundtrip(t, conn)
+			mu.Lock()
+			recording = true
+			mu.Unlock()
+
+			removeGlobal(conn, 10)
+			if WLPointerID != 0 || WLKeyboardID != 0 {
+				t.Errorf("the removed seat's pointer %d and keyboard %d are still used", WLPointerID, WLKeyboardID)
+			}
+			mustRoundtrip(t, conn)
+			mu.Lock()
+			defer mu.Unlock()
+			var want [][2]uint32
+			for _, r := range tc.want {
+				want = append(want, [2]uint32{*r.obj, r.opcode})
+			}
+			// The roundtrip's sync and its callback's id.
+			got = got[:len(got)-1]
+			if !slices.Equal(got, want) {
+				t.Errorf("removing the global sent %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSupportedVersions(t *testing.T) {
+	for iface := range globalHandlers {
+		if _, ok := supportedVersions[iface]; !ok {
+			t.Errorf("%s has a globalHandler but no supportedVersions entry", iface)
+		}
+	}
+	for child, global := range childInterfaces {
+		if _, ok := supportedVersions[global]; !ok {
+			t.Errorf("%s is created through %s, which has no supportedVersions entry", child, global)
+		}
+		if _, ok := supportedVersions[child]; ok {
+			t.Errorf("%s is in both childInterfaces and supportedVersions", child)
+		}
+	}
+	for _, tc := range []struct {
+		iface string
+		want  uint32
+	}{
+		{"wl_compositor", 6},
+		{"wl_surface", 6},
+		{"wl_pointer", 9},
+		{"xdg_toplevel", 1},
+		{"wl_data_offer", 3},
+		{"zwp_linux_dmabuf_feedback_v1", 4},
+		{"wl_callback", 0},
+		{"unknown_v1", 0},
+	} {
+		if got := supportedVersion(tc.iface); got != tc.want {
+			t.Errorf("supportedVersion(%q) is %d, want %d", tc.iface, got, tc.want)
+		}
+	}
+}
+
+func TestRegisterGlobalHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		iface      string
+		advertised uint32
+		supported  uint32 // its supportedVersions entry, 0 for none
+		// want is the version bound, the factory gets the advertised one.
+		want uint32
+	}{
+		{"bound at the advertised version", "wp_made_up_v1", 3, 0, 3},
+		{"capped by supportedVersions", "wp_capped_v1", 5, 2, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			WLRegistryID = regObj(objWLRegistry)
+			registry := WLRegistryID
+			var mu sync.Mutex
+			var bind []byte
+			conn, m := startMockCompositor(t, func(id, opcode uint32, body []byte) {
+				if id == registry && opcode == 0 {
+					mu.Lock()
+					bind = slices.Clone(body)
+					mu.Unlock()
+				}
+			})
+			if tc.supported != 0 {
+				supportedVersions[tc.iface] = tc.supported
+				t.Cleanup(func() { delete(supportedVersions, tc.iface) })
+			}
+			type event struct {
+				id, opcode uint32
+				body       []byte
+			}
+			var obj, gotName, gotVer uint32
+			var events []event
+			registerGlobalHandler(tc.iface, func(conn *net.UnixConn, id, name, ver uint32) eventHandler {
+				obj, gotName, gotVer = id, name, ver
+				return func(conn *net.UnixConn, id, opcode uint32, body []byte) {
+					events = append(events, event{id, opcode, slices.Clone(body)})
+				}
+			})
+			t.Cleanup(func() { delete(globalHandlers, tc.iface) })
+
+			announceGlobal(t, conn, 40, tc.iface, tc.advertised)
+			if obj == 0 || gotName != 40 || gotVer != tc.advertised {
+				t.Fatalf("factory got object %d, name %d, version %d, want a new object, 40 and %d", obj, gotName, gotVer, tc.advertised)
+			}
+			if v := interfaceVersion(obj); v != tc.want {
+				t.Errorf("object at version %d, want %d", v, tc.want)
+			}
+			mustRoundtrip(t, conn)
+			want := binary.LittleEndian.AppendUint32(nil, 40)
+			want = appendStr(want, tc.iface)
+			want = binary.LittleEndian.AppendUint32(want, tc.want)
+			want = binary.LittleEndian.AppendUint32(want, obj)
+			mu.Lock()
+			if !slices.Equal(bind, want) {
+				t.Errorf("bind sent %v, want %v", bind, want)
+			}
+			mu.Unlock()
+
+			m.emit(obj, 1, 42)
+			id, opcode, body, err := read(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = handleEvent(context.Background(), conn, id, opcode, body)
+			releaseBody(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantEvents := []event{{obj, 1, binary.LittleEndian.AppendUint32(nil, 42)}}
+			if !reflect.DeepEqual(events, wantEvents) {
+				t.Errorf("handler got %v, want %v", events, wantEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// surfaceRole is what a wl_surface is used as. A surface gets a role at most
+// once, it may only be given the same role again after its role object is
+// destroyed.
+type surfaceRole uint8
+
+const (
+	roleNone surfaceRole = iota
+	roleXDGSurface
+	roleLayerSurface
+	roleSubsurface
+	roleCursor
+)
+
+var surfaceRoleNames = [...]string{
+	roleNone:         "none",
+	roleXDGSurface:   "xdg_surface",
+	roleLayerSurface: "zwlr_layer_surface_v1",
+	roleSubsurface:   "wl_subsurface",
+	roleCursor:       "cursor",
+}
+
+func (r surfaceRole) String() string {
+	return surfaceRoleNames[r]
+}
+
+// surfaceRoles is the role of each wl_surface in objects, guarded by objMu.
+var surfaceRoles [objectsLen]surfaceRole
+
+// assignRole gives surface role, or returns the error the compositor would
+// otherwise send as a fatal protocol error if it already has another one.
+func assignRole(surface uint32, role surfaceRole) error {
+	if surface >= objectsLen {
+		return errors.New("wl_surface: no surface " + strconv.Itoa(int(surface)))
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	if cur := surfaceRoles[surface]; cur != roleNone && cur != role {
+		return errors.New("wl_surface: can't give surface the role " + role.String() + ", it already has the role " + cur.String())
+	}
+	surfaceRoles[surface] = role
+	return nil
+}
+
+// roleOf returns the role of surface, roleNone if it has none yet.
+func roleOf(surface uint32) surfaceRole {
+	if surface >= objectsLen {
+		return roleNone
+	}
+	objMu.Lock()
+	defer objMu.Unlock()
+	return surfaceRoles[surface]
+}
+
+// setCursor makes surface the pointer's cursor for the enter serial, with
+// its hotspot at x, y in surface coordinates. surface 0 hides the cursor.
+func setCursor(conn *net.UnixConn, serial, surface uint32, x, y int32) error {
+	if surface != 0 {
+		err := assignRole(surface, roleCursor)
+		if err != nil {
+			return err
+		}
+	}
+	buf := makeMsgBuf(WLPointerID, 0, WORD_SIZE*4)
+	buf = binary.LittleEndian.AppendUint32(buf, serial)
+	buf = binary.LittleEndian.AppendUint32(buf, surface)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(x))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(y))
+	return write(conn, buf)
+}
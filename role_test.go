@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestAssignRole(t *testing.T) {
+	resetTestState(t)
+	surface := regObj(objWLSurface)
+
+	err := assignRole(surface, roleXDGSurface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = assignRole(surface, roleXDGSurface)
+	if err != nil {
+		t.Errorf("giving the same role again: %v", err)
+	}
+	err = assignRole(surface, roleCursor)
+	if err == nil {
+		t.Error("gave a second role")
+	}
+	if got := roleOf(surface); got != roleXDGSurface {
+		t.Errorf("got role %v, want %v", got, roleXDGSurface)
+	}
+
+	err = assignRole(objectsLen, roleCursor)
+	if err == nil {
+		t.Error("gave a role to an id past the object table")
+	}
+	if got := roleOf(objectsLen); got != roleNone {
+		t.Errorf("got role %v for an id past the object table, want %v", got, roleNone)
+	}
+}
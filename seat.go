@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	// seatNames maps each bound wl_seat to its name, like "seat0", sent
+	// since wl_seat v2.
+	seatNames = map[uint32]string{}
+	// seatCaps maps each bound wl_seat to its capabilities.
+	seatCaps = map[uint32]uint32{}
+)
+
+// seatName is the name of the seat to read input from in multi-seat setups.
+// Input is read from the first seat until one of that name is announced,
+// and always if it's empty.
+var seatName string
+
+// seatByName returns the bound wl_seat named name.
+func seatByName(name string) (uint32, error) {
+	for id, n := range seatNames {
+		if n == name {
+			return id, nil
+		}
+	}
+	return 0, errors.New("wl_seat: no seat named " + name)
+}
+
+// selectSeat makes the seat named name WLSeatID, the one input is read
+// from and grabs are made with.
+func selectSeat(conn *net.UnixConn, name string) error {
+	id, err := seatByName(name)
+	if err != nil {
+		return err
+	}
+	if id != WLSeatID {
+		mustSwitchSeat(conn, id)
+	}
+	return nil
+}
+
+// mustSwitchSeat releases the input and data devices of WLSeatID and gets
+// those of seat instead.
+func mustSwitchSeat(conn *net.UnixConn, seat uint32) {
+	mustReleaseSeatDevices(conn)
+	WLSeatID = seat
+	mustGetSeatDevices(conn, seatCaps[seat])
+}
+
+// mustReleaseSeatDevices releases the input and data devices of WLSeatID.
+// Devices of a seat bound below version 3 can't be released, the
+// compositor keeps sending their events, which are ignored.
+func mustReleaseSeatDevices(conn *net.UnixConn) {
+	for _, dev := range []struct {
+		id      *uint32
+		release uint16
+		since   uint32
+	}{
+		{&WLPointerID, 1, 3},
+		{&WLKeyboardID, 0, 3},
+		{&WLTouchID, 0, 3},
+		{&WLDataDeviceID, 2, 2},
+	} {
+		if *dev.id == 0 {
+			continue
+		}
+		if interfaceVersion(*dev.id) >= dev.since {
+			err := write(conn, makeMsgBuf(*dev.id, dev.release, 0))
+			if err != nil {
+				panic(err)
+			}
+		}
+		*dev.id = 0
+	}
+	stopKeyRepeat()
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// shmFormat is a wl_shm::format. Apart from ARGB8888 and XRGB8888 they're
+// the DRM fourcc codes from drm_fourcc.h, all little-endian, i.e. XRGB8888
+// is B, G, R, X in memory.
+type shmFormat uint32
+
+const (
+	WLShmFormatARGB8888 shmFormat = 0
+	WLShmFormatXRGB8888 shmFormat = 1
+
+	WLShmFormatC8            shmFormat = 'C' | '8'<<8 | ' '<<16 | ' '<<24
+	WLShmFormatRGB565        shmFormat = 'R' | 'G'<<8 | '1'<<16 | '6'<<24
+	WLShmFormatRGB888        shmFormat = 'R' | 'G'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatBGR888        shmFormat = 'B' | 'G'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatXBGR8888      shmFormat = 'X' | 'B'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatABGR8888      shmFormat = 'A' | 'B'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatRGBX8888      shmFormat = 'R' | 'X'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatRGBA8888      shmFormat = 'R' | 'A'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatBGRX8888      shmFormat = 'B' | 'X'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatBGRA8888      shmFormat = 'B' | 'A'<<8 | '2'<<16 | '4'<<24
+	WLShmFormatXRGB2101010   shmFormat = 'X' | 'R'<<8 | '3'<<16 | '0'<<24
+	WLShmFormatARGB2101010   shmFormat = 'A' | 'R'<<8 | '3'<<16 | '0'<<24
+	WLShmFormatXBGR2101010   shmFormat = 'X' | 'B'<<8 | '3'<<16 | '0'<<24
+	WLShmFormatABGR2101010   shmFormat = 'A' | 'B'<<8 | '3'<<16 | '0'<<24
+	WLShmFormatXBGR16161616F shmFormat = 'X' | 'B'<<8 | '4'<<16 | 'H'<<24
+	WLShmFormatABGR16161616F shmFormat = 'A' | 'B'<<8 | '4'<<16 | 'H'<<24
+)
+
+var shmFormatNames = map[shmFormat]string{
+	WLShmFormatARGB8888:      "ARGB8888",
+	WLShmFormatXRGB8888:      "XRGB8888",
+	WLShmFormatC8:            "C8",
+	WLShmFormatRGB565:        "RGB565",
+	WLShmFormatRGB888:        "RGB888",
+	WLShmFormatBGR888:        "BGR888",
+	WLShmFormatXBGR8888:      "XBGR8888",
+	WLShmFormatABGR8888:      "ABGR8888",
+	WLShmFormatRGBX8888:      "RGBX8888",
+	WLShmFormatRGBA8888:      "RGBA8888",
+	WLShmFormatBGRX8888:      "BGRX8888",
+	WLShmFormatBGRA8888:      "BGRA8888",
+	WLShmFormatXRGB2101010:   "XRGB2101010",
+	WLShmFormatARGB2101010:   "ARGB2101010",
+	WLShmFormatXBGR2101010:   "XBGR2101010",
+	WLShmFormatABGR2101010:   "ABGR2101010",
+	WLShmFormatXBGR16161616F: "XBGR16161616F",
+	WLShmFormatABGR16161616F: "ABGR16161616F",
+}
+
+// String returns the format's name, or its fourcc for formats without a
+// constant here.
+func (f shmFormat) String() string {
+	if name, ok := shmFormatNames[f]; ok {
+		return name
+	}
+	return string([]byte{byte(f), byte(f >> 8), byte(f >> 16), byte(f >> 24)})
+}
+
+// bytesPerPixel returns the size of a pixel in f, 0 for formats without a
+// constant here.
+func (f shmFormat) bytesPerPixel() int32 {
+	switch f {
+	case WLShmFormatC8:
+		return 1
+	case WLShmFormatRGB565:
+		return 2
+	case WLShmFormatRGB888, WLShmFormatBGR888:
+		return 3
+	case WLShmFormatXBGR16161616F, WLShmFormatABGR16161616F:
+		return 8
+	}
+	if _, ok := shmFormatNames[f]; ok {
+		return 4
+	}
+	return 0
+}
+
+// shmFormats are the formats the compositor accepts. ARGB8888 and XRGB8888
+// must always be supported so they're there before the format events.
+var shmFormats = map[shmFormat]bool{
+	WLShmFormatARGB8888: true,
+	WLShmFormatXRGB8888: true,
+}
+
+// bufFormat is the format of WLBufferID.
+var bufFormat = WLShmFormatXRGB8888
+
+// hasAlpha reports whether f has an alpha channel.
+func (f shmFormat) hasAlpha() bool {
+	switch f {
+	case WLShmFormatARGB8888, WLShmFormatABGR8888, WLShmFormatRGBA8888, WLShmFormatBGRA8888,
+		WLShmFormatARGB2101010, WLShmFormatABGR2101010, WLShmFormatABGR16161616F:
+		return true
+	}
+	return false
+}
+
+// compositorFormats are the formats the compositor prefers, most preferred
+// first, e.g. from a zwp_linux_dmabuf_feedback_v1 tranche via
+// dmabufTrancheFormats. Empty when it gave no hint.
+var compositorFormats []shmFormat
+
+// preferredFormat returns the format buffers should use: the first of
+// compositorFormats wl_shm accepts that has alpha only if needed, otherwise
+// XRGB8888, or ARGB8888 with alpha. Without alpha compositors can skip
+// blending, so the alpha formats are only picked when asked for.
+func preferredFormat(alpha bool) shmFormat {
+	for _, f := range compositorFormats {
+		if shmFormats[f] && f.hasAlpha() == alpha && f.bytesPerPixel() != 0 {
+			return f
+		}
+	}
+	if alpha {
+		return WLShmFormatARGB8888
+	}
+	return WLShmFormatXRGB8888
+}
+
+// dmabufTrancheFormats returns the formats of a linux-dmabuf feedback
+// tranche in order without repeats. table is the mmapped format_table, 16
+// byte entries of a fourcc, padding and a modifier, and indices the
+// tranche_formats array of u16 indices into it. The fourccs for ARGB8888 and
+// XRGB8888 are mapped to their wl_shm values.
+func dmabufTrancheFormats(table, indices []byte) ([]shmFormat, error) {
+	if len(table)%16 != 0 || len(indices)%2 != 0 {
+		return nil, errors.New("linux-dmabuf feedback: malformed format table or tranche")
+	}
+	var fs []shmFormat
+	seen := map[shmFormat]bool{}
+	for i := 0; i < len(indices); i += 2 {
+		idx := int(binary.LittleEndian.Uint16(indices[i:]))
+		if idx >= len(table)/16 {
+			return nil, errors.New("linux-dmabuf feedback: tranche index " + strconv.Itoa(idx) + " out of the format table")
+		}
+		f := shmFormat(binary.LittleEndian.Uint32(table[idx*16:]))
+		switch f {
+		case 'A' | 'R'<<8 | '2'<<16 | '4'<<24:
+			f = WLShmFormatARGB8888
+		case 'X' | 'R'<<8 | '2'<<16 | '4'<<24:
+			f = WLShmFormatXRGB8888
+		}
+		if !seen[f] {
+			seen[f] = true
+			fs = append(fs, f)
+		}
+	}
+	return fs, nil
+}
+
+func handleWLShmEvent(opcode uint32, body []byte) {
+	switch opcode {
+	case 0: // format
+		shmFormats[shmFormat(binary.LittleEndian.Uint32(body))] = true
+	}
+}
+
+// shmBuffer is the layout of a buffer in a wl_shm_pool.
+type shmBuffer struct {
+	offset        int32
+	width, height int32
+	stride        int32
+	format        shmFormat
+}
+
+// alignStride returns the smallest stride for width pixels of format that
+// keeps every row 4-byte aligned.
+func alignStride(width int32, format shmFormat) int32 {
+	return (width*format.bytesPerPixel() + 3) &^ 3
+}
+
+// validate checks b fits in a pool of poolSize bytes, the checks the
+// compositor would otherwise answer with a fatal invalid_stride or
+// invalid_format error.
+func (b shmBuffer) validate(poolSize int) error {
+	if !shmFormats[b.format] {
+		return errors.New("wl_shm: format " + b.format.String() + " not supported by the compositor")
+	}
+	if b.width <= 0 || b.height <= 0 {
+		return errors.New("wl_shm_pool: invalid buffer size " + strconv.Itoa(int(b.width)) + "x" + strconv.Itoa(int(b.height)))
+	}
+	if b.offset < 0 {
+		return errors.New("wl_shm_pool: negative buffer offset")
+	}
+	if bpp := b.format.bytesPerPixel(); bpp != 0 && int64(b.stride) < int64(b.width)*int64(bpp) {
+		return errors.New("wl_shm_pool: stride " + strconv.Itoa(int(b.stride)) + " shorter than a row of " + strconv.Itoa(int(b.width)) + " " + b.format.String() + " pixels")
+	}
+	if end := int64(b.offset) + int64(b.stride)*int64(b.height); end > int64(poolSize) {
+		return errors.New("wl_shm_pool: buffer ends at " + strconv.FormatInt(end, 10) + ", past the pool's " + strconv.Itoa(poolSize) + " bytes")
+	}
+	return nil
+}
+
+// createBuffer creates a buffer laid out as b in the pool of poolSize bytes,
+// several buffers can be packed in one pool at different offsets.
+func createBuffer(conn *net.UnixConn, pool uint32, poolSize int, b shmBuffer) (id uint32, err error) {
+	err = b.validate(poolSize)
+	if err != nil {
+		return 0, err
+	}
+	buf := makeMsgBuf(pool, 0, WORD_SIZE*6)
+	id = regChildObj(objWLBuffer, pool)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(b.offset))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(b.width))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(b.height))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(b.stride))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(b.format))
+	return id, write(conn, buf)
+}
+
+// createPool creates a wl_shm_pool of size bytes backed by a new temp file,
+// returning the file and its mapping. The pages are allocated up front where
+// the filesystem supports it, so running out of memory is an error here
+// rather than a SIGBUS on first write. Nothing is sent on error, the app can
+// retry with a smaller size.
+func createPool(conn *net.UnixConn, size int64) (id uint32, f *os.File, mem []byte, err error) {
+	if size <= 0 || size > math.MaxInt32 {
+		return 0, nil, nil, errors.New("wl_shm: pool size " + strconv.FormatInt(size, 10) + " out of range, it must fit an int32")
+	}
+	f, err = os.CreateTemp("", "wl_shm_pool")
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	os.Remove(f.Name())
+	fd := int(f.Fd())
+	err = unix.Fallocate(fd, 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		f.Close()
+		return 0, nil, nil, errors.New("wl_shm: allocating a " + strconv.FormatInt(size, 10) + " byte pool: " + err.Error())
+	}
+	mem, err = unix.Mmap(fd, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return 0, nil, nil, errors.New("wl_shm: mapping a " + strconv.FormatInt(size, 10) + " byte pool: " + err.Error())
+	}
+	buf := makeMsgBuf(WLShmID, 0, WORD_SIZE*2)
+	id = regChildObj(objWLShmPool, WLShmID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	err = writeFD(conn, buf, fd)
+	if err != nil {
+		unix.Munmap(mem)
+		f.Close()
+		return 0, nil, nil, err
+	}
+	return id, f, mem, nil
+}
+
+// resizePool grows the pool id backed by f and mapped at mem to size bytes,
+// returning the new mapping. The old one is unmapped unless it fails, pools
+// can only grow. Like createPool the size has to fit an int32.
+func resizePool(conn *net.UnixConn, id uint32, f *os.File, mem []byte, size int64) ([]byte, error) {
+	if size > math.MaxInt32 {
+		return mem, errors.New("wl_shm_pool: pool size " + strconv.FormatInt(size, 10) + " out of range, it must fit an int32")
+	}
+	fd := int(f.Fd())
+	err := unix.Fallocate(fd, 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		err = f.Truncate(size)
+	}
+	if err != nil {
+		return mem, err
+	}
+	newMem, err := unix.Mmap(fd, 0, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return mem, err
+	}
+	unix.Munmap(mem)
+	buf := makeMsgBuf(id, 2, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	return newMem, write(conn, buf)
+}
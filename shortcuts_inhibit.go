@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+type shortcutsInhibitor struct {
+	id uint32
+	// active is set while the compositor forwards its shortcuts to us.
+	active bool
+}
+
+// shortcutsMu guards shortcutsInhibitors and their state, which the
+// dispatcher goroutine updates.
+var shortcutsMu sync.Mutex
+
+// shortcutsInhibitors maps a wl_seat id to the inhibitor for WLSurfaceID.
+var shortcutsInhibitors = map[uint32]*shortcutsInhibitor{}
+
+// errShortcutsAlreadyInhibited mirrors the manager's already_inhibited error,
+// which is fatal if it reaches the compositor.
+var errShortcutsAlreadyInhibited = errors.New("zwp_keyboard_shortcuts_inhibit_manager_v1: shortcuts already inhibited for this surface and seat")
+
+// inhibitShortcuts asks the compositor to forward its keyboard shortcuts on
+// seat to WLSurfaceID while it has focus. Whether it's honored is reported by
+// the active/inactive events.
+func inhibitShortcuts(conn *net.UnixConn, seat uint32) error {
+	shortcutsMu.Lock()
+	defer shortcutsMu.Unlock()
+	if _, ok := shortcutsInhibitors[seat]; ok {
+		return errShortcutsAlreadyInhibited
+	}
+	buf := makeMsgBuf(ZWPKeyboardShortcutsInhibitManagerID, 1, WORD_SIZE*3)
+	inhibitor := &shortcutsInhibitor{id: regChildObj(objZWPKeyboardShortcutsInhibitor, ZWPKeyboardShortcutsInhibitManagerID)}
+	buf = binary.LittleEndian.AppendUint32(buf, inhibitor.id)
+	buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+	buf = binary.LittleEndian.AppendUint32(buf, seat)
+	err := write(conn, buf)
+	if err != nil {
+		return err
+	}
+	shortcutsInhibitors[seat] = inhibitor
+	return nil
+}
+
+// mustRestoreShortcuts destroys the inhibitor on seat, if any.
+func mustRestoreShortcuts(conn *net.UnixConn, seat uint32) {
+	shortcutsMu.Lock()
+	inhibitor, ok := shortcutsInhibitors[seat]
+	delete(shortcutsInhibitors, seat)
+	shortcutsMu.Unlock()
+	if !ok {
+		return
+	}
+	buf := makeMsgBuf(inhibitor.id, 0, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func handleZWPKeyboardShortcutsInhibitorEvent(id, opcode uint32) Event {
+	shortcutsMu.Lock()
+	defer shortcutsMu.Unlock()
+	for seat, inhibitor := range shortcutsInhibitors {
+		if inhibitor.id != id {
+			continue
+		}
+		switch opcode {
+		case 0: // active
+			inhibitor.active = true
+			return ZWPKeyboardShortcutsInhibitorActive{Seat: seat}
+		case 1: // inactive
+			inhibitor.active = false
+			return ZWPKeyboardShortcutsInhibitorInactive{Seat: seat}
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals makes main disconnect cleanly on SIGINT and SIGTERM instead
+// of being killed mid-request. Code that handles signals itself should turn
+// it off and call disconnect on its own.
+var handleSignals = true
+
+// shutdownSignals are the signals watchSignals disconnects on.
+var shutdownSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// watchSignals makes nextMsg return errDisconnected on the first of
+// shutdownSignals, so the event loop returns and disconnects conn itself.
+// Disconnecting here instead would close conn while the loop may be writing
+// to it. The returned func stops watching.
+func watchSignals() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, shutdownSignals...)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-ch:
+			slog.Info("disconnecting on signal", "signal", sig)
+			interruptReader(errDisconnected)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
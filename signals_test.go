@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchSignalsStopsLoop(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	startReader(conn)
+	stop := watchSignals()
+	defer stop()
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan error, 1)
+	go func() {
+		for {
+			_, _, body, err := nextMsg()
+			releaseBody(body)
+			if err != nil {
+				got <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nextMsg didn't return after SIGTERM")
+	}
+	if !errors.Is(err, errDisconnected) {
+		t.Fatalf("got %v, want %v", err, errDisconnected)
+	}
+	if connClosed(conn) {
+		t.Fatal("conn closed by the signal handler rather than the loop")
+	}
+
+	err = disconnect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = nextMsg()
+	if !errors.Is(err, errDisconnected) {
+		t.Errorf("got %v after disconnect, want %v", err, errDisconnected)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// counters are bumped by read and flush.
+var counters struct {
+	msgsIn, msgsOut   atomic.Uint64
+	bytesIn, bytesOut atomic.Uint64
+	fdsIn, fdsOut     atomic.Uint64
+}
+
+type connStats struct {
+	MsgsReceived  uint64
+	MsgsSent      uint64
+	BytesReceived uint64
+	BytesSent     uint64
+	FDsReceived   uint64
+	FDsSent       uint64
+	// OpenRecvFDs is the number of fds received and not closed yet.
+	OpenRecvFDs int
+	// LiveObjects is the number of used slots in the object table, out of
+	// objectsLen.
+	LiveObjects int
+}
+
+// stats returns a snapshot of the connection counters. A LiveObjects that
+// keeps growing points at objects that are never destroyed.
+func stats() connStats {
+	s := connStats{
+		MsgsReceived:  counters.msgsIn.Load(),
+		MsgsSent:      counters.msgsOut.Load(),
+		BytesReceived: counters.bytesIn.Load(),
+		BytesSent:     counters.bytesOut.Load(),
+		FDsReceived:   counters.fdsIn.Load(),
+		FDsSent:       counters.fdsOut.Load(),
+	}
+	recvFDsMu.Lock()
+	s.OpenRecvFDs = len(recvFDOwners)
+	recvFDsMu.Unlock()
+	objMu.Lock()
+	for _, t := range objects[1:] {
+		if t != objNone {
+			s.LiveObjects++
+		}
+	}
+	objMu.Unlock()
+	return s
+}
+
+// Thresholds for checkObjLeaks, 0 disables the check. Live objects only pile
+// up when a destroy is missing or a delete_id isn't handled, so crossing them
+// is almost always a bug rather than a busy client.
+var (
+	// objLeakThreshold is the number of live objects of any type.
+	objLeakThreshold = objectsLen * 3 / 4
+	// objTypeLeakThreshold is the number of live objects of a single type.
+	objTypeLeakThreshold = objectsLen / 4
+)
+
+var (
+	objLeakWarned     bool
+	objTypeLeakWarned = map[objType]bool{}
+)
+
+// checkObjLeaks warns once each time the live object count, overall or of
+// type t, crosses its threshold. objMu must be held.
+func checkObjLeaks(t objType) {
+	var live, liveOfType int
+	for _, o := range objects[1:] {
+		if o == objNone {
+			continue
+		}
+		live++
+		if o == t {
+			liveOfType++
+		}
+	}
+	if objLeakThreshold > 0 {
+		over := live >= objLeakThreshold
+		if over && !objLeakWarned {
+			slog.Warn("object table filling up, possible object leak", "live", live, "max", objectsLen)
+		}
+		objLeakWarned = over
+	}
+	if objTypeLeakThreshold > 0 {
+		over := liveOfType >= objTypeLeakThreshold
+		if over && !objTypeLeakWarned[t] {
+			slog.Warn("objects of one type piling up, possible missing destroy or delete_id", "type", t, "live", liveOfType)
+		}
+		objTypeLeakWarned[t] = over
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// sub returns the counters of s gained since before.
+func (s connStats) sub(before connStats) connStats {
+	return connStats{
+		MsgsReceived:  s.MsgsReceived - before.MsgsReceived,
+		MsgsSent:      s.MsgsSent - before.MsgsSent,
+		BytesReceived: s.BytesReceived - before.BytesReceived,
+		BytesSent:     s.BytesSent - before.BytesSent,
+		FDsReceived:   s.FDsReceived - before.FDsReceived,
+		FDsSent:       s.FDsSent - before.FDsSent,
+		OpenRecvFDs:   s.OpenRecvFDs - before.OpenRecvFDs,
+		LiveObjects:   s.LiveObjects - before.LiveObjects,
+	}
+}
+
+func TestStats(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	flushPolicy = flushManual
+	conn, m := startMockCompositor(t, nil)
+	obj := regObj(objCustom)
+	request := func(t *testing.T, opcode uint16, args ...uint32) {
+		buf := makeMsgBuf(obj, opcode, uint32(len(args))*WORD_SIZE)
+		for _, arg := range args {
+			buf = binary.LittleEndian.AppendUint32(buf, arg)
+		}
+		if err := write(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := func(t *testing.T) {
+		id, _, body, err := read(conn)
+		releaseBody(body)
+		if err != nil || id != obj {
+			t.Fatalf("read %d, %v, want an event of %d", id, err, obj)
+		}
+	}
+	pipe := func(t *testing.T) *os.File {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+
+	for _, tc := range []struct {
+		name string
+		do   func(t *testing.T)
+		want connStats
+	}{
+		{
+			name: "request",
+			do: func(t *testing.T) {
+				request(t, 0, 1)
+				flush(conn)
+			},
+			want: connStats{MsgsSent: 1, BytesSent: 12},
+		},
+		{
+			name: "requests flushed together",
+			do: func(t *testing.T) {
+				request(t, 0)
+				request(t, 1, 1, 2)
+				flush(conn)
+			},
+			want: connStats{MsgsSent: 2, BytesSent: 24},
+		},
+		{
+			name: "request passing an fd",
+			do: func(t *testing.T) {
+				err := writeFD(conn, makeMsgBuf(obj, 2, 0), int(pipe(t).Fd()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				flush(conn)
+			},
+			want: connStats{MsgsSent: 1, BytesSent: 8, FDsSent: 1},
+		},
+		{
+			name: "event",
+			do: func(t *testing.T) {
+				m.emit(obj, 0, 1, 2, 3)
+				event(t)
+			},
+			want: connStats{MsgsReceived: 1, BytesReceived: 20},
+		},
+		{
+			name: "event passing an fd",
+			do: func(t *testing.T) {
+				m.mu.Lock()
+				msg := binary.LittleEndian.AppendUint32(nil, obj)
+				msg = binary.LittleEndian.AppendUint32(msg, HEADER_SIZE<<16)
+				_, _, err := m.conn.WriteMsgUnix(msg, unix.UnixRights(int(pipe(t).Fd())), nil)
+				m.mu.Unlock()
+				if err != nil {
+					t.Fatal(err)
+				}
+				event(t)
+				fd := takeFD(obj)
+				t.Cleanup(func() { closeRecvFD(fd) })
+			},
+			want: connStats{MsgsReceived: 1, BytesReceived: 8, FDsReceived: 1, OpenRecvFDs: 1},
+		},
+		{
+			name: "object",
+			do: func(t *testing.T) {
+				regObj(objWLRegion)
+			},
+			want: connStats{LiveObjects: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := stats()
+			tc.do(t)
+			if got := stats().sub(before); got != tc.want {
+				t.Errorf("counters went up by %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// captureLogs sends slog's output to the returned buffer for the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	return &buf
+}
+
+func TestCheckObjLeaks(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		total, perType int
+		// callbacks and buffers are leaked in turn, the display is live too.
+		callbacks, buffers int
+		// warnings are the number of each warning logged.
+		tableWarnings, typeWarnings int
+	}{
+		{"under both", 20, 10, 9, 9, 0, 0},
+		{"one type over, warned once", 100, 10, 15, 9, 0, 1},
+		{"both types over", 100, 10, 10, 10, 0, 2},
+		{"total over, warned once", 15, 100, 8, 12, 1, 0},
+		{"disabled", 0, 0, 50, 50, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetTestState(t)
+			t.Cleanup(resetObjects)
+			logs := captureLogs(t)
+			savedTotal, savedType := objLeakThreshold, objTypeLeakThreshold
+			objLeakThreshold, objTypeLeakThreshold = tc.total, tc.perType
+			reset := func() {
+				objLeakWarned = false
+				clear(objTypeLeakWarned)
+			}
+			reset()
+			t.Cleanup(func() {
+				objLeakThreshold, objTypeLeakThreshold = savedTotal, savedType
+				reset()
+			})
+
+			for range tc.callbacks {
+				regObj(objWLCallback)
+			}
+			for range tc.buffers {
+				regObj(objWLBuffer)
+			}
+			out := logs.String()
+			if n := strings.Count(out, "object table filling up"); n != tc.tableWarnings {
+				t.Errorf("got %d object table warnings, want %d", n, tc.tableWarnings)
+			}
+			if n := strings.Count(out, "objects of one type piling up"); n != tc.typeWarnings {
+				t.Errorf("got %d warnings of one type piling up, want %d", n, tc.typeWarnings)
+			}
+		})
+	}
+}
+
+func TestCheckObjLeaksWarnsAgainAfterDropping(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(resetObjects)
+	logs := captureLogs(t)
+	saved := objTypeLeakThreshold
+	objTypeLeakThreshold = 3
+	t.Cleanup(func() {
+		objTypeLeakThreshold = saved
+		clear(objTypeLeakWarned)
+	})
+	var ids []uint32
+	for range 3 {
+		ids = append(ids, regObj(objWLCallback))
+	}
+	// Deleting them all, then leaking again.
+	objMu.Lock()
+	for _, id := range ids {
+		objects[id] = objNone
+	}
+	objMu.Unlock()
+	for range 3 {
+		regObj(objWLCallback)
+	}
+	if n := strings.Count(logs.String(), "objects of one type piling up"); n != 2 {
+		t.Errorf("got %d warnings, want one each time the threshold is crossed", n)
+	}
+}
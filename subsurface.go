@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// createSubsurface makes surface a subsurface of parent, positioned relative
+// to it and committed in sync with it by default.
+func createSubsurface(conn *net.UnixConn, surface, parent uint32) (id uint32, err error) {
+	err = requireGlobal(WLSubcompositorID, "wl_subcompositor")
+	if err != nil {
+		return 0, err
+	}
+	err = assignRole(surface, roleSubsurface)
+	if err != nil {
+		return 0, err
+	}
+	buf := makeMsgBuf(WLSubcompositorID, 1, WORD_SIZE*3)
+	id = regChildObj(objWLSubsurface, WLSubcompositorID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	buf = binary.LittleEndian.AppendUint32(buf, surface)
+	buf = binary.LittleEndian.AppendUint32(buf, parent)
+	return id, write(conn, buf)
+}
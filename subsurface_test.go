@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestCreateSubsurface(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		subcompositor bool
+		wantErr       error
+		wantRole      surfaceRole
+	}{
+		{"bound", true, nil, roleSubsurface},
+		{"not advertised", false, errUnsupportedGlobal, roleNone},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resetTestState(t)
+			flushPolicy = flushManual
+			if tt.subcompositor {
+				WLSubcompositorID = regObj(objWLSubcompositor)
+			}
+			t.Cleanup(func() { WLSubcompositorID = 0 })
+			parent, surface := regObj(objWLSurface), regObj(objWLSurface)
+			conn, _ := newConnPair(t)
+			live := stats().LiveObjects
+
+			id, err := createSubsurface(conn, surface, parent)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != tt.subcompositor {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if got := roleOf(surface); got != tt.wantRole {
+				t.Errorf("got role %v, want %v", got, tt.wantRole)
+			}
+			outMu.Lock()
+			out := string(outBuf)
+			outMu.Unlock()
+			if !tt.subcompositor {
+				if id != 0 || out != "" || stats().LiveObjects != live {
+					t.Errorf("got id %d, %d objects and %q queued, want nothing", id, stats().LiveObjects-live, out)
+				}
+				return
+			}
+			want := makeMsgBuf(WLSubcompositorID, 1, WORD_SIZE*3)
+			want = binary.LittleEndian.AppendUint32(want, id)
+			want = binary.LittleEndian.AppendUint32(want, surface)
+			want = binary.LittleEndian.AppendUint32(want, parent)
+			if out != string(want) || objTypeOf(id) != objWLSubsurface {
+				t.Errorf("got %s and % x queued, want a wl_subsurface and % x", objTypeOf(id), out, want)
+			}
+		})
+	}
+}
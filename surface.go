@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+)
+
+// wl_output::transform
+const (
+	WLOutputTransformNormal = iota
+	WLOutputTransform90
+	WLOutputTransform180
+	WLOutputTransform270
+	WLOutputTransformFlipped
+	WLOutputTransformFlipped90
+	WLOutputTransformFlipped180
+	WLOutputTransformFlipped270
+)
+
+// surfaceState is the wl_surface state that's staged by the setters here and
+// sent right before the commit that applies it.
+type surfaceState struct {
+	transform uint32
+	scale     int32
+	// tearingHint is a wp_tearing_control_v1::presentation_hint.
+	tearingHint uint32
+	// alpha is the wp_alpha_modifier_surface_v1 multiplier, math.MaxUint32
+	// being fully opaque.
+	alpha uint32
+	// opaque is the opaque region, in surface coordinates, empty for
+	// none.
+	opaque rect
+
+	// The rest only applies to the next commit.
+
+	// detach attaches a null buffer, hiding the surface.
+	detach bool
+	// fifoBarrier and fifoWait are the wp_fifo_v1 set_barrier and
+	// wait_barrier requests.
+	fifoBarrier, fifoWait bool
+	// commitTime is the earliest presentation time for the commit, in
+	// nanoseconds of the presentation clock, 0 for none.
+	commitTime uint64
+	// feedback asks wp_presentation for feedback on the commit.
+	feedback bool
+}
+
+var (
+	pendingSurface = surfaceState{scale: 1, alpha: math.MaxUint32}
+	currentSurface = pendingSurface
+)
+
+// size returns the surface size in surface coordinates, i.e. the buffer size
+// with the width and height swapped if the buffer is rotated by 90 or 270
+// degrees, divided by the buffer scale.
+func (s surfaceState) size() (w, h uint32) {
+	w, h = bufWidth, bufHeight
+	if s.transform%2 == 1 {
+		w, h = h, w
+	}
+	return w / uint32(s.scale), h / uint32(s.scale)
+}
+
+// setBufferTransform stages the transform the buffer content is already
+// rotated by, so the compositor can skip rotating it for an output with the
+// same transform. Surfaces older than set_buffer_transform can't take one,
+// nothing is staged then and the content should be drawn unrotated.
+func setBufferTransform(transform uint32) error {
+	err := requireVersion(WLSurfaceID, 2, "set_buffer_transform")
+	if err != nil {
+		return err
+	}
+	pendingSurface.transform = transform
+	return nil
+}
+
+// setBufferScale stages the scale the buffer is rendered at, which the
+// buffer size has to be a multiple of.
+func setBufferScale(scale int32) error {
+	s := pendingSurface
+	s.scale = scale
+	err := s.validate()
+	if err != nil {
+		return err
+	}
+	pendingSurface.scale = scale
+	return nil
+}
+
+// validate checks s can be committed with the current buffer, catching what
+// the compositor would otherwise answer with a fatal protocol error.
+func (s surfaceState) validate() error {
+	if s.scale < 1 {
+		return errors.New("wl_surface: buffer scale " + strconv.Itoa(int(s.scale)) + " isn't positive")
+	}
+	if !s.detach && (bufWidth%uint32(s.scale) != 0 || bufHeight%uint32(s.scale) != 0) {
+		return errors.New("wl_surface: buffer size " + strconv.Itoa(int(bufWidth)) + "x" + strconv.Itoa(int(bufHeight)) +
+			" isn't a multiple of the buffer scale " + strconv.Itoa(int(s.scale)))
+	}
+	if s.transform != currentSurface.transform {
+		err := requireVersion(WLSurfaceID, 2, "set_buffer_transform")
+		if err != nil {
+			return err
+		}
+	}
+	if s.scale != currentSurface.scale {
+		err := requireVersion(WLSurfaceID, 3, "set_buffer_scale")
+		if err != nil {
+			return err
+		}
+	}
+	if len(pendingDamage) > 0 {
+		return requireVersion(WLSurfaceID, 4, "damage_buffer")
+	}
+	return nil
+}
+
+// detachBuffer stages a null buffer attach, hiding the surface without
+// destroying it once committed. Attaching a buffer and committing maps it
+// again.
+func detachBuffer() {
+	pendingSurface.detach = true
+}
+
+// commitState commits the staged state alone, e.g. to apply a scale or
+// transform change. No buffer is attached: the last one attached stays the
+// surface's content across commits until another attach, so it's shown again
+// with the new state. Unlike mustCommit an invalid state is returned as an
+// error, before anything is sent.
+func commitState(conn *net.UnixConn) error {
+	err := pendingSurface.validate()
+	if err != nil {
+		return err
+	}
+	mustCommit(conn)
+	return nil
+}
+
+// mustApplySurfaceState acks the pending configure and sends the staged
+// state that changed since the last commit.
+func mustApplySurfaceState(conn *net.UnixConn) {
+	err := pendingSurface.validate()
+	if err != nil {
+		panic(err)
+	}
+	configureMu.Lock()
+	serial := pendingConfigure
+	pendingConfigure = 0
+	configureMu.Unlock()
+	if serial != 0 {
+		mustAckConfigure(conn, serial)
+	}
+	if pendingSurface.transform != currentSurface.transform {
+		buf := makeMsgBuf(WLSurfaceID, 7, WORD_SIZE)
+		buf = binary.LittleEndian.AppendUint32(buf, pendingSurface.transform)
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if pendingSurface.scale != currentSurface.scale {
+		buf := makeMsgBuf(WLSurfaceID, 8, WORD_SIZE)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(pendingSurface.scale))
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if pendingSurface.tearingHint != currentSurface.tearingHint {
+		mustSetPresentationHint(conn, pendingSurface.tearingHint)
+	}
+	if pendingSurface.alpha != currentSurface.alpha {
+		mustSetAlphaMultiplier(conn, pendingSurface.alpha)
+	}
+	if autoOpaque {
+		pendingSurface.opaque = pendingSurface.autoOpaqueRegion()
+	}
+	if pendingSurface.opaque != currentSurface.opaque {
+		mustSetOpaqueRegion(conn, pendingSurface.opaque)
+	}
+	if pendingSurface.detach {
+		mustAttachNull(conn)
+	}
+	if pendingSurface.fifoBarrier || pendingSurface.fifoWait {
+		mustSendFifo(conn, pendingSurface.fifoBarrier, pendingSurface.fifoWait)
+	}
+	if pendingSurface.commitTime != 0 {
+		mustSetCommitTimestamp(conn, pendingSurface.commitTime)
+	}
+	if pendingSurface.feedback {
+		mustRequestFeedback(conn)
+	}
+	mustSendDamage(conn)
+	currentSurface = pendingSurface
+	pendingSurface.detach = false
+	pendingSurface.fifoBarrier, pendingSurface.fifoWait = false, false
+	pendingSurface.commitTime = 0
+	pendingSurface.feedback = false
+}
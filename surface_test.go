@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetScaleSkippedBeforeSetBufferScale(t *testing.T) {
+	useSurfaceVersion(t, 2, 1)
+	if ev := mustSetScale(nil, 2); ev != nil {
+		t.Errorf("got %#v for a v2 surface, want nil", ev)
+	}
+	if pendingSurface.scale != 1 || bufWidth != winWidth {
+		t.Errorf("scale %d with a %dx%d buffer staged for a v2 surface", pendingSurface.scale, bufWidth, bufHeight)
+	}
+	if err := pendingSurface.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetScale(t *testing.T) {
+	conn, _ := newMockSurface(t, nil)
+	ev, ok := mustSetScale(conn, 2).(WLSurfaceScale)
+	if !ok || ev.Scale != 2 {
+		t.Fatalf("got %#v, want WLSurfaceScale{Scale: 2}", ev)
+	}
+	if bufWidth != 2*winWidth || bufHeight != 2*winHeight {
+		t.Errorf("got a %dx%d buffer, want %dx%d", bufWidth, bufHeight, 2*winWidth, 2*winHeight)
+	}
+	if err := pendingSurface.validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetBufferTransformNeedsV2(t *testing.T) {
+	useSurfaceVersion(t, 1, 1)
+	err := setBufferTransform(WLOutputTransform90)
+	if !errors.Is(err, errVersionTooOld) {
+		t.Errorf("got %v, want %v", err, errVersionTooOld)
+	}
+	if pendingSurface.transform != WLOutputTransformNormal {
+		t.Error("transform staged for a v1 surface")
+	}
+
+	useSurfaceVersion(t, 2, 1)
+	err = setBufferTransform(WLOutputTransform90)
+	if err != nil || pendingSurface.transform != WLOutputTransform90 {
+		t.Errorf("got %v with transform %d staged, want it staged", err, pendingSurface.transform)
+	}
+}
+
+func TestBufferTransform(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		transform uint32
+		// wire is the wl_output::transform value set_buffer_transform sends.
+		wire  uint32
+		scale int32
+		// w and h are the surface size of a 200x100 buffer.
+		w, h uint32
+	}{
+		{"normal", WLOutputTransformNormal, 0, 1, 200, 100},
+		{"90", WLOutputTransform90, 1, 1, 100, 200},
+		{"180", WLOutputTransform180, 2, 1, 200, 100},
+		{"270", WLOutputTransform270, 3, 1, 100, 200},
+		{"flipped", WLOutputTransformFlipped, 4, 1, 200, 100},
+		{"flipped 90", WLOutputTransformFlipped90, 5, 1, 100, 200},
+		{"flipped 180", WLOutputTransformFlipped180, 6, 1, 200, 100},
+		{"flipped 270", WLOutputTransformFlipped270, 7, 1, 100, 200},
+		{"90 at scale 2", WLOutputTransform90, 1, 2, 50, 100},
+		{"270 at scale 2", WLOutputTransform270, 3, 2, 50, 100},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// Surface coordinates, damage included, are rotated from the
+			// buffer's.
+			useSurfaceVersion(t, 3, tt.scale)
+			bufWidth, bufHeight = 200, 100
+			err := setBufferTransform(tt.transform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w, h := pendingSurface.size(); w != tt.w || h != tt.h {
+				t.Errorf("got a %dx%d surface, want %dx%d", w, h, tt.w, tt.h)
+			}
+			if r, _ := fullDamage(); r != (rect{0, 0, int32(tt.w), int32(tt.h)}) {
+				t.Errorf("got full damage %v, want %dx%d", r, tt.w, tt.h)
+			}
+
+			var sent atomic.Int64
+			sent.Store(-1)
+			conn, _ := newMockSurface(t, func(id, opcode uint32, body []byte) {
+				if objTypeOf(id) == objWLSurface && opcode == 7 {
+					sent.Store(int64(binary.LittleEndian.Uint32(body)))
+				}
+			})
+			err = setBufferTransform(tt.transform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustCommit(conn)
+			mustRoundtrip(t, conn)
+			want := int64(tt.wire)
+			if tt.transform == WLOutputTransformNormal {
+				// Already the surface's, not sent again.
+				want = -1
+			}
+			if got := sent.Load(); got != want {
+				t.Errorf("sent set_buffer_transform %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCommitState(t *testing.T) {
+	var log requestLog
+	conn, _ := newMockSurface(t, log.record)
+	pendingSurface.scale = 0
+	err := commitState(conn)
+	if err == nil {
+		t.Fatal("committed a scale of 0")
+	}
+	mustRoundtrip(t, conn)
+	if log.has(WLSurfaceID, 6) {
+		t.Fatal("invalid state committed")
+	}
+
+	pendingSurface.scale = 2
+	err = commitState(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustRoundtrip(t, conn)
+	if !log.has(WLSurfaceID, 8) || !log.has(WLSurfaceID, 6) {
+		t.Error("scale not committed")
+	}
+	if log.has(WLSurfaceID, 1) {
+		t.Error("buffer attached by a state-only commit")
+	}
+}
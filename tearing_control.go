@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// wp_tearing_control_v1::presentation_hint
+const (
+	WPTearingControlPresentationHintVsync = 0
+	WPTearingControlPresentationHintAsync = 1
+)
+
+// setTearingHint stages whether the surface's content may be presented as soon
+// as it's committed, tearing included, instead of waiting for vblank. It's
+// only a hint and compositors generally only honor it for fullscreen surfaces
+// they can scan out directly, anything else stays vsynced. Ignored if the
+// compositor doesn't support wp_tearing_control_manager_v1.
+func setTearingHint(async bool) {
+	if WPTearingControlManagerID == 0 {
+		return
+	}
+	pendingSurface.tearingHint = WPTearingControlPresentationHintVsync
+	if async {
+		pendingSurface.tearingHint = WPTearingControlPresentationHintAsync
+	}
+}
+
+func mustSetPresentationHint(conn *net.UnixConn, hint uint32) {
+	if WPTearingControlID == 0 {
+		buf := makeMsgBuf(WPTearingControlManagerID, 1, WORD_SIZE*2)
+		WPTearingControlID = regChildObj(objWPTearingControl, WPTearingControlManagerID)
+		buf = binary.LittleEndian.AppendUint32(buf, WPTearingControlID)
+		buf = binary.LittleEndian.AppendUint32(buf, WLSurfaceID)
+		err := write(conn, buf)
+		if err != nil {
+			panic(err)
+		}
+	}
+	buf := makeMsgBuf(WPTearingControlID, 0, WORD_SIZE)
+	buf = binary.LittleEndian.AppendUint32(buf, hint)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// titleInterval is the least time between two set_title requests, titles
+// set faster than that (a progress percentage, a clock) only send the
+// latest once the interval is up.
+var titleInterval = 100 * time.Millisecond
+
+var (
+	titleMu sync.Mutex
+	// title is the last title sent, pendingTitle the one waiting for the
+	// interval to be up if titleWaiting.
+	title, pendingTitle string
+	titleSentAt         time.Time
+	titleWaiting        bool
+)
+
+var errInvalidTitle = errors.New("xdg_toplevel: title isn't valid UTF-8")
+
+// setTitle sets the toplevel's title, at any time: the compositor applies
+// it right away, it isn't double-buffered state waiting for a commit.
+func setTitle(conn *net.UnixConn, t string) error {
+	if XDGTopLevelID == 0 {
+		return errors.New("xdg_toplevel: no toplevel to set the title of")
+	}
+	if !utf8.ValidString(t) {
+		return errInvalidTitle
+	}
+	titleMu.Lock()
+	defer titleMu.Unlock()
+	if titleWaiting {
+		pendingTitle = t
+		return nil
+	}
+	if t == title {
+		return nil
+	}
+	if wait := titleInterval - clk.Now().Sub(titleSentAt); wait > 0 {
+		pendingTitle = t
+		titleWaiting = true
+		go sendPendingTitle(conn, wait)
+		return nil
+	}
+	return sendTitleLocked(conn, t)
+}
+
+func sendPendingTitle(conn *net.UnixConn, wait time.Duration) {
+	<-clk.After(wait)
+	titleMu.Lock()
+	defer titleMu.Unlock()
+	titleWaiting = false
+	if pendingTitle == title {
+		return
+	}
+	err := sendTitleLocked(conn, pendingTitle)
+	if err == nil {
+		err = flush(conn)
+	}
+	if err != nil {
+		slog.Error("xdg_toplevel set_title err", "err", err)
+	}
+}
+
+// sendTitleLocked sends set_title, titleMu must be held.
+func sendTitleLocked(conn *net.UnixConn, t string) error {
+	buf := makeMsgBuf(XDGTopLevelID, 2, strSize(t))
+	buf = appendStr(buf, t)
+	err := write(conn, buf)
+	if err != nil {
+		return err
+	}
+	title, titleSentAt = t, clk.Now()
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errNoToplevelIcon = unsupportedGlobalErr("xdg_toplevel_icon_manager_v1")
+
+	errIconInvalidBuffer = errors.New("xdg_toplevel_icon_v1: buffer isn't a square shm argb8888 buffer")
+	errIconImmutable     = errors.New("xdg_toplevel_icon_v1: icon changed after it was set on a toplevel")
+	errIconNoBuffer      = errors.New("xdg_toplevel_icon_v1: buffer destroyed while the icon still uses it")
+)
+
+var (
+	// iconSizes are the icon sizes the compositor prefers, complete once
+	// iconSizesDone. Others are scaled.
+	iconSizes     []int32
+	iconSizesDone bool
+)
+
+func handleXDGToplevelIconManagerEvent(opcode uint32, body []byte) {
+	switch opcode {
+	case 0: // icon_size
+		if iconSizesDone {
+			iconSizes, iconSizesDone = nil, false
+		}
+		iconSizes = append(iconSizes, int32(binary.LittleEndian.Uint32(body)))
+	case 1: // done
+		iconSizesDone = true
+	}
+}
+
+// toplevelIcon is the icon set on XDGTopLevelID. A set icon can't be
+// changed, so a new one is created each time, and its buffer has to live as
+// long as it does.
+type toplevelIcon struct {
+	id, buffer, pool uint32
+	f                *os.File
+	mem              []byte
+}
+
+var curIcon toplevelIcon
+
+// setIconName sets the toplevel's icon to the named icon from the icon
+// theme, "" unsetting it.
+func setIconName(conn *net.UnixConn, name string) error {
+	if name == "" {
+		return setIcon(conn, nil)
+	}
+	err := requireGlobal(XDGToplevelIconManagerID, "xdg_toplevel_icon_manager_v1")
+	if err != nil {
+		return err
+	}
+	icon := toplevelIcon{id: mustCreateIcon(conn)}
+	buf := makeMsgBuf(icon.id, 1, strSize(name)) // set_name
+	buf = appendStr(buf, name)
+	err = write(conn, buf)
+	if err != nil {
+		return err
+	}
+	return applyIcon(conn, icon)
+}
+
+// setIcon sets the toplevel's icon to img, which has to be square. nil
+// unsets it.
+func setIcon(conn *net.UnixConn, img image.Image) error {
+	err := requireGlobal(XDGToplevelIconManagerID, "xdg_toplevel_icon_manager_v1")
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return applyIcon(conn, toplevelIcon{})
+	}
+	bounds := img.Bounds()
+	size := int32(bounds.Dx())
+	if size <= 0 || bounds.Dx() != bounds.Dy() {
+		return errIconInvalidBuffer
+	}
+	var icon toplevelIcon
+	icon.pool, icon.f, icon.mem, err = createPool(conn, int64(size)*int64(size)*4)
+	if err != nil {
+		return err
+	}
+	putImage(icon.mem, int(size)*4, img)
+	icon.buffer, err = createBuffer(conn, icon.pool, len(icon.mem), shmBuffer{
+		width:  size,
+		height: size,
+		stride: size * 4,
+		format: WLShmFormatARGB8888,
+	})
+	if err != nil {
+		freeIcon(conn, icon)
+		return err
+	}
+	icon.id = mustCreateIcon(conn)
+	buf := makeMsgBuf(icon.id, 2, WORD_SIZE*2) // add_buffer
+	buf = binary.LittleEndian.AppendUint32(buf, icon.buffer)
+	buf = binary.LittleEndian.AppendUint32(buf, 1) // scale
+	err = write(conn, buf)
+	if err != nil {
+		freeIcon(conn, icon)
+		return err
+	}
+	return applyIcon(conn, icon)
+}
+
+func mustCreateIcon(conn *net.UnixConn) (id uint32) {
+	buf := makeMsgBuf(XDGToplevelIconManagerID, 1, WORD_SIZE)
+	id = regChildObj(objXDGToplevelIcon, XDGToplevelIconManagerID)
+	buf = binary.LittleEndian.AppendUint32(buf, id)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// applyIcon sets icon on the toplevel, 0 unsetting it, and frees the
+// previous one.
+func applyIcon(conn *net.UnixConn, icon toplevelIcon) error {
+	buf := makeMsgBuf(XDGToplevelIconManagerID, 2, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, XDGTopLevelID)
+	buf = binary.LittleEndian.AppendUint32(buf, icon.id) // 0 is null
+	err := write(conn, buf)
+	if err != nil {
+		freeIcon(conn, icon)
+		return err
+	}
+	freeIcon(conn, curIcon)
+	curIcon = icon
+	return nil
+}
+
+// freeIcon destroys icon, then its buffer and pool.
+func freeIcon(conn *net.UnixConn, icon toplevelIcon) {
+	var msgs [][]byte
+	if icon.id != 0 {
+		msgs = append(msgs, makeMsgBuf(icon.id, 0, 0))
+	}
+	if icon.buffer != 0 {
+		msgs = append(msgs, makeMsgBuf(icon.buffer, 0, 0))
+	}
+	if icon.pool != 0 {
+		msgs = append(msgs, makeMsgBuf(icon.pool, 1, 0))
+	}
+	for _, msg := range msgs {
+		err := write(conn, msg)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if icon.mem != nil {
+		unix.Munmap(icon.mem)
+		icon.f.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// wl_keyboard::keymap_format
+const (
+	WLKeyboardKeymapFormatNoKeymap = 0
+	WLKeyboardKeymapFormatXKBV1    = 1
+)
+
+// wl_keyboard::key_state
+const (
+	WLKeyboardKeyStateReleased = 0
+	WLKeyboardKeyStatePressed  = 1
+)
+
+var errVirtualKeymapNotSet = errors.New("zwp_virtual_keyboard_v1: keymap must be set before sending keys")
+
+var virtualKeyboardKeymapSet bool
+
+// virtualInputEpoch is the base for the millisecond timestamps sent with
+// synthesized input, set by the first one.
+var virtualInputEpoch time.Time
+
+func virtualInputTime() uint32 {
+	now := clk.Now()
+	if virtualInputEpoch.IsZero() {
+		virtualInputEpoch = now
+	}
+	return uint32(now.Sub(virtualInputEpoch).Milliseconds())
+}
+
+// mustCreateVirtualKeyboard creates a virtual keyboard on seat. Synthesizing
+// input is privileged, compositors may answer with the unauthorized error
+// unless the client was explicitly allowed to use the manager.
+func mustCreateVirtualKeyboard(conn *net.UnixConn, seat uint32) {
+	buf := makeMsgBuf(ZWPVirtualKeyboardManagerID, 0, WORD_SIZE*2)
+	ZWPVirtualKeyboardID = regChildObj(objZWPVirtualKeyboard, ZWPVirtualKeyboardManagerID)
+	buf = binary.LittleEndian.AppendUint32(buf, seat)
+	buf = binary.LittleEndian.AppendUint32(buf, ZWPVirtualKeyboardID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// setVirtualKeymap uploads an XKB v1 keymap (text form, without the trailing
+// NUL) for the virtual keyboard. Keycodes sent afterwards are interpreted with
+// it.
+func setVirtualKeymap(conn *net.UnixConn, keymap []byte) error {
+	data := append(keymap[:len(keymap):len(keymap)], 0)
+	f, err := writeTempFile("zwp_virtual_keyboard_keymap", data)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := makeMsgBuf(ZWPVirtualKeyboardID, 0, WORD_SIZE*2)
+	buf = binary.LittleEndian.AppendUint32(buf, WLKeyboardKeymapFormatXKBV1)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	err = writeFD(conn, buf, int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	virtualKeyboardKeymapSet = true
+	return nil
+}
+
+// sendVirtualKey sends a key press or release, key is an evdev keycode as in
+// linux/input-event-codes.h.
+func sendVirtualKey(conn *net.UnixConn, key, state uint32) error {
+	if !virtualKeyboardKeymapSet {
+		return errVirtualKeymapNotSet
+	}
+	buf := makeMsgBuf(ZWPVirtualKeyboardID, 1, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, virtualInputTime())
+	buf = binary.LittleEndian.AppendUint32(buf, key)
+	buf = binary.LittleEndian.AppendUint32(buf, state)
+	err := write(conn, buf)
+	return err
+}
+
+func sendVirtualModifiers(conn *net.UnixConn, depressed, latched, locked, group uint32) error {
+	if !virtualKeyboardKeymapSet {
+		return errVirtualKeymapNotSet
+	}
+	buf := makeMsgBuf(ZWPVirtualKeyboardID, 2, WORD_SIZE*4)
+	buf = binary.LittleEndian.AppendUint32(buf, depressed)
+	buf = binary.LittleEndian.AppendUint32(buf, latched)
+	buf = binary.LittleEndian.AppendUint32(buf, locked)
+	buf = binary.LittleEndian.AppendUint32(buf, group)
+	err := write(conn, buf)
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Buttons from linux/input-event-codes.h.
+const (
+	BTN_LEFT   = 0x110
+	BTN_RIGHT  = 0x111
+	BTN_MIDDLE = 0x112
+)
+
+// wl_pointer::button_state
+const (
+	WLPointerButtonStateReleased = 0
+	WLPointerButtonStatePressed  = 1
+)
+
+// wl_pointer::axis
+const (
+	WLPointerAxisVerticalScroll   = 0
+	WLPointerAxisHorizontalScroll = 1
+)
+
+// mustCreateVirtualPointer creates a virtual pointer, seat may be 0 to let the
+// compositor pick one.
+func mustCreateVirtualPointer(conn *net.UnixConn, seat uint32) {
+	buf := makeMsgBuf(ZWLRVirtualPointerManagerID, 0, WORD_SIZE*2)
+	ZWLRVirtualPointerID = regChildObj(objZWLRVirtualPointer, ZWLRVirtualPointerManagerID)
+	buf = binary.LittleEndian.AppendUint32(buf, seat)
+	buf = binary.LittleEndian.AppendUint32(buf, ZWLRVirtualPointerID)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// The mustMovePointer/mustClickPointer/mustScrollPointer helpers each send a
+// single pointer event followed by a frame. To group several events into one
+// frame use the mustVirtualPointer* requests and finish with
+// mustVirtualPointerFrame.
+
+func mustMovePointer(conn *net.UnixConn, dx, dy float64) {
+	mustVirtualPointerMotion(conn, dx, dy)
+	mustVirtualPointerFrame(conn)
+}
+
+// mustMovePointerAbsolute moves the pointer to x,y in a x_extent by y_extent
+// coordinate space mapped onto the output layout.
+func mustMovePointerAbsolute(conn *net.UnixConn, x, y, xExtent, yExtent uint32) {
+	mustVirtualPointerMotionAbsolute(conn, x, y, xExtent, yExtent)
+	mustVirtualPointerFrame(conn)
+}
+
+func mustClickPointer(conn *net.UnixConn, button, state uint32) {
+	mustVirtualPointerButton(conn, button, state)
+	mustVirtualPointerFrame(conn)
+}
+
+func mustScrollPointer(conn *net.UnixConn, axis uint32, value float64) {
+	mustVirtualPointerAxis(conn, axis, value)
+	mustVirtualPointerFrame(conn)
+}
+
+func mustVirtualPointerMotion(conn *net.UnixConn, dx, dy float64) {
+	buf := makeMsgBuf(ZWLRVirtualPointerID, 0, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, virtualInputTime())
+	buf = binary.LittleEndian.AppendUint32(buf, toFixed(dx))
+	buf = binary.LittleEndian.AppendUint32(buf, toFixed(dy))
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustVirtualPointerMotionAbsolute(conn *net.UnixConn, x, y, xExtent, yExtent uint32) {
+	buf := makeMsgBuf(ZWLRVirtualPointerID, 1, WORD_SIZE*5)
+	buf = binary.LittleEndian.AppendUint32(buf, virtualInputTime())
+	buf = binary.LittleEndian.AppendUint32(buf, x)
+	buf = binary.LittleEndian.AppendUint32(buf, y)
+	buf = binary.LittleEndian.AppendUint32(buf, xExtent)
+	buf = binary.LittleEndian.AppendUint32(buf, yExtent)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustVirtualPointerButton(conn *net.UnixConn, button, state uint32) {
+	buf := makeMsgBuf(ZWLRVirtualPointerID, 2, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, virtualInputTime())
+	buf = binary.LittleEndian.AppendUint32(buf, button)
+	buf = binary.LittleEndian.AppendUint32(buf, state)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustVirtualPointerAxis(conn *net.UnixConn, axis uint32, value float64) {
+	buf := makeMsgBuf(ZWLRVirtualPointerID, 3, WORD_SIZE*3)
+	buf = binary.LittleEndian.AppendUint32(buf, virtualInputTime())
+	buf = binary.LittleEndian.AppendUint32(buf, axis)
+	buf = binary.LittleEndian.AppendUint32(buf, toFixed(value))
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func mustVirtualPointerFrame(conn *net.UnixConn) {
+	buf := makeMsgBuf(ZWLRVirtualPointerID, 4, 0)
+	err := write(conn, buf)
+	if err != nil {
+		panic(err)
+	}
+}
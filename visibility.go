@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// Compositors stop answering frame callbacks of surfaces nobody can see,
+// minimized, occluded or on another workspace, which is the only sign of it
+// a client gets. A surface whose frame callback has been pending for longer
+// than occlusionTimeout is taken as hidden, and drawing it is wasted work
+// until the callback fires or a configure comes.
+
+// occlusionTimeout is how long a frame callback may stay pending before
+// surfaceVisible reports the surface hidden, a few frames at the lowest
+// refresh rates compositors throttle to.
+var occlusionTimeout = time.Second
+
+// frameRequestedAt is when mustFrame last asked for WLFrameCallbackID,
+// guarded by frameMu.
+var frameRequestedAt time.Time
+
+// surfaceVisible reports whether WLSurfaceID is likely visible, so code
+// drawing on its own schedule rather than on frame callbacks can skip
+// frames nobody would see.
+func surfaceVisible() bool {
+	frameMu.Lock()
+	defer frameMu.Unlock()
+	if WLFrameCallbackID == 0 {
+		return true
+	}
+	return clk.Now().Sub(frameRequestedAt) < occlusionTimeout
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrawStopsWhileHidden(t *testing.T) {
+	var commits, frames atomic.Int32
+	conn, m := newMockSurface(t, func(id, opcode uint32, body []byte) {
+		if objTypeOf(id) != objWLSurface {
+			return
+		}
+		switch opcode {
+		case 3:
+			frames.Add(1)
+		case 6:
+			commits.Add(1)
+		}
+	})
+	fake := newFakeClock(time.Unix(0, 0))
+	clk = fake
+	XDGSurfaceID = regObj(objXDGSurface)
+	m.hidden.Store(true)
+
+	mustDraw(conn)
+	err := roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !surfaceVisible() {
+		t.Fatal("surface hidden right after requesting a frame")
+	}
+	fake.advance(occlusionTimeout)
+	if surfaceVisible() {
+		t.Fatal("surface visible with no frame callback for occlusionTimeout")
+	}
+
+	// Configures still get committed, but nothing asks for more frames.
+	for range 3 {
+		if react(conn, XDGSurfaceConfigure{XDGSurface: XDGSurfaceID}) {
+			t.Fatal("configure quit")
+		}
+	}
+	err = roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := commits.Load(); got != 4 {
+		t.Errorf("got %d commits, want 4", got)
+	}
+	if got := frames.Load(); got != 1 {
+		t.Errorf("got %d frame requests while hidden, want 1", got)
+	}
+
+	m.show()
+	awaitFrameDone(t, conn)
+	if !surfaceVisible() {
+		t.Error("surface hidden after its frame callback was done")
+	}
+	m.hidden.Store(true)
+	react(conn, WLSurfaceFrameDone{})
+	err = roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := frames.Load(); got != 2 {
+		t.Errorf("got %d frame requests once shown, want 2", got)
+	}
+}
+
+func TestReplacedFrameCallbackIgnored(t *testing.T) {
+	conn, m := newMockSurface(t, nil)
+	m.hidden.Store(true)
+	mustFrame(conn)
+	mustFrame(conn)
+	mustCommit(conn)
+	err := roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.show()
+	awaitFrameDone(t, conn)
+	err = roundtrip(context.Background(), conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if framePending() {
+		t.Error("frame still pending after its callback")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import "net"
+
+// pendingWindowWidth and pendingWindowHeight are the size from the last
+// xdg_toplevel::configure, applied once the xdg_surface::configure ending
+// the sequence is handled, by react or awaitConfigure. Guarded by
+// configureMu.
+var (
+	pendingWindowWidth  uint32 = winWidth
+	pendingWindowHeight uint32 = winHeight
+)
+
+// configureSize returns the window size for an xdg_toplevel::configure of
+// width x height. A 0 dimension, like in the 0x0 configure compositors send
+// first, leaves it to the client, which uses its preferred one.
+func configureSize(width, height int32) (w, h uint32) {
+	w, h = winWidth, winHeight
+	if width > 0 {
+		w = uint32(width)
+	}
+	if height > 0 {
+		h = uint32(height)
+	}
+	return w, h
+}
+
+// mustApplyWindowSize resizes the window to the configured size, the buffer
+// following at the current scale. Before the buffer exists, only the size it
+// gets created with is set.
+func mustApplyWindowSize(conn *net.UnixConn) {
+	configureMu.Lock()
+	w, h := pendingWindowWidth, pendingWindowHeight
+	configureMu.Unlock()
+	if w == windowWidth && h == windowHeight {
+		return
+	}
+	windowWidth, windowHeight = w, h
+	scale := uint32(pendingSurface.scale)
+	if WLBufferID == 0 {
+		bufWidth, bufHeight = windowWidth*scale, windowHeight*scale
+		return
+	}
+	mustResizeBuffer(conn, windowWidth*scale, windowHeight*scale)
+}
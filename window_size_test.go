@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestConfigureSize(t *testing.T) {
+	for _, tt := range []struct {
+		width, height int32
+		w, h          uint32
+	}{
+		{0, 0, winWidth, winHeight},
+		{800, 600, 800, 600},
+		{800, 0, 800, winHeight},
+		{0, 600, winWidth, 600},
+		{-1, -1, winWidth, winHeight},
+	} {
+		w, h := configureSize(tt.width, tt.height)
+		if w != tt.w || h != tt.h {
+			t.Errorf("configureSize(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+func TestApplyWindowSizeBeforeBuffer(t *testing.T) {
+	resetTestState(t)
+	t.Cleanup(func() { pendingWindowWidth, pendingWindowHeight = winWidth, winHeight })
+	pendingSurface.scale = 2
+	pendingWindowWidth, pendingWindowHeight = configureSize(800, 600)
+
+	mustApplyWindowSize(nil)
+	if windowWidth != 800 || windowHeight != 600 {
+		t.Errorf("got a window of %dx%d, want 800x600", windowWidth, windowHeight)
+	}
+	if bufWidth != 1600 || bufHeight != 1200 {
+		t.Errorf("got a buffer of %dx%d at scale 2, want 1600x1200", bufWidth, bufHeight)
+	}
+}